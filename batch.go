@@ -0,0 +1,406 @@
+package influxmarshal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// MarshalBatch marshals each element of vs, which must be a slice of structs
+// or pointers to structs, and returns the points. Timestamps are assigned as
+// by MarshalBatchPoints, one WithPrecision unit apart. vs may be a
+// []interface{} holding values of different types, in which case measurement
+// is usually empty so that each element supplies its own, as described for
+// Marshal.
+func MarshalBatch(vs interface{}, measurement string, opts ...Option) ([]influx.Point, error) {
+	var points []influx.Point
+	err := marshalEach(vs, measurement, core.NewOptions(opts), func(i int, p influx.Point) error {
+		points = append(points, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// MarshalBatchPoints marshals each element of vs, which must be a slice of
+// structs or pointers to structs, and returns them as a batch configured by
+// cfg, ready to pass to the Write method of a client.Client. As with
+// MarshalBatch, the elements may be of different types. They are marshaled
+// as by MarshalWithOptions, so each may supply its own timestamp through a
+// member with the "time" option or by implementing Timestamper.
+//
+// Unless WithTime is used, elements without a timestamp are stamped with
+// increasing times one unit of the precision set by WithPrecision, or else
+// that of cfg, apart, so that points of the same series do not overwrite
+// each other on the server. The last element is stamped with time.Now() and
+// the others before it, rather than after, so that no point lands in the
+// future. Duplicate points are handled according to WithDuplicatePolicy.
+func MarshalBatchPoints(vs interface{}, measurement string, cfg client.BatchPointsConfig, opts ...Option) (client.BatchPoints, error) {
+	bp, err := client.NewBatchPoints(cfg)
+	if err != nil {
+		return nil, err
+	}
+	o := core.NewOptions(opts)
+	if o.Precision == 0 {
+		o.Precision = core.PrecisionDuration(cfg.Precision)
+	}
+	set := newPointSet(DuplicatePolicy(o.Duplicates))
+	err = marshalEach(vs, measurement, o, func(i int, p influx.Point) error {
+		if err := set.add(p); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	bp.AddPoints(set.points)
+	return bp, nil
+}
+
+// DuplicatePolicy determines how points with the same measurement, tag set
+// and timestamp within a batch are handled. InfluxDB keeps only the last
+// such point written, merging its fields with those of the points it
+// replaces.
+type DuplicatePolicy int
+
+const (
+	// KeepDuplicates adds every point to the batch, leaving duplicates to
+	// be resolved by the server. It is the default.
+	KeepDuplicates DuplicatePolicy = iota
+	// KeepLast keeps only the last of the duplicate points, in the position
+	// of the first.
+	KeepLast
+	// MergeFields combines the fields of duplicate points into a single
+	// point, with later values replacing earlier ones, as the server would.
+	MergeFields
+	// RejectDuplicates causes a duplicate point to be an error wrapping
+	// ErrDuplicatePoint.
+	RejectDuplicates
+)
+
+// ErrDuplicatePoint is returned when a point duplicates one already in a
+// batch under the RejectDuplicates policy.
+var ErrDuplicatePoint = errors.New("duplicate point")
+
+// pointSet accumulates the points of a batch according to a
+// DuplicatePolicy.
+type pointSet struct {
+	policy DuplicatePolicy
+	points []*client.Point
+	// fields and index are kept when duplicates must be detected: fields
+	// holds the fields of each point, and index the position of each point
+	// by pointKey
+	fields []map[string]interface{}
+	index  map[string]int
+}
+
+func newPointSet(policy DuplicatePolicy) *pointSet {
+	s := &pointSet{policy: policy}
+	if policy != KeepDuplicates {
+		s.index = make(map[string]int)
+	}
+	return s
+}
+
+// add adds p to the set.
+func (s *pointSet) add(p influx.Point) error {
+	if s.policy == KeepDuplicates {
+		cp, err := client.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time)
+		if err != nil {
+			return err
+		}
+		s.points = append(s.points, cp)
+		return nil
+	}
+
+	key := pointKey(p)
+	i, dup := s.index[key]
+	fields := p.Fields
+	if dup {
+		switch s.policy {
+		case RejectDuplicates:
+			return fmt.Errorf("%w: %s at %s", ErrDuplicatePoint, p.Measurement, p.Time.Format(time.RFC3339Nano))
+		case MergeFields:
+			fields = make(map[string]interface{}, len(s.fields[i])+len(p.Fields))
+			for k, v := range s.fields[i] {
+				fields[k] = v
+			}
+			for k, v := range p.Fields {
+				fields[k] = v
+			}
+		}
+	}
+	cp, err := client.NewPoint(p.Measurement, p.Tags, fields, p.Time)
+	if err != nil {
+		return err
+	}
+	if dup {
+		s.points[i], s.fields[i] = cp, fields
+		return nil
+	}
+	s.index[key] = len(s.points)
+	s.points = append(s.points, cp)
+	s.fields = append(s.fields, fields)
+	return nil
+}
+
+// pointKey returns a key identifying the measurement, tag set and time of p.
+func pointKey(p influx.Point) string {
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(p.Measurement)
+	for _, k := range keys {
+		b.WriteByte(0)
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(p.Tags[k])
+	}
+	b.WriteByte(0)
+	b.WriteString(strconv.FormatInt(p.Time.UnixNano(), 10))
+	return b.String()
+}
+
+// marshalEach marshals each element of the slice vs, calling fn with the
+// index and point of each in turn. With WithParallelism, the elements are
+// marshaled concurrently, but fn is still called in order.
+func marshalEach(vs interface{}, measurement string, o *core.Options, fn func(i int, p influx.Point) error) error {
+	sv := reflect.ValueOf(vs)
+	if sv.Kind() != reflect.Slice && sv.Kind() != reflect.Array {
+		return fmt.Errorf("not a slice")
+	}
+	// without WithTime, elements are stamped from base, so that the last
+	// is stamped now
+	var base time.Time
+	if o.Time.IsZero() && sv.Len() > 0 {
+		step := batchStep(o)
+		base = o.Now().Truncate(step).Add(-time.Duration(sv.Len()-1) * step)
+	}
+
+	if o.Parallelism > 1 && sv.Len() > 1 {
+		points, err := marshalParallel(sv, measurement, o, base)
+		if err != nil {
+			return err
+		}
+		for i, p := range points {
+			if err := fn(i, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < sv.Len(); i++ {
+		p, err := marshalElem(sv, i, measurement, o, base)
+		if err != nil {
+			return err
+		}
+		if err := fn(i, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchStep returns the interval between the default timestamps of the
+// elements of a batch: the precision of its timestamps.
+func batchStep(o *core.Options) time.Duration {
+	if o.Precision <= 0 {
+		return time.Nanosecond
+	}
+	return o.Precision
+}
+
+// marshalElem marshals element i of the slice sv. If base is not zero, the
+// element is stamped i batch steps after it, unless it supplies its own
+// timestamp.
+func marshalElem(sv reflect.Value, i int, measurement string, o *core.Options, base time.Time) (influx.Point, error) {
+	if !base.IsZero() {
+		eo := *o
+		eo.Time = base.Add(time.Duration(i) * batchStep(o))
+		o = &eo
+	}
+	v := sv.Index(i).Interface()
+	val, err := core.StructValue(v)
+	if err != nil {
+		return influx.Point{}, fmt.Errorf("element %d: %w", i, err)
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return influx.Point{}, fmt.Errorf("element %d: %w", i, err)
+	}
+	p, err := marshal(v, val, info, measurement, o)
+	if err != nil {
+		return p, fmt.Errorf("element %d: %w", i, err)
+	}
+	return p, nil
+}
+
+// marshalParallel marshals the elements of the slice sv on o.parallelism
+// goroutines, each taking a contiguous range. If more than one element fails,
+// the error for the first is returned.
+func marshalParallel(sv reflect.Value, measurement string, o *core.Options, base time.Time) ([]influx.Point, error) {
+	n := sv.Len()
+	workers := o.Parallelism
+	if workers > n {
+		workers = n
+	}
+	points := make([]influx.Point, n)
+	errs := make([]error, workers)
+	chunk := (n + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := w*chunk, (w+1)*chunk
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				p, err := marshalElem(sv, i, measurement, o, base)
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				points[i] = p
+			}
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// RetentionPolicyer is the interface for your type to provide the retention
+// policy its points are written to by a Batcher.
+type RetentionPolicyer interface {
+	RetentionPolicy() string
+}
+
+// Databaser is the interface for your type to provide the database its
+// points are written to by a Batcher.
+type Databaser interface {
+	Database() string
+}
+
+// BatchKey identifies a batch built by a Batcher.
+type BatchKey struct {
+	Database        string
+	RetentionPolicy string
+	Measurement     string
+}
+
+// Batcher marshals values of any struct type and partitions the points into
+// separate batches by database, retention policy and measurement, so that
+// each can be written with its own client.Write call.
+//
+// The database of a value is given by its Database method if it implements
+// Databaser. Its retention policy is given by its RetentionPolicy method if
+// it implements RetentionPolicyer, and otherwise by the tag on an embedded
+// RetentionPolicy field. Each defaults to that of the BatchPointsConfig the
+// Batcher was created with.
+//
+// Points with the same measurement, tag set and timestamp are handled
+// according to the DuplicatePolicy set by WithDuplicatePolicy.
+//
+// A Batcher is not safe for concurrent use.
+type Batcher struct {
+	cfg     client.BatchPointsConfig
+	opts    *core.Options
+	batches map[BatchKey]*pendingBatch
+}
+
+// NewBatcher returns a Batcher creating batches with the configuration cfg,
+// other than their database and retention policy. The options apply to every
+// value added.
+func NewBatcher(cfg client.BatchPointsConfig, opts ...Option) *Batcher {
+	return &Batcher{
+		cfg:     cfg,
+		opts:    core.NewOptions(opts),
+		batches: make(map[BatchKey]*pendingBatch),
+	}
+}
+
+// Add marshals v, as MarshalWithOptions does, and adds the point to the
+// batch for its database, retention policy and measurement.
+func (b *Batcher) Add(v interface{}, measurement string) error {
+	val, err := core.StructValue(v)
+	if err != nil {
+		return err
+	}
+	info, err := core.CompileType(val.Type(), b.opts)
+	if err != nil {
+		return err
+	}
+	p, err := marshal(v, val, info, measurement, b.opts)
+	if err != nil {
+		return err
+	}
+
+	key := BatchKey{
+		Database:        b.cfg.Database,
+		RetentionPolicy: b.cfg.RetentionPolicy,
+		Measurement:     p.Measurement,
+	}
+	if d, ok := v.(Databaser); ok {
+		key.Database = d.Database()
+	}
+	if r, ok := v.(RetentionPolicyer); ok {
+		key.RetentionPolicy = r.RetentionPolicy()
+	} else if info.RetentionPolicy != "" {
+		key.RetentionPolicy = info.RetentionPolicy
+	}
+
+	pb, ok := b.batches[key]
+	if !ok {
+		cfg := b.cfg
+		cfg.Database, cfg.RetentionPolicy = key.Database, key.RetentionPolicy
+		bp, err := client.NewBatchPoints(cfg)
+		if err != nil {
+			return err
+		}
+		pb = &pendingBatch{bp: bp, set: newPointSet(DuplicatePolicy(b.opts.Duplicates))}
+		b.batches[key] = pb
+	}
+	return pb.set.add(p)
+}
+
+// Batches returns the batches built so far and resets the Batcher, so that
+// it can be reused for the next set of writes.
+func (b *Batcher) Batches() map[BatchKey]client.BatchPoints {
+	batches := make(map[BatchKey]client.BatchPoints, len(b.batches))
+	for key, pb := range b.batches {
+		pb.bp.AddPoints(pb.set.points)
+		batches[key] = pb.bp
+	}
+	b.batches = make(map[BatchKey]*pendingBatch)
+	return batches
+}
+
+// pendingBatch is a batch being built by a Batcher.
+type pendingBatch struct {
+	bp  client.BatchPoints
+	set *pointSet
+}
@@ -0,0 +1,66 @@
+package influxmarshal
+
+import (
+	"testing"
+	"time"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+type batchValue struct {
+	Host  string `influx:"host,tag"`
+	Value int    `influx:"value"`
+}
+
+func TestMarshalBatchDistinctTimes(t *testing.T) {
+	vs := []batchValue{{"a", 1}, {"a", 2}, {"a", 3}}
+	for _, parallelism := range []int{1, 2} {
+		points, err := MarshalBatch(vs, "m", WithParallelism(parallelism))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(points); i++ {
+			if !points[i].Time.After(points[i-1].Time) {
+				t.Fatalf("parallelism %d: point %d at %v, not after %v", parallelism, i, points[i].Time, points[i-1].Time)
+			}
+		}
+	}
+
+	at := time.Unix(100, 0)
+	points, err := MarshalBatch(vs, "m", WithTime(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if !p.Time.Equal(at) {
+			t.Fatalf("got %v, want %v", p.Time, at)
+		}
+	}
+}
+
+func TestMarshalBatchPointsPrecision(t *testing.T) {
+	vs := []batchValue{{"a", 1}, {"a", 2}}
+	bp, err := MarshalBatchPoints(vs, "m", client.BatchPointsConfig{Precision: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := bp.Points()
+	if d := points[1].Time().Sub(points[0].Time()); d != time.Second {
+		t.Fatalf("points %v apart, want 1s", d)
+	}
+}
+
+func TestMarshalBatchPointsNotInFuture(t *testing.T) {
+	now := time.Unix(1000, 500)
+	clock := WithClock(func() time.Time { return now })
+	vs := []batchValue{{"a", 1}, {"a", 2}, {"a", 3}}
+	bp, err := MarshalBatchPoints(vs, "m", client.BatchPointsConfig{Precision: "s"}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range bp.Points() {
+		if want := time.Unix(998+int64(i), 0); !p.Time().Equal(want) {
+			t.Fatalf("point %d at %v, want %v", i, p.Time(), want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+)
+
+// OverflowPolicy determines how a Collector handles a batch that would take
+// its buffered batches beyond the limit set by WithMaxBufferBytes.
+type OverflowPolicy int
+
+const (
+	// BlockOnOverflow holds the batch, and so the encoding of further
+	// values, until enough buffered batches have been received. It is the
+	// default.
+	BlockOnOverflow OverflowPolicy = iota
+	// DropOldest discards the oldest buffered batches to make room for the
+	// batch. Their lines are counted by Dropped.
+	DropOldest
+	// SpillToDisk writes the batch, and those after it, to a temporary file
+	// in the directory set by WithSpillDir. They are delivered in order once
+	// the batches in memory have been received.
+	SpillToDisk
+)
+
+// batchQueue is an io.Writer that queues a copy of each write as a batch,
+// keeping up to max bytes in memory and applying policy beyond that. A batch
+// is always accepted into an empty queue, whatever its size. A LineWriter
+// gives the number of lines in each batch through writeLines, as they cannot
+// be counted once compressed.
+type batchQueue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	max    int
+	policy OverflowPolicy
+	dir    string
+	onDrop func(lines int)
+
+	batches []queuedBatch
+	size    int
+	closed  bool
+
+	// spill holds the batches written by SpillToDisk, each prefixed with
+	// its length, between the offsets rd and wr
+	spill  *os.File
+	rd, wr int64
+}
+
+func newBatchQueue(max int, policy OverflowPolicy, dir string, onDrop func(lines int)) *batchQueue {
+	q := &batchQueue{
+		max:    max,
+		policy: policy,
+		dir:    dir,
+		onDrop: onDrop,
+	}
+	q.cond.L = &q.mu
+	return q
+}
+
+// queuedBatch is a batch held in memory by a batchQueue.
+type queuedBatch struct {
+	b     []byte
+	lines int
+}
+
+func (q *batchQueue) Write(p []byte) (int, error) {
+	if err := q.writeLines(p, bytes.Count(p, []byte{'\n'})); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeLines queues a copy of p, which holds the given number of lines. It
+// returns ErrClosed if the queue is closed, including while it waits for
+// room.
+func (q *batchQueue) writeLines(p []byte, lines int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	full := func() bool {
+		return len(q.batches) > 0 && q.size+len(p) > q.max
+	}
+	switch q.policy {
+	case DropOldest:
+		for full() {
+			q.onDrop(q.shift().lines)
+		}
+	case SpillToDisk:
+		if q.wr > q.rd || full() {
+			if err := q.spillBatch(p); err != nil {
+				return err
+			}
+			q.cond.Broadcast()
+			return nil
+		}
+	default:
+		for full() {
+			q.cond.Wait()
+			if q.closed {
+				return ErrClosed
+			}
+		}
+	}
+	q.batches = append(q.batches, queuedBatch{append([]byte(nil), p...), lines})
+	q.size += len(p)
+	q.cond.Broadcast()
+	return nil
+}
+
+// next removes and returns the oldest batch, waiting for one if the queue is
+// empty. It returns nil once the queue is closed and empty.
+func (q *batchQueue) next() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.batches) == 0 && q.wr == q.rd && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.batches) > 0 {
+		qb := q.shift()
+		q.cond.Broadcast()
+		return qb.b, nil
+	}
+	if q.wr > q.rd {
+		return q.unspill()
+	}
+	if q.spill != nil {
+		q.spill.Close()
+		os.Remove(q.spill.Name())
+		q.spill = nil
+	}
+	return nil, nil
+}
+
+// close causes next to return nil once the queue is empty.
+func (q *batchQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// shift removes and returns the oldest batch in memory. q.mu must be held.
+func (q *batchQueue) shift() queuedBatch {
+	qb := q.batches[0]
+	q.batches[0] = queuedBatch{}
+	q.batches = q.batches[1:]
+	q.size -= len(qb.b)
+	return qb
+}
+
+// spillBatch appends p to the spill file, creating it if necessary. q.mu
+// must be held.
+func (q *batchQueue) spillBatch(p []byte) error {
+	if q.spill == nil {
+		f, err := os.CreateTemp(q.dir, "influxmarshal-*.spill")
+		if err != nil {
+			return err
+		}
+		q.spill = f
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(p)))
+	if _, err := q.spill.WriteAt(hdr[:], q.wr); err != nil {
+		return err
+	}
+	if _, err := q.spill.WriteAt(p, q.wr+int64(len(hdr))); err != nil {
+		return err
+	}
+	q.wr += int64(len(hdr) + len(p))
+	return nil
+}
+
+// unspill removes and returns the oldest batch in the spill file, which is
+// truncated once it has been read in full. q.mu must be held.
+func (q *batchQueue) unspill() ([]byte, error) {
+	b, err := q.readSpilled()
+	if err != nil {
+		// the rest of the file cannot be trusted
+		q.rd, q.wr = 0, 0
+		return nil, err
+	}
+	q.rd += int64(4 + len(b))
+	if q.rd == q.wr {
+		q.rd, q.wr = 0, 0
+		return b, q.spill.Truncate(0)
+	}
+	return b, nil
+}
+
+// readSpilled reads the batch at offset q.rd in the spill file. q.mu must be
+// held.
+func (q *batchQueue) readSpilled() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := q.spill.ReadAt(hdr[:], q.rd); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := q.spill.ReadAt(b, q.rd+int64(len(hdr))); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
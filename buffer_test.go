@@ -0,0 +1,185 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchQueueSpillOrder(t *testing.T) {
+	dir := t.TempDir()
+	q := newBatchQueue(10, SpillToDisk, dir, nil)
+	var want []string
+	for i := 0; i < 10; i++ {
+		b := fmt.Sprintf("m n=%di\n", i)
+		want = append(want, b)
+		if _, err := q.Write([]byte(b)); err != nil {
+			t.Fatal(err)
+		}
+		// receiving some batches part way through leaves later batches
+		// in memory and on disk at once
+		if i == 5 {
+			got, err := q.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want[0] {
+				t.Fatalf("got %q, want %q", got, want[0])
+			}
+			want = want[1:]
+		}
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Fatalf("got %d spill files, want 1", len(ents))
+	}
+	q.close()
+
+	var got []string
+	for {
+		b, err := q.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil {
+			break
+		}
+		got = append(got, string(b))
+	}
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 0 {
+		t.Fatalf("spill file not removed: %v", ents)
+	}
+}
+
+func TestBatchQueueSpillReuse(t *testing.T) {
+	q := newBatchQueue(1, SpillToDisk, t.TempDir(), nil)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 3; i++ {
+			if _, err := q.Write([]byte(fmt.Sprintf("%d-%d\n", round, i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			b, err := q.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("%d-%d\n", round, i); string(b) != want {
+				t.Fatalf("got %q, want %q", b, want)
+			}
+		}
+		if q.rd != 0 || q.wr != 0 {
+			t.Fatalf("spill offsets not reset: %d, %d", q.rd, q.wr)
+		}
+	}
+}
+
+func TestBatchQueueBlock(t *testing.T) {
+	q := newBatchQueue(10, BlockOnOverflow, "", nil)
+	if _, err := q.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan struct{})
+	go func() {
+		q.Write([]byte("x"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("Write did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if b, _ := q.next(); string(b) != "0123456789" {
+		t.Fatalf("got %q", b)
+	}
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Write still blocked after the queue was drained")
+	}
+	if b, _ := q.next(); string(b) != "x" {
+		t.Fatalf("got %q", b)
+	}
+}
+
+func TestBatchQueueOversized(t *testing.T) {
+	q := newBatchQueue(4, BlockOnOverflow, "", nil)
+	if _, err := q.Write([]byte("longer than the limit")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := q.next(); string(b) != "longer than the limit" {
+		t.Fatalf("got %q", b)
+	}
+}
+
+func TestBatchQueueDropOldest(t *testing.T) {
+	var dropped int
+	q := newBatchQueue(10, DropOldest, "", func(lines int) { dropped += lines })
+	for _, b := range []string{"a\nb\n", "c\nd\n", "e\nf\n", "g\n"} {
+		if _, err := q.Write([]byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped != 2 {
+		t.Fatalf("dropped %d lines, want 2", dropped)
+	}
+	q.close()
+	var got string
+	for b, _ := q.next(); b != nil; b, _ = q.next() {
+		got += string(b)
+	}
+	if got != "c\nd\ne\nf\ng\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+type collectorValue struct {
+	Host  string `influx:"host,tag"`
+	Value int    `influx:"value"`
+}
+
+func TestCollectorDropOldestGzip(t *testing.T) {
+	const n = 500
+	c := NewCollector("m",
+		WithWorkers(1),
+		WithMaxBufferBytes(1, DropOldest),
+		WithLineWriterOptions(
+			WithMaxBatchLines(n),
+			WithGzip(gzip.BestSpeed),
+			WithEncodeOptions(WithTime(time.Unix(0, 0))),
+		),
+	)
+	for i := 0; i < 3*n; i++ {
+		if err := c.Add(collectorValue{Host: "a", Value: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := make(chan int)
+	go func() {
+		lines := 0
+		for batch := range c.Batches() {
+			zr, err := gzip.NewReader(bytes.NewReader(batch))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			b, _ := io.ReadAll(zr)
+			lines += strings.Count(string(b), "\n")
+		}
+		received <- lines
+	}()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-received
+	if dropped := c.Dropped(); dropped%n != 0 || uint64(lines)+dropped != 3*n {
+		t.Fatalf("received %d lines and dropped %d, want multiples of %d totalling %d", lines, dropped, n, 3*n)
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flowchartsman/influxmarshal"
+)
+
+const annotation = "//influxmarshal:generate"
+
+// kinds of member supported by generated code
+const (
+	kindInt = iota
+	kindUint
+	kindFloat
+	kindBool
+	kindString
+	kindTime
+)
+
+// member is a struct member to be encoded.
+type member struct {
+	goName    string
+	key       string
+	kind      int
+	bits      int
+	omitzero  bool
+	omitempty bool
+	omitnan   bool
+	required  bool
+	// overflow is set for unsigned members whose values may not fit in an
+	// int64
+	overflow bool
+}
+
+// structInfo describes a struct to generate methods for.
+type structInfo struct {
+	name        string
+	measurement string
+	tags        []member
+	fields      []member
+	times       []member
+}
+
+// unsupportedMethods maps the methods of the interfaces that generated
+// methods do not honor to the names of the interfaces.
+var unsupportedMethods = map[string]string{
+	"InfluxTags":          "InfluxTagger",
+	"BeforeInfluxMarshal": "BeforeMarshaler",
+	"AfterInfluxMarshal":  "AfterMarshaler",
+}
+
+// generateFile generates methods for the structs in the file at path and
+// writes them to out. Nothing is written if there are no structs to
+// generate methods for.
+func generateFile(path, out string, all bool, tagKey string) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	// generated methods do not call the methods of these interfaces
+	unsupported := make(map[string]string)
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Recv == nil {
+			continue
+		}
+		iface, ok := unsupportedMethods[fd.Name.Name]
+		if !ok {
+			continue
+		}
+		recv := fd.Recv.List[0].Type
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		}
+		if id, ok := recv.(*ast.Ident); ok {
+			unsupported[id.Name] = iface
+		}
+	}
+
+	var structs []*structInfo
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok || ts.TypeParams != nil {
+				continue
+			}
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			if !all && !annotated(doc) {
+				continue
+			}
+			if iface, ok := unsupported[ts.Name.Name]; ok {
+				return fmt.Errorf("%s: %s implements %s, which is not supported", fset.Position(ts.Pos()), ts.Name.Name, iface)
+			}
+			info, err := parseStruct(ts.Name.Name, st, tagKey)
+			if err != nil {
+				return fmt.Errorf("%s: %v", fset.Position(ts.Pos()), err)
+			}
+			structs = append(structs, info)
+		}
+	}
+	if len(structs) == 0 {
+		return nil
+	}
+
+	src, err := generate(f.Name.Name, structs)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, src, 0644)
+}
+
+// annotated reports whether doc contains the generate annotation.
+func annotated(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStruct reads the members of the struct type st named name.
+func parseStruct(name string, st *ast.StructType, tagKey string) (*structInfo, error) {
+	info := &structInfo{name: name}
+	for _, field := range st.Fields.List {
+		var tag string
+		hasTag := false
+		if field.Tag != nil {
+			raw, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag, hasTag = reflect.StructTag(raw).Lookup(tagKey)
+		}
+		if tag == "-" {
+			continue
+		}
+
+		if len(field.Names) == 0 {
+			if sel, ok := field.Type.(*ast.SelectorExpr); ok {
+				switch sel.Sel.Name {
+				case "Measurement":
+					info.measurement = tag
+					continue
+				case "RetentionPolicy":
+					continue
+				}
+			}
+			return nil, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+
+		for _, ident := range field.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			m := member{goName: ident.Name, key: ident.Name}
+			isTag, isTime := false, false
+			if hasTag {
+				opts := strings.Split(tag, ",")
+				if opts[0] != "" {
+					m.key = opts[0]
+				}
+				for _, opt := range opts[1:] {
+					switch opt {
+					case "omitzero":
+						m.omitzero = true
+					case "omitempty":
+						m.omitempty = true
+					case "omitnan":
+						m.omitnan = true
+					case "required":
+						m.required = true
+					case "tag":
+						isTag = true
+					case "time":
+						isTime = true
+					case "inline", "tags", "fields", "dive", "boolint", "json", "string", "flatten":
+						return nil, fmt.Errorf("%s.%s: option %q is not supported", name, ident.Name, opt)
+					case "":
+						// tolerate stray commas
+					default:
+						switch key, _, _ := strings.Cut(opt, "="); key {
+						case "measurement", "precision", "duration", "timeformat", "epoch", "prefix", "flatten":
+							return nil, fmt.Errorf("%s.%s: option %q is not supported", name, ident.Name, opt)
+						}
+						return nil, fmt.Errorf("%s.%s: unknown option %q", name, ident.Name, opt)
+					}
+				}
+			}
+
+			if err := setKind(&m, field.Type); err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", name, ident.Name, err)
+			}
+			if !hasTag && ident.Name == "Time" && m.kind == kindTime {
+				isTime = true
+			}
+			switch {
+			case isTime:
+				if m.kind != kindTime {
+					return nil, fmt.Errorf("%s.%s: time option on non-time member", name, ident.Name)
+				}
+				info.times = append(info.times, m)
+			case m.kind == kindTime:
+				return nil, fmt.Errorf("%s.%s: time.Time members must have the time option", name, ident.Name)
+			case isTag:
+				info.tags = append(info.tags, m)
+			default:
+				info.fields = append(info.fields, m)
+			}
+		}
+	}
+	if len(info.fields) == 0 {
+		return nil, fmt.Errorf("%s has no fields", name)
+	}
+	// as influxmarshal reports with DuplicateKeyError
+	seen := make(map[string]string)
+	for _, m := range append(info.tags[:len(info.tags):len(info.tags)], info.fields...) {
+		if other, ok := seen[m.key]; ok {
+			return nil, fmt.Errorf("%s: members %s and %s have the same key %q", name, other, m.goName, m.key)
+		}
+		seen[m.key] = m.goName
+	}
+	sort.SliceStable(info.tags, func(i, j int) bool {
+		return info.tags[i].key < info.tags[j].key
+	})
+	return info, nil
+}
+
+// setKind sets the kind of m from its type expression.
+func setKind(m *member, expr ast.Expr) error {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "int", "int64":
+			m.kind, m.bits = kindInt, 64
+		case "int8", "int16", "int32", "rune":
+			m.kind, m.bits = kindInt, 32
+		case "uint", "uint64", "uintptr":
+			m.kind, m.bits, m.overflow = kindUint, 64, true
+		case "uint8", "uint16", "uint32", "byte":
+			m.kind, m.bits = kindUint, 32
+		case "float32":
+			m.kind, m.bits = kindFloat, 32
+		case "float64":
+			m.kind, m.bits = kindFloat, 64
+		case "bool":
+			m.kind = kindBool
+		case "string":
+			m.kind = kindString
+		default:
+			return fmt.Errorf("unsupported type %s", t.Name)
+		}
+		return nil
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok && x.Name == "time" && t.Sel.Name == "Time" {
+			m.kind = kindTime
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported type")
+}
+
+// generator accumulates generated source.
+type generator struct {
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// generate returns the formatted source of a file in package pkg with
+// methods for structs.
+func generate(pkg string, structs []*structInfo) ([]byte, error) {
+	g := &generator{imports: map[string]bool{
+		"errors":  true,
+		"strconv": true,
+		"time":    true,
+	}}
+	for _, s := range structs {
+		g.marshalInflux(s)
+		g.appendInfluxLine(s)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by influxmarshalgen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	var std []string
+	for imp := range g.imports {
+		std = append(std, imp)
+	}
+	sort.Strings(std)
+	for _, imp := range std {
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	fmt.Fprintf(&out, "\n\t\"github.com/flowchartsman/influxmarshal\"\n")
+	fmt.Fprintf(&out, "\tinflux \"github.com/influxdata/influxdb1-client\"\n)\n")
+	out.Write(g.buf.Bytes())
+	return format.Source(out.Bytes())
+}
+
+// measurement generates the resolution of the measurement name of s, with
+// the given return values on failure.
+func (g *generator) measurement(s *structInfo, fail string) {
+	g.printf("\tif measurement == \"\" {\n")
+	g.printf("\t\tif m, ok := interface{}(v).(influxmarshal.Measurementer); ok {\n")
+	g.printf("\t\t\tmeasurement = m.Measurement()\n")
+	if s.measurement != "" {
+		g.printf("\t\t} else {\n\t\t\tmeasurement = %q\n", s.measurement)
+	}
+	g.printf("\t\t}\n\t}\n")
+	g.printf("\tif measurement == \"\" {\n\t\treturn %s, errors.New(%q)\n\t}\n", fail, "no measurement for "+s.name)
+}
+
+// timestamp generates the resolution of the timestamp t of s.
+func (g *generator) timestamp(s *structInfo) {
+	for _, m := range s.times {
+		g.printf("\tif !v.%s.IsZero() {\n\t\tt = v.%[1]s\n\t}\n", m.goName)
+	}
+	g.printf("\tif ts, ok := interface{}(v).(influxmarshal.Timestamper); ok {\n")
+	g.printf("\t\tif vt := ts.Timestamp(); !vt.IsZero() {\n\t\t\tt = vt\n\t\t}\n\t}\n")
+}
+
+// required generates the checks of the members of s with the "required"
+// option, returning fail and an error if one is zero.
+func (g *generator) required(s *structInfo, fail string) {
+	for _, ms := range [][]member{s.tags, s.fields, s.times} {
+		for _, m := range ms {
+			if !m.required {
+				continue
+			}
+			g.imports["fmt"] = true
+			var zero string
+			switch m.kind {
+			case kindString:
+				zero = fmt.Sprintf("v.%s == \"\"", m.goName)
+			case kindBool:
+				zero = "!v." + m.goName
+			case kindTime:
+				zero = fmt.Sprintf("v.%s.IsZero()", m.goName)
+			default:
+				zero = fmt.Sprintf("v.%s == 0", m.goName)
+			}
+			g.printf("\tif %s {\n\t\treturn %s, fmt.Errorf(\"member %s: %%w\", influxmarshal.ErrRequired)\n\t}\n", zero, fail, m.goName)
+		}
+	}
+}
+
+// zeroCheck returns the condition under which m is not omitted, or "" if it
+// is never omitted.
+func zeroCheck(m member, tag bool) string {
+	switch {
+	case m.kind == kindString && (tag || m.omitzero || m.omitempty):
+		return fmt.Sprintf("v.%s != \"\"", m.goName)
+	case !m.omitzero:
+		return ""
+	case m.kind == kindBool:
+		return "v." + m.goName
+	}
+	return fmt.Sprintf("v.%s != 0", m.goName)
+}
+
+// fieldCheck is like zeroCheck for the field m, also omitting NaN and
+// infinite values of members with the "omitnan" option.
+func (g *generator) fieldCheck(m member) string {
+	cond := zeroCheck(m, false)
+	if m.kind != kindFloat || !m.omitnan {
+		return cond
+	}
+	g.imports["math"] = true
+	finite := fmt.Sprintf("!math.IsNaN(float64(v.%s)) && !math.IsInf(float64(v.%[1]s), 0)", m.goName)
+	if cond == "" {
+		return finite
+	}
+	return cond + " && " + finite
+}
+
+// checkFinite generates the check of the float field m for NaN and infinite
+// values, which are an error unless m has the "omitnan" option, returning
+// fail and an error as influxmarshal.Marshal does.
+func (g *generator) checkFinite(m member, fail string) {
+	if m.kind != kindFloat || m.omitnan {
+		return
+	}
+	g.imports["fmt"] = true
+	g.imports["math"] = true
+	g.printf("\tif math.IsNaN(float64(v.%s)) || math.IsInf(float64(v.%[1]s), 0) {\n", m.goName)
+	g.printf("\t\treturn %s, fmt.Errorf(\"member %s: unsupported value %%v\", v.%[2]s)\n\t}\n", fail, m.goName)
+}
+
+// tagString returns an expression formatting the tag m as a string.
+func tagString(m member) string {
+	switch m.kind {
+	case kindInt:
+		return fmt.Sprintf("strconv.FormatInt(int64(v.%s), 10)", m.goName)
+	case kindUint:
+		return fmt.Sprintf("strconv.FormatUint(uint64(v.%s), 10)", m.goName)
+	case kindFloat:
+		return fmt.Sprintf("strconv.FormatFloat(float64(v.%s), 'g', -1, %d)", m.goName, m.bits)
+	case kindBool:
+		return fmt.Sprintf("strconv.FormatBool(v.%s)", m.goName)
+	}
+	return "v." + m.goName
+}
+
+func (g *generator) marshalInflux(s *structInfo) {
+	g.printf("\n// MarshalInflux implements influxmarshal.PointMarshaler.\n")
+	g.printf("func (v *%s) MarshalInflux(measurement string) (influx.Point, error) {\n", s.name)
+	g.measurement(s, "influx.Point{}")
+	g.required(s, "influx.Point{}")
+	g.printf("\tp := influx.Point{\n\t\tMeasurement: measurement,\n")
+	g.printf("\t\tTags: make(map[string]string, %d),\n", len(s.tags))
+	g.printf("\t\tFields: make(map[string]interface{}, %d),\n\t}\n", len(s.fields))
+	for _, m := range s.tags {
+		set := fmt.Sprintf("p.Tags[%q] = %s", m.key, tagString(m))
+		if cond := zeroCheck(m, true); cond != "" {
+			g.printf("\tif %s {\n\t\t%s\n\t}\n", cond, set)
+		} else {
+			g.printf("\t%s\n", set)
+		}
+	}
+	for _, m := range s.fields {
+		g.checkFinite(m, "influx.Point{}")
+		set := fmt.Sprintf("p.Fields[%q] = v.%s", m.key, m.goName)
+		if cond := g.fieldCheck(m); cond != "" {
+			g.printf("\tif %s {\n\t\t%s\n\t}\n", cond, set)
+		} else {
+			g.printf("\t%s\n", set)
+		}
+	}
+	g.printf("\tt := time.Now()\n")
+	g.timestamp(s)
+	g.printf("\tp.Time = t\n")
+	g.printf("\tif len(p.Fields) == 0 {\n\t\treturn p, influxmarshal.ErrNoFields\n\t}\n")
+	g.printf("\treturn p, nil\n}\n")
+}
+
+func (g *generator) appendInfluxLine(s *structInfo) {
+	g.printf("\n// AppendInfluxLine implements influxmarshal.LineAppender.\n")
+	g.printf("func (v *%s) AppendInfluxLine(dst []byte, measurement string, t time.Time) ([]byte, error) {\n", s.name)
+	g.measurement(s, "dst")
+	g.required(s, "dst")
+	g.printf("\tb := influxmarshal.AppendEscapedMeasurement(dst, measurement)\n")
+	for _, m := range s.tags {
+		cond := zeroCheck(m, true)
+		if cond != "" {
+			g.printf("\tif %s {\n", cond)
+		}
+		g.printf("\tb = append(b, %q...)\n", ","+influxmarshal.EscapeTagKey(m.key)+"=")
+		switch m.kind {
+		case kindInt:
+			g.printf("\tb = strconv.AppendInt(b, int64(v.%s), 10)\n", m.goName)
+		case kindUint:
+			g.printf("\tb = strconv.AppendUint(b, uint64(v.%s), 10)\n", m.goName)
+		case kindFloat:
+			g.printf("\tb = strconv.AppendFloat(b, float64(v.%s), 'g', -1, %d)\n", m.goName, m.bits)
+		case kindBool:
+			g.printf("\tb = strconv.AppendBool(b, v.%s)\n", m.goName)
+		default:
+			g.printf("\tb = influxmarshal.AppendEscapedTagValue(b, v.%s)\n", m.goName)
+		}
+		if cond != "" {
+			g.printf("\t}\n")
+		}
+	}
+	g.imports["bytes"] = true
+	g.printf("\tif bytes.ContainsAny(b[len(dst):], \"\\n\\r\") {\n\t\treturn dst, influxmarshal.ErrLineBreak\n\t}\n")
+
+	g.printf("\tsep := byte(' ')\n")
+	for _, m := range s.fields {
+		cond := g.fieldCheck(m)
+		if cond != "" {
+			g.printf("\tif %s {\n", cond)
+		}
+		g.printf("\tb = append(b, sep)\n")
+		g.printf("\tb = append(b, %q...)\n", influxmarshal.EscapeFieldKey(m.key)+"=")
+		switch m.kind {
+		case kindInt:
+			g.printf("\tb = strconv.AppendInt(b, int64(v.%s), 10)\n\tb = append(b, 'i')\n", m.goName)
+		case kindUint:
+			if m.overflow {
+				g.imports["fmt"] = true
+				g.imports["math"] = true
+				g.printf("\tif uint64(v.%s) > math.MaxInt64 {\n", m.goName)
+				g.printf("\t\treturn dst, fmt.Errorf(\"field %%s: value %%d overflows int64\", %q, v.%s)\n\t}\n", m.key, m.goName)
+			}
+			g.printf("\tb = strconv.AppendUint(b, uint64(v.%s), 10)\n\tb = append(b, 'i')\n", m.goName)
+		case kindFloat:
+			g.checkFinite(m, "dst")
+			g.printf("\tb = strconv.AppendFloat(b, float64(v.%s), 'f', -1, %d)\n", m.goName, m.bits)
+		case kindBool:
+			g.printf("\tb = strconv.AppendBool(b, v.%s)\n", m.goName)
+		default:
+			g.printf("\tb = append(b, '\"')\n\tb = influxmarshal.AppendEscapedStringField(b, v.%s)\n\tb = append(b, '\"')\n", m.goName)
+		}
+		g.printf("\tsep = ','\n")
+		if cond != "" {
+			g.printf("\t}\n")
+		}
+	}
+	g.printf("\tif sep == ' ' {\n\t\treturn dst, influxmarshal.ErrNoFields\n\t}\n")
+
+	g.timestamp(s)
+	g.printf("\tif !t.IsZero() {\n\t\tb = append(b, ' ')\n\t\tb = strconv.AppendInt(b, t.UnixNano(), 10)\n\t}\n")
+	g.printf("\treturn append(b, '\\n'), nil\n}\n")
+}
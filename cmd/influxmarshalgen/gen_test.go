@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	for _, tt := range []struct {
+		in, golden string
+		all        bool
+		tagKey     string
+	}{
+		// the generated methods of gentest are also tested against
+		// reflection
+		{"internal/gentest/point.go", "internal/gentest/point_influx.go", false, "influx"},
+		{"testdata/tagkey.go", "testdata/tagkey.golden", true, "lp"},
+	} {
+		out := filepath.Join(t.TempDir(), "out.go")
+		if err := generateFile(tt.in, out, tt.all, tt.tagKey); err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *update {
+			if err := os.WriteFile(tt.golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(tt.golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s: output differs from %s; run go test -update to regenerate it\n%s", tt.in, tt.golden, got)
+		}
+	}
+}
+
+func TestGenerateNotAnnotated(t *testing.T) {
+	in := writeSource(t, "type T struct {\n\tN int\n}\n")
+	out := filepath.Join(t.TempDir(), "out.go")
+	if err := generateFile(in, out, false, "influx"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("output written for a file without annotated structs: %v", err)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"type T struct {\n\tN int `influx:\"n,inline\"`\n}", `T.N: option "inline" is not supported`},
+		{"type T struct {\n\tN int `influx:\"n,precision=2\"`\n}", `T.N: option "precision=2" is not supported`},
+		{"type T struct {\n\tN int `influx:\"n,bogus\"`\n}", `T.N: unknown option "bogus"`},
+		{"type T struct {\n\tE\n\tN int\n}", "T: embedded fields are not supported"},
+		{"type T struct {\n\tN complex128\n}", "T.N: unsupported type complex128"},
+		{"type T struct {\n\tN []int\n}", "T.N: unsupported type"},
+		{"type T struct {\n\tN int `influx:\",time\"`\n}", "T.N: time option on non-time member"},
+		{"type T struct {\n\tAt time.Time\n\tN  int\n}", "T.At: time.Time members must have the time option"},
+		{"type T struct {\n\tA int `influx:\"k\"`\n\tB int `influx:\"k\"`\n}", `T: members A and B have the same key "k"`},
+		{"type T struct {\n\tN int `influx:\",tag\"`\n}", "T has no fields"},
+		{"type T struct {\n\tN int\n}\n\nfunc (T) InfluxTags() map[string]string { return nil }", "T implements InfluxTagger, which is not supported"},
+	} {
+		in := writeSource(t, tt.src)
+		err := generateFile(in, filepath.Join(t.TempDir(), "out.go"), true, "influx")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+// writeSource writes a file in package p holding src, and returns its path.
+func writeSource(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.go")
+	if err := os.WriteFile(path, []byte("package p\n\n"+src+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
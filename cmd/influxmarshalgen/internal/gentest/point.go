@@ -0,0 +1,51 @@
+// Package gentest holds structs with methods generated by influxmarshalgen,
+// to test them against the reflection-based encoder.
+package gentest
+
+import (
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+)
+
+//go:generate go run ../.. point.go
+
+// Point has a member of each kind supported by influxmarshalgen.
+//
+//influxmarshal:generate
+type Point struct {
+	influxmarshal.Measurement `influx:"point"`
+
+	Host   string  `influx:"host,tag"`
+	Region string  `influx:"region,tag,required"`
+	Shard  int     `influx:"shard,tag,omitzero"`
+	Node   uint8   `influx:"node,tag"`
+	Ratio  float32 `influx:"ratio,tag,omitzero"`
+	Active bool    `influx:"active,tag"`
+
+	Count   int64   `influx:"count"`
+	Small   int8    `influx:"small,omitzero"`
+	Total   uint64  `influx:"total"`
+	Bytes   uint32  `influx:"bytes,omitempty"`
+	Value   float64 `influx:"value"`
+	Maybe   float64 `influx:"maybe,omitnan"`
+	Single  float32 `influx:"single,omitzero"`
+	OK      bool    `influx:"ok,omitzero"`
+	Message string  `influx:"message"`
+	Note    string  `influx:"note,omitempty"`
+	Ignored string  `influx:"-"`
+
+	Time time.Time `influx:"time,time"`
+}
+
+// Sparse has only optional fields.
+//
+//influxmarshal:generate
+type Sparse struct {
+	influxmarshal.Measurement `influx:"sparse"`
+
+	Name  string    `influx:"name,tag"`
+	Count int       `influx:"count,omitzero"`
+	Value float64   `influx:"value,omitnan"`
+	Time  time.Time `influx:"time,time"`
+}
@@ -0,0 +1,310 @@
+// Code generated by influxmarshalgen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// MarshalInflux implements influxmarshal.PointMarshaler.
+func (v *Point) MarshalInflux(measurement string) (influx.Point, error) {
+	if measurement == "" {
+		if m, ok := interface{}(v).(influxmarshal.Measurementer); ok {
+			measurement = m.Measurement()
+		} else {
+			measurement = "point"
+		}
+	}
+	if measurement == "" {
+		return influx.Point{}, errors.New("no measurement for Point")
+	}
+	if v.Region == "" {
+		return influx.Point{}, fmt.Errorf("member Region: %w", influxmarshal.ErrRequired)
+	}
+	p := influx.Point{
+		Measurement: measurement,
+		Tags:        make(map[string]string, 6),
+		Fields:      make(map[string]interface{}, 10),
+	}
+	p.Tags["active"] = strconv.FormatBool(v.Active)
+	if v.Host != "" {
+		p.Tags["host"] = v.Host
+	}
+	p.Tags["node"] = strconv.FormatUint(uint64(v.Node), 10)
+	if v.Ratio != 0 {
+		p.Tags["ratio"] = strconv.FormatFloat(float64(v.Ratio), 'g', -1, 32)
+	}
+	if v.Region != "" {
+		p.Tags["region"] = v.Region
+	}
+	if v.Shard != 0 {
+		p.Tags["shard"] = strconv.FormatInt(int64(v.Shard), 10)
+	}
+	p.Fields["count"] = v.Count
+	if v.Small != 0 {
+		p.Fields["small"] = v.Small
+	}
+	p.Fields["total"] = v.Total
+	p.Fields["bytes"] = v.Bytes
+	if math.IsNaN(float64(v.Value)) || math.IsInf(float64(v.Value), 0) {
+		return influx.Point{}, fmt.Errorf("member Value: unsupported value %v", v.Value)
+	}
+	p.Fields["value"] = v.Value
+	if !math.IsNaN(float64(v.Maybe)) && !math.IsInf(float64(v.Maybe), 0) {
+		p.Fields["maybe"] = v.Maybe
+	}
+	if math.IsNaN(float64(v.Single)) || math.IsInf(float64(v.Single), 0) {
+		return influx.Point{}, fmt.Errorf("member Single: unsupported value %v", v.Single)
+	}
+	if v.Single != 0 {
+		p.Fields["single"] = v.Single
+	}
+	if v.OK {
+		p.Fields["ok"] = v.OK
+	}
+	p.Fields["message"] = v.Message
+	if v.Note != "" {
+		p.Fields["note"] = v.Note
+	}
+	t := time.Now()
+	if !v.Time.IsZero() {
+		t = v.Time
+	}
+	if ts, ok := interface{}(v).(influxmarshal.Timestamper); ok {
+		if vt := ts.Timestamp(); !vt.IsZero() {
+			t = vt
+		}
+	}
+	p.Time = t
+	if len(p.Fields) == 0 {
+		return p, influxmarshal.ErrNoFields
+	}
+	return p, nil
+}
+
+// AppendInfluxLine implements influxmarshal.LineAppender.
+func (v *Point) AppendInfluxLine(dst []byte, measurement string, t time.Time) ([]byte, error) {
+	if measurement == "" {
+		if m, ok := interface{}(v).(influxmarshal.Measurementer); ok {
+			measurement = m.Measurement()
+		} else {
+			measurement = "point"
+		}
+	}
+	if measurement == "" {
+		return dst, errors.New("no measurement for Point")
+	}
+	if v.Region == "" {
+		return dst, fmt.Errorf("member Region: %w", influxmarshal.ErrRequired)
+	}
+	b := influxmarshal.AppendEscapedMeasurement(dst, measurement)
+	b = append(b, ",active="...)
+	b = strconv.AppendBool(b, v.Active)
+	if v.Host != "" {
+		b = append(b, ",host="...)
+		b = influxmarshal.AppendEscapedTagValue(b, v.Host)
+	}
+	b = append(b, ",node="...)
+	b = strconv.AppendUint(b, uint64(v.Node), 10)
+	if v.Ratio != 0 {
+		b = append(b, ",ratio="...)
+		b = strconv.AppendFloat(b, float64(v.Ratio), 'g', -1, 32)
+	}
+	if v.Region != "" {
+		b = append(b, ",region="...)
+		b = influxmarshal.AppendEscapedTagValue(b, v.Region)
+	}
+	if v.Shard != 0 {
+		b = append(b, ",shard="...)
+		b = strconv.AppendInt(b, int64(v.Shard), 10)
+	}
+	if bytes.ContainsAny(b[len(dst):], "\n\r") {
+		return dst, influxmarshal.ErrLineBreak
+	}
+	sep := byte(' ')
+	b = append(b, sep)
+	b = append(b, "count="...)
+	b = strconv.AppendInt(b, int64(v.Count), 10)
+	b = append(b, 'i')
+	sep = ','
+	if v.Small != 0 {
+		b = append(b, sep)
+		b = append(b, "small="...)
+		b = strconv.AppendInt(b, int64(v.Small), 10)
+		b = append(b, 'i')
+		sep = ','
+	}
+	b = append(b, sep)
+	b = append(b, "total="...)
+	if uint64(v.Total) > math.MaxInt64 {
+		return dst, fmt.Errorf("field %s: value %d overflows int64", "total", v.Total)
+	}
+	b = strconv.AppendUint(b, uint64(v.Total), 10)
+	b = append(b, 'i')
+	sep = ','
+	b = append(b, sep)
+	b = append(b, "bytes="...)
+	b = strconv.AppendUint(b, uint64(v.Bytes), 10)
+	b = append(b, 'i')
+	sep = ','
+	b = append(b, sep)
+	b = append(b, "value="...)
+	if math.IsNaN(float64(v.Value)) || math.IsInf(float64(v.Value), 0) {
+		return dst, fmt.Errorf("member Value: unsupported value %v", v.Value)
+	}
+	b = strconv.AppendFloat(b, float64(v.Value), 'f', -1, 64)
+	sep = ','
+	if !math.IsNaN(float64(v.Maybe)) && !math.IsInf(float64(v.Maybe), 0) {
+		b = append(b, sep)
+		b = append(b, "maybe="...)
+		b = strconv.AppendFloat(b, float64(v.Maybe), 'f', -1, 64)
+		sep = ','
+	}
+	if v.Single != 0 {
+		b = append(b, sep)
+		b = append(b, "single="...)
+		if math.IsNaN(float64(v.Single)) || math.IsInf(float64(v.Single), 0) {
+			return dst, fmt.Errorf("member Single: unsupported value %v", v.Single)
+		}
+		b = strconv.AppendFloat(b, float64(v.Single), 'f', -1, 32)
+		sep = ','
+	}
+	if v.OK {
+		b = append(b, sep)
+		b = append(b, "ok="...)
+		b = strconv.AppendBool(b, v.OK)
+		sep = ','
+	}
+	b = append(b, sep)
+	b = append(b, "message="...)
+	b = append(b, '"')
+	b = influxmarshal.AppendEscapedStringField(b, v.Message)
+	b = append(b, '"')
+	sep = ','
+	if v.Note != "" {
+		b = append(b, sep)
+		b = append(b, "note="...)
+		b = append(b, '"')
+		b = influxmarshal.AppendEscapedStringField(b, v.Note)
+		b = append(b, '"')
+		sep = ','
+	}
+	if sep == ' ' {
+		return dst, influxmarshal.ErrNoFields
+	}
+	if !v.Time.IsZero() {
+		t = v.Time
+	}
+	if ts, ok := interface{}(v).(influxmarshal.Timestamper); ok {
+		if vt := ts.Timestamp(); !vt.IsZero() {
+			t = vt
+		}
+	}
+	if !t.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, t.UnixNano(), 10)
+	}
+	return append(b, '\n'), nil
+}
+
+// MarshalInflux implements influxmarshal.PointMarshaler.
+func (v *Sparse) MarshalInflux(measurement string) (influx.Point, error) {
+	if measurement == "" {
+		if m, ok := interface{}(v).(influxmarshal.Measurementer); ok {
+			measurement = m.Measurement()
+		} else {
+			measurement = "sparse"
+		}
+	}
+	if measurement == "" {
+		return influx.Point{}, errors.New("no measurement for Sparse")
+	}
+	p := influx.Point{
+		Measurement: measurement,
+		Tags:        make(map[string]string, 1),
+		Fields:      make(map[string]interface{}, 2),
+	}
+	if v.Name != "" {
+		p.Tags["name"] = v.Name
+	}
+	if v.Count != 0 {
+		p.Fields["count"] = v.Count
+	}
+	if !math.IsNaN(float64(v.Value)) && !math.IsInf(float64(v.Value), 0) {
+		p.Fields["value"] = v.Value
+	}
+	t := time.Now()
+	if !v.Time.IsZero() {
+		t = v.Time
+	}
+	if ts, ok := interface{}(v).(influxmarshal.Timestamper); ok {
+		if vt := ts.Timestamp(); !vt.IsZero() {
+			t = vt
+		}
+	}
+	p.Time = t
+	if len(p.Fields) == 0 {
+		return p, influxmarshal.ErrNoFields
+	}
+	return p, nil
+}
+
+// AppendInfluxLine implements influxmarshal.LineAppender.
+func (v *Sparse) AppendInfluxLine(dst []byte, measurement string, t time.Time) ([]byte, error) {
+	if measurement == "" {
+		if m, ok := interface{}(v).(influxmarshal.Measurementer); ok {
+			measurement = m.Measurement()
+		} else {
+			measurement = "sparse"
+		}
+	}
+	if measurement == "" {
+		return dst, errors.New("no measurement for Sparse")
+	}
+	b := influxmarshal.AppendEscapedMeasurement(dst, measurement)
+	if v.Name != "" {
+		b = append(b, ",name="...)
+		b = influxmarshal.AppendEscapedTagValue(b, v.Name)
+	}
+	if bytes.ContainsAny(b[len(dst):], "\n\r") {
+		return dst, influxmarshal.ErrLineBreak
+	}
+	sep := byte(' ')
+	if v.Count != 0 {
+		b = append(b, sep)
+		b = append(b, "count="...)
+		b = strconv.AppendInt(b, int64(v.Count), 10)
+		b = append(b, 'i')
+		sep = ','
+	}
+	if !math.IsNaN(float64(v.Value)) && !math.IsInf(float64(v.Value), 0) {
+		b = append(b, sep)
+		b = append(b, "value="...)
+		b = strconv.AppendFloat(b, float64(v.Value), 'f', -1, 64)
+		sep = ','
+	}
+	if sep == ' ' {
+		return dst, influxmarshal.ErrNoFields
+	}
+	if !v.Time.IsZero() {
+		t = v.Time
+	}
+	if ts, ok := interface{}(v).(influxmarshal.Timestamper); ok {
+		if vt := ts.Timestamp(); !vt.IsZero() {
+			t = vt
+		}
+	}
+	if !t.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, t.UnixNano(), 10)
+	}
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,132 @@
+package gentest
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+)
+
+// plainPoint and plainSparse have the members of Point and Sparse without
+// their generated methods, so they are encoded by reflection.
+type (
+	plainPoint  Point
+	plainSparse Sparse
+)
+
+var ts = time.Unix(1700000000, 5)
+
+func valid() Point {
+	return Point{
+		Host:    "a b,c=d",
+		Region:  "eu",
+		Shard:   3,
+		Node:    7,
+		Ratio:   0.5,
+		Active:  true,
+		Count:   -1,
+		Small:   2,
+		Total:   1 << 40,
+		Bytes:   4096,
+		Value:   1.25,
+		Maybe:   2.5,
+		Single:  0.75,
+		OK:      true,
+		Message: `say "hi"\`,
+		Note:    "n",
+		Ignored: "x",
+		Time:    ts,
+	}
+}
+
+func TestGeneratedMatchesReflection(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		edit func(p *Point)
+	}{
+		{"valid", func(p *Point) {}},
+		{"zero", func(p *Point) { *p = Point{Region: "eu", Time: ts} }},
+		{"nan", func(p *Point) { p.Value = math.NaN() }},
+		{"inf", func(p *Point) { p.Single = float32(math.Inf(-1)) }},
+		{"omitnan", func(p *Point) { p.Maybe = math.Inf(1) }},
+		{"required", func(p *Point) { p.Region = "" }},
+		{"overflow", func(p *Point) { p.Total = math.MaxUint64 }},
+		{"line break", func(p *Point) { p.Host = "a\nb" }},
+		{"no time", func(p *Point) { p.Time = time.Time{} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := valid()
+			tt.edit(&v)
+			comparePoints(t, &v, (*plainPoint)(&v), v.Time.IsZero())
+			compareLines(t, &v, (*plainPoint)(&v))
+		})
+	}
+
+	for _, v := range []Sparse{
+		{Name: "s", Count: 1, Value: 2, Time: ts},
+		{Count: 1, Value: math.NaN(), Time: ts},
+		{Value: math.NaN(), Time: ts},
+	} {
+		v := v
+		comparePoints(t, &v, (*plainSparse)(&v), false)
+		compareLines(t, &v, (*plainSparse)(&v))
+	}
+}
+
+// comparePoints compares the point of v, marshaled by its generated method,
+// with that of plain, marshaled by reflection. If now is set, the times are
+// not compared, as both take the current time.
+func comparePoints(t *testing.T, v, plain interface{}, now bool) {
+	t.Helper()
+	if _, ok := v.(influxmarshal.PointMarshaler); !ok {
+		t.Fatalf("%T does not implement PointMarshaler", v)
+	}
+	got, gotErr := influxmarshal.Marshal(v, "")
+	want, wantErr := influxmarshal.Marshal(plain, "")
+	if !sameError(gotErr, wantErr) {
+		t.Fatalf("MarshalInflux: got error %v, want %v", gotErr, wantErr)
+	}
+	if wantErr != nil {
+		return
+	}
+	if now {
+		got.Time, want.Time = time.Time{}, time.Time{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MarshalInflux:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+// compareLines compares the line of v, appended by its generated method,
+// with that of plain, appended by reflection.
+func compareLines(t *testing.T, v, plain interface{}) {
+	t.Helper()
+	if _, ok := v.(influxmarshal.LineAppender); !ok {
+		t.Fatalf("%T does not implement LineAppender", v)
+	}
+	got, gotErr := influxmarshal.AppendPoint(nil, v, "", ts.Add(time.Hour))
+	want, wantErr := influxmarshal.AppendPoint(nil, plain, "", ts.Add(time.Hour))
+	if !sameError(gotErr, wantErr) {
+		t.Fatalf("AppendInfluxLine: got error %v, want %v", gotErr, wantErr)
+	}
+	if string(got) != string(want) {
+		t.Errorf("AppendInfluxLine:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+// sameError reports whether the generated methods and reflection agree on
+// err, which must match in text, or for sentinel errors, in identity.
+func sameError(got, want error) bool {
+	if got == nil || want == nil {
+		return got == want
+	}
+	for _, sentinel := range []error{influxmarshal.ErrRequired, influxmarshal.ErrNoFields, influxmarshal.ErrLineBreak} {
+		if errors.Is(want, sentinel) {
+			return errors.Is(got, sentinel)
+		}
+	}
+	return got.Error() == want.Error()
+}
@@ -0,0 +1,57 @@
+// Command influxmarshalgen generates reflection-free MarshalInflux and
+// AppendInfluxLine methods for structs, so that they implement the
+// influxmarshal.PointMarshaler and influxmarshal.LineAppender interfaces.
+// Marshal, AppendLine and AppendPoint then use the generated methods instead
+// of reflection.
+//
+// Usage:
+//
+//	influxmarshalgen [-all] [-tag key] file.go...
+//
+// By default, methods are generated for the structs in each file whose doc
+// comment contains the line
+//
+//	//influxmarshal:generate
+//
+// or for every struct with -all. The methods for file.go are written to
+// file_influx.go. They are generated on the pointer type, so values must be
+// passed as pointers to take advantage of them.
+//
+// Generated methods support members of boolean, integer, floating point and
+// string types, and time.Time timestamps, with the same struct tags and
+// options as influxmarshal.Marshal, except "inline", "prefix", "tags",
+// "fields", "dive", "flatten", "measurement", "precision", "duration",
+// "boolint", "timeformat", "epoch", "json" and "string". Members of other
+// types, including named types, must be omitted with the tag "-". Structs
+// implementing influxmarshal.InfluxTagger, influxmarshal.BeforeMarshaler or
+// influxmarshal.AfterMarshaler are not supported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	all := flag.Bool("all", false, "generate methods for every struct, not only annotated ones")
+	tagKey := flag.String("tag", "influx", "struct tag key")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: influxmarshalgen [-all] [-tag key] file.go...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range flag.Args() {
+		out := strings.TrimSuffix(path, ".go") + "_influx.go"
+		if err := generateFile(path, out, *all, *tagKey); err != nil {
+			fmt.Fprintf(os.Stderr, "influxmarshalgen: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package tagkey
+
+import (
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+)
+
+// Load is generated with -all and -tag lp, without an annotation.
+type Load struct {
+	influxmarshal.Measurement `lp:"load"`
+
+	CPU      string    `lp:"cpu,tag"`
+	Core     uint      `lp:"core,tag,omitzero"`
+	Usage    float64   `lp:"usage,omitnan"`
+	Steal    float32   `lp:"steal,omitzero"`
+	Ticks    uint64    `lp:"ticks,required"`
+	Idle     bool      `influx:"ignored"`
+	Sampled  time.Time `lp:",time"`
+	internal int
+}
+
+// Pair declares two fields at once.
+type Pair struct {
+	A, B int32 `lp:",omitempty"`
+}
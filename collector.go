@@ -0,0 +1,290 @@
+package influxmarshal
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Collector gathers values from many goroutines, encodes them as line
+// protocol on a pool of worker goroutines, and delivers the lines in batches
+// on a channel:
+//
+//	c := influxmarshal.NewCollector("cpu", influxmarshal.WithLineWriterOptions(
+//	    influxmarshal.WithMaxBatchLines(5000),
+//	    influxmarshal.WithFlushAge(time.Second),
+//	))
+//	go func() {
+//	    for batch := range c.Batches() {
+//	        // write batch to InfluxDB
+//	    }
+//	}()
+//	...
+//	c.Add(v) // from any goroutine
+//	...
+//	err := c.Close()
+//
+// Batches are formed as by a LineWriter configured with the options given by
+// WithLineWriterOptions. The batches must be received promptly, as Add
+// blocks once the workers and the channel are full, unless WithMaxBufferBytes
+// is used to buffer them. The rate at which values
+// are encoded can be limited with WithRateLimit, so that bursts of values do
+// not overwhelm the server.
+type Collector struct {
+	// dropped is first to keep it 64-bit aligned for atomic access
+	dropped uint64
+
+	measurement string
+	workers     int
+	lwOpts      []LineWriterOption
+	onError     func(v interface{}, err error)
+	queue       int
+	limit       *tokenBucket
+	drop        bool
+	maxBuffer   int
+	overflow    OverflowPolicy
+	spillDir    string
+
+	lw        *LineWriter
+	in        chan interface{}
+	out       chan []byte
+	wg        sync.WaitGroup
+	buffer    *batchQueue
+	delivered chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+// CollectorOption customizes a Collector.
+type CollectorOption func(*Collector)
+
+// WithWorkers sets the number of goroutines encoding values for a Collector.
+// The default is runtime.GOMAXPROCS(0).
+func WithWorkers(n int) CollectorOption {
+	return func(c *Collector) {
+		c.workers = n
+	}
+}
+
+// WithQueueSize sets the number of values that may be queued for encoding
+// before Add blocks. The default is the number of workers.
+func WithQueueSize(n int) CollectorOption {
+	return func(c *Collector) {
+		c.queue = n
+	}
+}
+
+// WithRateLimit limits a Collector to encoding rate values per second on
+// average, with bursts of up to burst values. Values beyond the limit are
+// queued, up to the size set by WithQueueSize, after which Add blocks, unless
+// WithDropOverLimit is used. A rate of zero or less removes the limit.
+func WithRateLimit(rate float64, burst int) CollectorOption {
+	return func(c *Collector) {
+		if rate <= 0 {
+			c.limit = nil
+			return
+		}
+		c.limit = newTokenBucket(rate, burst)
+	}
+}
+
+// WithDropOverLimit causes a Collector with a rate limit to discard values
+// beyond the limit instead of queuing them. The number discarded is reported
+// by Dropped.
+func WithDropOverLimit() CollectorOption {
+	return func(c *Collector) {
+		c.drop = true
+	}
+}
+
+// WithMaxBufferBytes causes a Collector to buffer batches that have not yet
+// been received from the channel returned by Batches, so that a slow receiver,
+// such as one writing to an overloaded server, does not hold up encoding. The
+// buffered batches are limited to n bytes in memory, beyond which a batch is
+// handled according to policy. Without this option, a single batch is
+// buffered.
+func WithMaxBufferBytes(n int, policy OverflowPolicy) CollectorOption {
+	return func(c *Collector) {
+		c.maxBuffer = n
+		c.overflow = policy
+	}
+}
+
+// WithSpillDir sets the directory in which batches are stored under the
+// SpillToDisk policy. The default is os.TempDir().
+func WithSpillDir(dir string) CollectorOption {
+	return func(c *Collector) {
+		c.spillDir = dir
+	}
+}
+
+// WithLineWriterOptions sets the options of the LineWriter used to form
+// batches, and so the size and age limits of each batch and the Options used
+// to encode values.
+func WithLineWriterOptions(opts ...LineWriterOption) CollectorOption {
+	return func(c *Collector) {
+		c.lwOpts = append(c.lwOpts, opts...)
+	}
+}
+
+// WithErrorFunc sets a function to be called, from a worker goroutine, with
+// each value that cannot be encoded and the error. By default, the first
+// such error is returned by Close.
+func WithErrorFunc(f func(v interface{}, err error)) CollectorOption {
+	return func(c *Collector) {
+		c.onError = f
+	}
+}
+
+// NewCollector returns a Collector encoding values into the given
+// measurement, or, if measurement is empty, the measurement each value
+// declares, and starts its workers.
+func NewCollector(measurement string, opts ...CollectorOption) *Collector {
+	c := &Collector{
+		measurement: measurement,
+		workers:     runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.workers < 1 {
+		c.workers = 1
+	}
+	if c.queue <= 0 {
+		c.queue = c.workers
+	}
+	c.in = make(chan interface{}, c.queue)
+	c.out = make(chan []byte, 1)
+	if c.maxBuffer > 0 {
+		c.buffer = newBatchQueue(c.maxBuffer, c.overflow, c.spillDir, func(lines int) {
+			atomic.AddUint64(&c.dropped, uint64(lines))
+		})
+		c.delivered = make(chan struct{})
+		c.lw = NewLineWriter(c.buffer, c.lwOpts...)
+		go c.deliver()
+	} else {
+		c.lw = NewLineWriter(batchWriter(c.out), c.lwOpts...)
+	}
+
+	c.wg.Add(c.workers)
+	for i := 0; i < c.workers; i++ {
+		go c.work()
+	}
+	return c
+}
+
+// Add queues v to be encoded. It returns ErrClosed if the Collector has been
+// closed.
+func (c *Collector) Add(v interface{}) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.limit != nil && c.drop && !c.limit.take() {
+		atomic.AddUint64(&c.dropped, 1)
+		return nil
+	}
+	c.in <- v
+	return nil
+}
+
+// Dropped returns the number of values discarded for exceeding the rate
+// limit under WithDropOverLimit, or with their batches under DropOldest.
+func (c *Collector) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Batches returns the channel on which batches of lines are delivered. Each
+// batch is owned by the receiver. The channel is closed by Close once the
+// last batch has been delivered.
+func (c *Collector) Batches() <-chan []byte {
+	return c.out
+}
+
+// Close encodes any queued values, delivers the remaining lines, and closes
+// the channel returned by Batches. It returns the first error encountered by
+// the Collector, if any.
+func (c *Collector) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.in)
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	c.setErr(nil, c.lw.Close())
+	if c.buffer != nil {
+		c.buffer.close()
+		<-c.delivered
+	}
+	close(c.out)
+
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+func (c *Collector) work() {
+	defer c.wg.Done()
+	var buf []byte
+	for v := range c.in {
+		if c.limit != nil && !c.drop {
+			c.limit.wait()
+		}
+		var err error
+		buf, err = AppendLine(buf[:0], v, c.measurement, c.lw.opts...)
+		if err == nil {
+			err = c.lw.writeLine(buf)
+		}
+		c.setErr(v, err)
+	}
+}
+
+// deliver sends the batches in the buffer on the channel returned by Batches
+// until the buffer is closed and empty.
+func (c *Collector) deliver() {
+	defer close(c.delivered)
+	for {
+		b, err := c.buffer.next()
+		c.setErr(nil, err)
+		if b == nil && err == nil {
+			return
+		}
+		if b != nil {
+			c.out <- b
+		}
+	}
+}
+
+// setErr reports err, if it is not nil, for the value v.
+func (c *Collector) setErr(v interface{}, err error) {
+	if err == nil {
+		return
+	}
+	if c.onError != nil && v != nil {
+		c.onError(v, err)
+		return
+	}
+	c.errMu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.errMu.Unlock()
+}
+
+// batchWriter is an io.Writer that delivers a copy of each write on a
+// channel.
+type batchWriter chan<- []byte
+
+func (w batchWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
@@ -0,0 +1,145 @@
+package influxmarshal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collect receives every batch from c until it is closed, returning the
+// lines in order.
+func collect(c *Collector) <-chan []string {
+	done := make(chan []string, 1)
+	go func() {
+		var lines []string
+		for batch := range c.Batches() {
+			lines = append(lines, strings.Split(strings.TrimSuffix(string(batch), "\n"), "\n")...)
+		}
+		done <- lines
+	}()
+	return done
+}
+
+func collectorOpts(opts ...CollectorOption) []CollectorOption {
+	return append(opts, WithLineWriterOptions(
+		WithMaxBatchLines(7),
+		WithEncodeOptions(WithTime(time.Unix(0, 1))),
+	))
+}
+
+func TestCollectorOrder(t *testing.T) {
+	c := NewCollector("m", collectorOpts(WithWorkers(1))...)
+	done := collect(c)
+	for i := 0; i < 100; i++ {
+		if err := c.Add(collectorValue{Host: "a", Value: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-done
+	if len(lines) != 100 {
+		t.Fatalf("got %d lines, want 100", len(lines))
+	}
+	for i, line := range lines {
+		if want := fmt.Sprintf("m,host=a value=%di 1", i); line != want {
+			t.Fatalf("line %d: got %q, want %q", i, line, want)
+		}
+	}
+}
+
+func TestCollectorCloseDrains(t *testing.T) {
+	c := NewCollector("m", collectorOpts(WithWorkers(4), WithQueueSize(64))...)
+	done := collect(c)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := c.Add(collectorValue{Host: fmt.Sprint(g), Value: i}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-done
+	if len(lines) != 400 {
+		t.Fatalf("got %d lines, want 400", len(lines))
+	}
+	sort.Strings(lines)
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == lines[i-1] {
+			t.Fatalf("duplicate line %q", lines[i])
+		}
+	}
+
+	if err := c.Add(collectorValue{}); err != ErrClosed {
+		t.Fatalf("Add after Close: got %v, want ErrClosed", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestCollectorErrors(t *testing.T) {
+	type bad struct {
+		C chan int `influx:"c"`
+	}
+
+	c := NewCollector("m", collectorOpts()...)
+	done := collect(c)
+	c.Add(bad{})
+	c.Add(collectorValue{Host: "a", Value: 1})
+	if err := c.Close(); err == nil {
+		t.Fatal("expected error from Close")
+	}
+	if lines := <-done; len(lines) != 1 {
+		t.Fatalf("got %q", lines)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	c = NewCollector("m", collectorOpts(WithErrorFunc(func(v interface{}, err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}))...)
+	done = collect(c)
+	c.Add(bad{})
+	c.Add(struct{}{})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(errs) != 2 {
+		t.Fatalf("got errors %v, want 2", errs)
+	}
+	if !errors.Is(errs[0], ErrNoFields) && !errors.Is(errs[1], ErrNoFields) {
+		t.Fatalf("got errors %v, want one to be ErrNoFields", errs)
+	}
+}
+
+func TestCollectorDropOverLimit(t *testing.T) {
+	c := NewCollector("m", collectorOpts(WithRateLimit(1, 5), WithDropOverLimit())...)
+	done := collect(c)
+	for i := 0; i < 20; i++ {
+		c.Add(collectorValue{Host: "a", Value: i})
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-done
+	if dropped := c.Dropped(); dropped < 14 || uint64(len(lines))+dropped != 20 {
+		t.Fatalf("received %d and dropped %d", len(lines), dropped)
+	}
+}
@@ -0,0 +1,527 @@
+package influxmarshal
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// Unmarshal decodes the series in res into dest, which must be a pointer to
+// a slice of structs or a slice of struct pointers. Each row of each series
+// is appended to the slice as a new element.
+//
+// Result columns are matched to struct fields using the same "influx" struct
+// tags understood by Marshal, so a struct can be written and read back
+// without any additional annotation. The conversions of the "epoch",
+// "timeformat", "duration", "boolint" and "json" options are reversed. Columns without a matching field are
+// ignored, as are fields without a matching column. Null values leave the
+// field at its zero value. When a query groups by tags, the tags are
+// returned with each series rather than as columns; these are decoded into
+// the members with the "tag" option, and any without a member of their own
+// are collected by a member with the "tags" option. The "time" column is
+// decoded into the timestamp field, if any.
+//
+// Numeric columns may be decoded into any integer or float field that can
+// hold the value without loss; an error is returned otherwise. Times, in the
+// "time" column or elsewhere, may be decoded into time.Time fields from
+// RFC3339 strings or from epoch values, which are taken to be in
+// nanoseconds. Use a Decoder to handle epoch values in other units.
+//
+// Results can also be decoded without a struct type by passing a pointer to
+// a []map[string]interface{}, in which case each row becomes a map of its
+// series tags and column values. Either kind of slice may also be the
+// element type of a map with string keys, such as
+// map[string][]map[string]interface{}, to group rows by series name.
+func Unmarshal(res *influx.Result, dest interface{}) error {
+	return defaultDecoder.Unmarshal(res, dest)
+}
+
+// Decoder decodes query results into structs, as Unmarshal does, with
+// additional control over how values are interpreted.
+type Decoder struct {
+	epoch           time.Duration
+	disallowUnknown bool
+}
+
+// DecodeOption customizes the behavior of a Decoder.
+type DecodeOption func(*Decoder)
+
+// WithEpochUnit sets the unit of epoch time values, which must match the
+// precision requested by the query, e.g. time.Millisecond for "ms". The
+// default is time.Nanosecond.
+func WithEpochUnit(unit time.Duration) DecodeOption {
+	return func(d *Decoder) {
+		d.epoch = unit
+	}
+}
+
+// DisallowUnknownColumns causes the Decoder to return an error when a result
+// column, other than "time", has no corresponding struct field. This helps to
+// catch drift between queries and the structs they are decoded into.
+func DisallowUnknownColumns() DecodeOption {
+	return func(d *Decoder) {
+		d.disallowUnknown = true
+	}
+}
+
+var defaultDecoder = NewDecoder()
+
+// NewDecoder returns a Decoder configured by opts.
+func NewDecoder(opts ...DecodeOption) *Decoder {
+	d := &Decoder{
+		epoch: time.Nanosecond,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Unmarshal decodes the series in res into dest, as the package-level
+// Unmarshal does.
+func (d *Decoder) Unmarshal(res *influx.Result, dest interface{}) error {
+	if res == nil {
+		return fmt.Errorf("result is nil")
+	}
+	if res.Err != nil {
+		return res.Err
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer to a slice or map")
+	}
+	dv = dv.Elem()
+
+	switch {
+	case dv.Kind() == reflect.Slice:
+		plan, err := newSlicePlan(dv.Type())
+		if err != nil {
+			return err
+		}
+		for i := range res.Series {
+			if err := d.decodeSeries(dv, &res.Series[i], plan); err != nil {
+				return err
+			}
+		}
+	case dv.Kind() == reflect.Map && dv.Type().Key().Kind() == reflect.String && dv.Type().Elem().Kind() == reflect.Slice:
+		plan, err := newSlicePlan(dv.Type().Elem())
+		if err != nil {
+			return err
+		}
+		if dv.IsNil() {
+			dv.Set(reflect.MakeMap(dv.Type()))
+		}
+		for i := range res.Series {
+			row := &res.Series[i]
+			key := reflect.ValueOf(row.Name).Convert(dv.Type().Key())
+			sv := reflect.New(dv.Type().Elem()).Elem()
+			if cur := dv.MapIndex(key); cur.IsValid() {
+				sv.Set(cur)
+			}
+			if err := d.decodeSeries(sv, row, plan); err != nil {
+				return err
+			}
+			dv.SetMapIndex(key, sv)
+		}
+	default:
+		return fmt.Errorf("dest must be a pointer to a slice or map")
+	}
+	return nil
+}
+
+// rowMapType is the element type used to decode rows without a struct.
+var rowMapType = reflect.TypeOf(map[string]interface{}(nil))
+
+// newSlicePlan returns the decode plan for the elements of the slice type t,
+// or nil if they are maps.
+func newSlicePlan(t reflect.Type) (*decodePlan, error) {
+	elemType := t.Elem()
+	if elemType == rowMapType {
+		return nil, nil
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	return newDecodePlan(elemType)
+}
+
+// decodeSeries appends each row of row to the slice sv, decoding with plan,
+// or into maps if plan is nil.
+func (d *Decoder) decodeSeries(sv reflect.Value, row *models.Row, plan *decodePlan) error {
+	elemType := sv.Type().Elem()
+	if plan == nil {
+		for _, values := range row.Values {
+			sv.Set(reflect.Append(sv, reflect.ValueOf(d.rowMap(row, values))))
+		}
+		return nil
+	}
+
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	colFields := plan.columnFields(row.Columns)
+	if err := d.checkColumns(row.Columns, colFields, structType); err != nil {
+		return err
+	}
+	for _, values := range row.Values {
+		ev := reflect.New(structType).Elem()
+		if err := plan.decodeRow(d, ev, row, colFields, values); err != nil {
+			return err
+		}
+		if elemType.Kind() == reflect.Ptr {
+			ev = ev.Addr()
+		}
+		sv.Set(reflect.Append(sv, ev))
+	}
+	return nil
+}
+
+// checkColumns returns an error if unknown columns are disallowed and one of
+// columns has no field in the struct type t.
+func (d *Decoder) checkColumns(columns []string, colFields []*core.FieldInfo, t reflect.Type) error {
+	if !d.disallowUnknown {
+		return nil
+	}
+	for i, col := range columns {
+		if colFields[i] == nil && col != "time" {
+			return fmt.Errorf("unknown column %q for %s", col, t)
+		}
+	}
+	return nil
+}
+
+// rowMap returns the series tags and column values of a single row as a
+// map. The time column is converted to a time.Time and numbers to int64 or
+// float64.
+func (d *Decoder) rowMap(row *models.Row, values []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(row.Tags)+len(values))
+	for k, v := range row.Tags {
+		m[k] = v
+	}
+	for i, v := range values {
+		if i >= len(row.Columns) {
+			break
+		}
+		col := row.Columns[i]
+		if col == "time" && v != nil {
+			if t, err := d.parseTime(v); err == nil {
+				m[col] = t
+				continue
+			}
+		}
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = i
+			} else if f, err := n.Float64(); err == nil {
+				v = f
+			}
+		}
+		m[col] = v
+	}
+	return m
+}
+
+// decodeRow stores values, a single row of the series row, in the struct
+// value sv. Series tags are decoded first, so that a column of the same name
+// takes precedence. colFields holds the member for each column, as returned
+// by columnFields.
+func (plan *decodePlan) decodeRow(d *Decoder, sv reflect.Value, row *models.Row, colFields []*core.FieldInfo, values []interface{}) error {
+	for k, v := range row.Tags {
+		if fi, ok := plan.tags[k]; ok {
+			if err := d.setMember(sv, fi, v); err != nil {
+				return fmt.Errorf("tag %s: %v", k, err)
+			}
+			continue
+		}
+		for _, index := range plan.tagMaps {
+			m := fieldByIndexAlloc(sv, index)
+			if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String || m.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("tags member must be a map of strings")
+			}
+			if m.IsNil() {
+				m.Set(reflect.MakeMap(m.Type()))
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(m.Type().Key()), reflect.ValueOf(v).Convert(m.Type().Elem()))
+		}
+	}
+	for i, v := range values {
+		if i >= len(colFields) || colFields[i] == nil {
+			continue
+		}
+		if err := d.setMember(sv, colFields[i], v); err != nil {
+			return fmt.Errorf("column %s: %v", row.Columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Decode is like Unmarshal, but returns the decoded rows as a typed slice.
+// T must be a struct or a pointer to a struct.
+func Decode[T any](res *influx.Result) ([]T, error) {
+	var out []T
+	if err := Unmarshal(res, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodePlan maps the columns and series tags of query results to the
+// members of a struct type.
+type decodePlan struct {
+	// columns maps column names to members
+	columns map[string]*core.FieldInfo
+	// tags maps series tag keys to the members with the "tag" option
+	tags map[string]*core.FieldInfo
+	// tagMaps holds the members with the "tags" option, which collect
+	// series tags without a member of their own
+	tagMaps [][]int
+}
+
+func newDecodePlan(t reflect.Type) (*decodePlan, error) {
+	info, err := core.CompileType(t, core.NewOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	plan := &decodePlan{
+		columns: make(map[string]*core.FieldInfo, len(info.Fields)),
+		tags:    make(map[string]*core.FieldInfo, len(info.TagOrder)),
+	}
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		switch {
+		case fi.TagMap:
+			plan.tagMaps = append(plan.tagMaps, fi.Index)
+		case fi.FieldMap, fi.Flatten:
+			// not supported for query results
+		case fi.Time:
+			plan.columns["time"] = fi
+		default:
+			// tags appear as columns unless the query groups by them
+			plan.columns[fi.Name] = fi
+			if fi.Tag {
+				plan.tags[fi.Name] = fi
+			}
+		}
+	}
+	return plan, nil
+}
+
+// columnFields returns the member for each of columns, or nil for columns
+// without one.
+func (plan *decodePlan) columnFields(columns []string) []*core.FieldInfo {
+	colFields := make([]*core.FieldInfo, len(columns))
+	for i, col := range columns {
+		colFields[i] = plan.columns[col]
+	}
+	return colFields
+}
+
+// fieldByIndexAlloc returns the nested field of v at index, allocating nil
+// pointers to inlined structs along the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// setMember stores src, a value from a query result, into the member fi of
+// the struct value sv, reversing the conversion of its options.
+func (d *Decoder) setMember(sv reflect.Value, fi *core.FieldInfo, src interface{}) error {
+	if src == nil {
+		return nil
+	}
+	dst := fieldByIndexAlloc(sv, fi.Index)
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	return d.setDecoded(dst, fi, src)
+}
+
+// setDecoded stores src into dst, a value of the type of the member fi,
+// reversing the conversion of its options.
+func (d *Decoder) setDecoded(dst reflect.Value, fi *core.FieldInfo, src interface{}) error {
+	if ok, err := fi.SetDecoded(dst, src); ok {
+		return err
+	}
+	return d.setValue(dst, src)
+}
+
+// setValue stores src, a value from a query result, into dst.
+func (d *Decoder) setValue(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+
+	if dst.Type() == core.TimeType {
+		t, err := d.parseTime(src)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	// the client decodes numbers as json.Number, so handle those directly to
+	// avoid a lossy round trip through float64
+	if n, ok := src.(json.Number); ok {
+		return setNumber(dst, string(n))
+	}
+
+	sv := reflect.ValueOf(src)
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		switch sv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return setNumber(dst, strconv.FormatInt(sv.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return setNumber(dst, strconv.FormatUint(sv.Uint(), 10))
+		case reflect.Float32, reflect.Float64:
+			return setNumber(dst, strconv.FormatFloat(sv.Float(), 'g', -1, 64))
+		case reflect.String:
+			// tags are always strings
+			return setNumber(dst, sv.String())
+		}
+	case reflect.String:
+		if sv.Kind() == reflect.String {
+			dst.SetString(sv.String())
+			return nil
+		}
+	case reflect.Bool:
+		switch sv.Kind() {
+		case reflect.Bool:
+			dst.SetBool(sv.Bool())
+			return nil
+		case reflect.String:
+			b, err := strconv.ParseBool(sv.String())
+			if err != nil {
+				return fmt.Errorf("cannot decode %q into %s", sv.String(), dst.Type())
+			}
+			dst.SetBool(b)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+}
+
+// parseTime converts src, either an RFC3339 string or an epoch value, to a
+// time.Time.
+func (d *Decoder) parseTime(src interface{}) (time.Time, error) {
+	var s string
+	switch v := src.(type) {
+	case time.Time:
+		return v, nil
+	case json.Number:
+		s = string(v)
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, nil
+		}
+		s = v
+	default:
+		sv := reflect.ValueOf(src)
+		switch sv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return d.epochTime(sv.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return d.epochTime(int64(sv.Uint())), nil
+		case reflect.Float32, reflect.Float64:
+			return d.epochTime(int64(sv.Float())), nil
+		}
+		return time.Time{}, fmt.Errorf("cannot decode %T into %s", src, core.TimeType)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return time.Time{}, fmt.Errorf("cannot decode %q into %s", s, core.TimeType)
+		}
+		n = int64(f)
+	}
+	return d.epochTime(n), nil
+}
+
+// epochTime returns the time n units of d.epoch after the Unix epoch.
+func (d *Decoder) epochTime(n int64) time.Time {
+	unit := d.epoch
+	if unit <= 0 {
+		unit = time.Nanosecond
+	}
+	if unit >= time.Second {
+		return time.Unix(n*int64(unit/time.Second), 0)
+	}
+	perSecond := int64(time.Second / unit)
+	return time.Unix(n/perSecond, (n%perSecond)*int64(unit))
+}
+
+// setNumber parses the textual number s into the numeric or string value dst.
+func setNumber(dst reflect.Value, s string) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			// integral floats are allowed, e.g. 1e3 or 42.0
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil || f != float64(int64(f)) {
+				return fmt.Errorf("cannot decode %s into %s", s, dst.Type())
+			}
+			n = int64(f)
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("value %s overflows %s", s, dst.Type())
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil || f < 0 || f != float64(uint64(f)) {
+				return fmt.Errorf("cannot decode %s into %s", s, dst.Type())
+			}
+			n = uint64(f)
+		}
+		if dst.OverflowUint(n) {
+			return fmt.Errorf("value %s overflows %s", s, dst.Type())
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot decode %s into %s", s, dst.Type())
+		}
+		dst.SetFloat(f)
+	case reflect.String:
+		dst.SetString(s)
+	default:
+		return fmt.Errorf("cannot decode number into %s", dst.Type())
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+package influxmarshal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// resultOf returns a result holding the points ps in a single series, as the
+// client decodes a query selecting every tag and field, with numbers as
+// json.Number and times in RFC3339.
+func resultOf(ps ...influx.Point) *influx.Result {
+	keys := map[string]bool{}
+	for _, p := range ps {
+		for k := range p.Tags {
+			keys[k] = true
+		}
+		for k := range p.Fields {
+			keys[k] = true
+		}
+	}
+	columns := []string{"time"}
+	for k := range keys {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns[1:])
+
+	row := models.Row{Name: ps[0].Measurement, Columns: columns}
+	for _, p := range ps {
+		values := []interface{}{p.Time.UTC().Format(time.RFC3339Nano)}
+		for _, col := range columns[1:] {
+			var v interface{}
+			if tv, ok := p.Tags[col]; ok {
+				v = tv
+			} else if fv, ok := p.Fields[col]; ok {
+				switch fv.(type) {
+				case string, bool:
+					v = fv
+				default:
+					v = json.Number(fmt.Sprint(fv))
+				}
+			}
+			values = append(values, v)
+		}
+		row.Values = append(row.Values, values)
+	}
+	return &influx.Result{Series: []models.Row{row}}
+}
+
+type convertedPoint struct {
+	Seen     time.Time         `influx:"seen,epoch=s"`
+	SeenMS   time.Time         `influx:"seen_ms,epoch=ms"`
+	Day      time.Time         `influx:"day,timeformat=date"`
+	Stamp    *time.Time        `influx:"stamp,timeformat=rfc3339nano"`
+	Wait     time.Duration     `influx:"wait,duration=ms"`
+	Latency  time.Duration     `influx:"latency,duration=float"`
+	Up       bool              `influx:"up,boolint"`
+	Zone     bool              `influx:"zone,tag,boolint"`
+	Labels   map[string]string `influx:"labels,json"`
+	Interval time.Duration     `influx:"interval,tag,duration=s"`
+	Time     time.Time         `influx:"time,time"`
+}
+
+func TestUnmarshalReversesConversions(t *testing.T) {
+	stamp := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	in := convertedPoint{
+		Seen:     time.Unix(1700000000, 0),
+		SeenMS:   time.UnixMilli(1700000000123),
+		Day:      time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+		Stamp:    &stamp,
+		Wait:     1500 * time.Millisecond,
+		Latency:  250 * time.Microsecond,
+		Up:       true,
+		Zone:     true,
+		Labels:   map[string]string{"a": "b"},
+		Interval: time.Minute,
+		Time:     time.Unix(1700000001, 0).UTC(),
+	}
+	p, err := Marshal(in, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []convertedPoint
+	if err := Unmarshal(resultOf(p), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || !reflect.DeepEqual(normalize(out[0]), normalize(in)) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	line, err := MarshalLine(in, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromLine convertedPoint
+	if err := UnmarshalLine(line, &fromLine); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(normalize(fromLine), normalize(in)) {
+		t.Fatalf("UnmarshalLine: got %+v, want %+v", fromLine, in)
+	}
+
+	if err := CheckRoundTrip(in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// normalize returns v with its times in UTC, so that they compare equal
+// whatever their location.
+func normalize(v convertedPoint) convertedPoint {
+	v.Seen, v.SeenMS, v.Day, v.Time = v.Seen.UTC(), v.SeenMS.UTC(), v.Day.UTC(), v.Time.UTC()
+	if v.Stamp != nil {
+		s := v.Stamp.UTC()
+		v.Stamp = &s
+	}
+	return v
+}
+
+func TestUnmarshalConversionErrors(t *testing.T) {
+	type epoch struct {
+		Seen time.Time `influx:"seen,epoch=s"`
+	}
+	type format struct {
+		Day time.Time `influx:"day,timeformat=date"`
+	}
+	for _, tt := range []struct {
+		dest  interface{}
+		col   string
+		value interface{}
+	}{
+		{&[]epoch{}, "seen", json.Number("1.5")},
+		{&[]epoch{}, "seen", true},
+		{&[]format{}, "day", json.Number("3")},
+		{&[]format{}, "day", "yesterday"},
+	} {
+		res := &influx.Result{Series: []models.Row{{
+			Columns: []string{tt.col},
+			Values:  [][]interface{}{{tt.value}},
+		}}}
+		if err := Unmarshal(res, tt.dest); err == nil {
+			t.Errorf("%T from %v: no error", tt.dest, tt.value)
+		}
+	}
+}
+
+type decodedCPU struct {
+	Host  string    `influx:"host,tag"`
+	Usage float64   `influx:"usage"`
+	Count int8      `influx:"count"`
+	Cores *uint     `influx:"cores"`
+	Up    bool      `influx:"up"`
+	Time  time.Time `influx:"time,time"`
+}
+
+// series returns a result with a single series of the given columns and
+// rows.
+func series(columns []string, rows ...[]interface{}) *influx.Result {
+	return &influx.Result{Series: []models.Row{{Name: "cpu", Columns: columns, Values: rows}}}
+}
+
+func TestUnmarshal(t *testing.T) {
+	four := uint(4)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{"time", "host", "usage", "count", "cores", "up"}
+	for _, tt := range []struct {
+		name    string
+		res     *influx.Result
+		want    []decodedCPU
+		wantErr string
+	}{
+		{
+			name: "values",
+			res:  series(columns, []interface{}{"2024-01-02T03:04:05Z", "a", json.Number("0.5"), json.Number("3"), json.Number("4"), true}),
+			want: []decodedCPU{{Host: "a", Usage: 0.5, Count: 3, Cores: &four, Up: true, Time: ts}},
+		},
+		{
+			name: "nil columns",
+			res:  series(columns, []interface{}{nil, nil, nil, nil, nil, nil}),
+			want: []decodedCPU{{}},
+		},
+		{
+			name: "unknown and missing columns",
+			res:  series([]string{"host", "other"}, []interface{}{"a", json.Number("1")}),
+			want: []decodedCPU{{Host: "a"}},
+		},
+		{
+			name: "integral float into integer",
+			res:  series([]string{"count"}, []interface{}{json.Number("2.0")}),
+			want: []decodedCPU{{Count: 2}},
+		},
+		{
+			name: "several rows and series",
+			res: &influx.Result{Series: []models.Row{
+				{Columns: []string{"host"}, Values: [][]interface{}{{"a"}, {"b"}}},
+				{Columns: []string{"host"}, Values: [][]interface{}{{"c"}}},
+			}},
+			want: []decodedCPU{{Host: "a"}, {Host: "b"}, {Host: "c"}},
+		},
+		{
+			name:    "fraction into integer",
+			res:     series([]string{"count"}, []interface{}{json.Number("1.5")}),
+			wantErr: "column count: cannot decode 1.5 into int8",
+		},
+		{
+			name:    "overflow",
+			res:     series([]string{"count"}, []interface{}{json.Number("300")}),
+			wantErr: "column count: value 300 overflows int8",
+		},
+		{
+			name:    "negative into unsigned",
+			res:     series([]string{"cores"}, []interface{}{json.Number("-1")}),
+			wantErr: "column cores: cannot decode -1 into uint",
+		},
+		{
+			name:    "string into number",
+			res:     series([]string{"usage"}, []interface{}{"high"}),
+			wantErr: `column usage: cannot decode high into float64`,
+		},
+		{
+			name:    "number into string",
+			res:     series([]string{"host"}, []interface{}{true}),
+			wantErr: "column host: cannot decode bool into string",
+		},
+		{
+			name:    "string into bool",
+			res:     series([]string{"up"}, []interface{}{"maybe"}),
+			wantErr: `column up: cannot decode "maybe" into bool`,
+		},
+		{
+			name:    "bad time",
+			res:     series([]string{"time"}, []interface{}{"yesterday"}),
+			wantErr: `column time: cannot decode "yesterday" into time.Time`,
+		},
+		{
+			name:    "result error",
+			res:     &influx.Result{Err: errors.New("database not found")},
+			wantErr: "database not found",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []decodedCPU
+			err := Unmarshal(tt.res, &got)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalDest(t *testing.T) {
+	res := series([]string{"host"}, []interface{}{"a"})
+
+	// pointers are allocated, and rows appended to existing elements
+	ptrs := []*decodedCPU{{Host: "x"}}
+	if err := Unmarshal(res, &ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 2 || ptrs[1].Host != "a" {
+		t.Fatalf("got %+v", ptrs)
+	}
+
+	var cpus []decodedCPU
+	var ints []int
+	for _, dest := range []interface{}{nil, cpus, &ints, new(int), new(map[int][]decodedCPU)} {
+		if err := Unmarshal(res, dest); err == nil {
+			t.Errorf("%T: no error", dest)
+		}
+	}
+	if err := Unmarshal(res, &ints); err != ErrNotStruct {
+		t.Errorf("got %v, want ErrNotStruct", err)
+	}
+	if err := Unmarshal(nil, &cpus); err == nil {
+		t.Error("nil result: no error")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	res := series([]string{"host", "usage"}, []interface{}{"a", json.Number("1")})
+	var got []decodedCPU
+	if err := NewDecoder().Unmarshal(res, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Host != "a" || got[0].Usage != 1 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	res := series([]string{"host"}, []interface{}{"a"}, []interface{}{"b"})
+	got, err := Decode[decodedCPU](res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Host != "a" || got[1].Host != "b" {
+		t.Fatalf("got %+v", got)
+	}
+
+	ptrs, err := Decode[*decodedCPU](res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 2 || ptrs[1].Host != "b" {
+		t.Fatalf("got %+v", ptrs)
+	}
+
+	if got, err := Decode[decodedCPU](series([]string{"count"}, []interface{}{"x"})); err == nil || got != nil {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	if _, err := Decode[int](res); err != ErrNotStruct {
+		t.Fatalf("got %v, want ErrNotStruct", err)
+	}
+}
+
+func TestUnmarshalGroupByTags(t *testing.T) {
+	type grouped struct {
+		Host  string            `influx:"host,tag"`
+		Core  int               `influx:"core,tag"`
+		Usage float64           `influx:"usage"`
+		Other map[string]string `influx:",tags"`
+	}
+	res := &influx.Result{Series: []models.Row{
+		{
+			Name:    "cpu",
+			Tags:    map[string]string{"host": "a", "core": "1", "region": "eu"},
+			Columns: []string{"time", "usage"},
+			Values:  [][]interface{}{{json.Number("1"), json.Number("0.5")}, {json.Number("2"), json.Number("0.25")}},
+		},
+		{
+			// a column takes precedence over the series tag of the same name
+			Name:    "cpu",
+			Tags:    map[string]string{"host": "b"},
+			Columns: []string{"time", "host", "usage"},
+			Values:  [][]interface{}{{json.Number("3"), "c", json.Number("1")}},
+		},
+	}}
+	var got []grouped
+	if err := Unmarshal(res, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []grouped{
+		{Host: "a", Core: 1, Usage: 0.5, Other: map[string]string{"region": "eu"}},
+		{Host: "a", Core: 1, Usage: 0.25, Other: map[string]string{"region": "eu"}},
+		{Host: "c", Usage: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	res.Series[0].Tags["core"] = "first"
+	if err := Unmarshal(res, &got); err == nil || err.Error() != "tag core: cannot decode first into int" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestUnmarshalTimes(t *testing.T) {
+	type timed struct {
+		Seen *time.Time `influx:"seen"`
+		Time time.Time  `influx:"time,time"`
+	}
+	want := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	for _, tt := range []struct {
+		unit  time.Duration
+		value interface{}
+	}{
+		{time.Nanosecond, "2024-01-02T03:04:05.006Z"},
+		{time.Nanosecond, "2024-01-02T04:04:05.006+01:00"},
+		{time.Nanosecond, json.Number("1704164645006000000")},
+		{time.Nanosecond, "1704164645006000000"},
+		{time.Nanosecond, int64(1704164645006000000)},
+		{time.Nanosecond, want},
+		{time.Microsecond, json.Number("1704164645006000")},
+		{time.Millisecond, json.Number("1704164645006")},
+		{time.Millisecond, float64(1704164645006)},
+		{time.Millisecond, uint64(1704164645006)},
+		{time.Millisecond, json.Number("1.704164645006e12")},
+		// epoch values in units of a second or more are whole
+		{time.Second, json.Number("1704164645")},
+		{time.Minute, json.Number("28402744")},
+	} {
+		res := series([]string{"time", "seen"}, []interface{}{tt.value, tt.value})
+		var got []timed
+		if err := NewDecoder(WithEpochUnit(tt.unit)).Unmarshal(res, &got); err != nil {
+			t.Errorf("%v in %v: %v", tt.value, tt.unit, err)
+			continue
+		}
+		w := want.Truncate(tt.unit)
+		if len(got) != 1 || !got[0].Time.Equal(w) || got[0].Seen == nil || !got[0].Seen.Equal(w) {
+			t.Errorf("%v in %v: got %+v, want %v", tt.value, tt.unit, got, w)
+		}
+	}
+
+	for _, value := range []interface{}{"yesterday", true, json.Number("soon")} {
+		var got []timed
+		if err := Unmarshal(series([]string{"time"}, []interface{}{value}), &got); err == nil {
+			t.Errorf("%v: no error", value)
+		}
+	}
+}
+
+func TestUnmarshalMaps(t *testing.T) {
+	res := &influx.Result{Series: []models.Row{
+		{
+			Name:    "cpu",
+			Tags:    map[string]string{"host": "a"},
+			Columns: []string{"time", "usage", "count", "note", "empty"},
+			Values:  [][]interface{}{{"2024-01-02T03:04:05Z", json.Number("0.5"), json.Number("3"), "x", nil}},
+		},
+		{
+			Name:    "mem",
+			Columns: []string{"time", "free"},
+			Values:  [][]interface{}{{"not a time", json.Number("1")}},
+		},
+	}}
+	var rows []map[string]interface{}
+	if err := Unmarshal(res, &rows); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"host": "a", "time": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "usage": 0.5, "count": int64(3), "note": "x", "empty": nil},
+		// times that cannot be parsed are kept as they are
+		{"time": "not a time", "free": int64(1)},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("got %v, want %v", rows, want)
+	}
+
+	// rows grouped by series name, appended to those already there
+	bySeries := map[string][]map[string]interface{}{"mem": {{"free": int64(0)}}}
+	if err := Unmarshal(res, &bySeries); err != nil {
+		t.Fatal(err)
+	}
+	if len(bySeries) != 2 || len(bySeries["cpu"]) != 1 || len(bySeries["mem"]) != 2 || bySeries["mem"][1]["free"] != int64(1) {
+		t.Fatalf("got %v", bySeries)
+	}
+
+	res.Series[1].Values[0][0] = json.Number("1")
+	var structs map[string][]*decodedCPU
+	if err := Unmarshal(res, &structs); err != nil {
+		t.Fatal(err)
+	}
+	if len(structs) != 2 || structs["cpu"][0].Host != "a" || structs["cpu"][0].Count != 3 || len(structs["mem"]) != 1 {
+		t.Fatalf("got %v", structs)
+	}
+}
+
+func TestDisallowUnknownColumns(t *testing.T) {
+	strict := NewDecoder(DisallowUnknownColumns())
+	var got []decodedCPU
+
+	// the time column is allowed without a member, as are series tags
+	type untimed struct {
+		Usage float64 `influx:"usage"`
+	}
+	res := &influx.Result{Series: []models.Row{{
+		Tags:    map[string]string{"host": "a"},
+		Columns: []string{"time", "usage"},
+		Values:  [][]interface{}{{json.Number("1"), json.Number("2")}},
+	}}}
+	var u []untimed
+	if err := strict.Unmarshal(res, &u); err != nil || len(u) != 1 || u[0].Usage != 2 {
+		t.Fatalf("got %+v, %v", u, err)
+	}
+
+	res = series([]string{"time", "host", "usage_idle"}, []interface{}{nil, "a", json.Number("1")})
+	err := strict.Unmarshal(res, &got)
+	if err == nil || err.Error() != `unknown column "usage_idle" for influxmarshal.decodedCPU` {
+		t.Fatalf("got %v", err)
+	}
+	if err := Unmarshal(res, &got); err != nil || len(got) != 1 || got[0].Host != "a" {
+		t.Fatalf("without DisallowUnknownColumns: got %+v, %v", got, err)
+	}
+
+	// maps take every column
+	var rows []map[string]interface{}
+	if err := strict.Unmarshal(res, &rows); err != nil {
+		t.Fatal(err)
+	}
+}
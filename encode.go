@@ -1,29 +1,126 @@
 package influxmarshal
 
 import (
+	"context"
 	"fmt"
-	"math"
 	"reflect"
-	"strings"
-	"time"
+	"sort"
+	"strconv"
 
+	"github.com/flowchartsman/influxmarshal/internal/core"
 	influx "github.com/influxdata/influxdb1-client"
+	client "github.com/influxdata/influxdb1-client/v2"
 )
 
+// ErrNoFields is returned when a value would produce a point without any
+// fields, which InfluxDB does not accept.
+var ErrNoFields = core.ErrNoFields
+
+// ErrNotStruct is returned when a value to encode or decode is not a struct
+// or a pointer to one.
+var ErrNotStruct = core.ErrNotStruct
+
+// ErrRequired is returned, wrapped with the name of the member, when a member
+// with the "required" option is zero or nil.
+var ErrRequired = core.ErrRequired
+
+// UnsupportedTypeError is returned when a member holds a value that cannot be
+// encoded as a tag or field. Its Field is the path of the member, such as
+// "Stats.CPU" for a member of an inlined struct.
+type UnsupportedTypeError = core.UnsupportedTypeError
+
+// DuplicateKeyError is returned when two members of a struct are encoded
+// with the same key, whether as tags or fields, which would make one
+// overwrite the other. Members promoted from embedded structs are instead
+// resolved as described for Marshal.
+type DuplicateKeyError = core.DuplicateKeyError
+
+// TagSyntaxError is returned when a struct tag holds an option that is not
+// recognized.
+type TagSyntaxError = core.TagSyntaxError
+
 // InfluxValuer is the interface for your type to return a tag or field value
-type InfluxValuer interface {
-	InfluxValue() (value interface{})
+type InfluxValuer = core.InfluxValuer
+
+// InfluxTagValuer is the interface for your type to return its value as a
+// tag. It takes precedence over InfluxValuer for tags, so that a type can
+// render differently as a tag and as a field.
+type InfluxTagValuer = core.InfluxTagValuer
+
+// InfluxFieldValuer is the interface for your type to return its value as a
+// field. It takes precedence over InfluxValuer for fields.
+type InfluxFieldValuer = core.InfluxFieldValuer
+
+// BeforeMarshaler is the interface for your type to be called before it is
+// encoded, such as to populate derived members or validate invariants. An
+// error from BeforeInfluxMarshal is returned unchanged. Pass v as a pointer
+// for changes to its members to be encoded.
+type BeforeMarshaler = core.BeforeMarshaler
+
+// AfterMarshaler is the interface for your type to be called with the point
+// it was encoded as, such as to attach computed tags or check the result. It
+// is only called by the functions returning points, and not those writing
+// line protocol. An error from AfterInfluxMarshal is returned unchanged.
+type AfterMarshaler interface {
+	AfterInfluxMarshal(p *influx.Point) error
+}
+
+// Measurementer is the interface for your type to provide its own
+// measurement name.
+type Measurementer = core.Measurementer
+
+// InfluxTagger is the interface for your type to provide computed tags, such
+// as a size class derived from a member, in addition to those of its
+// members, which take precedence over them. Tags with empty values are
+// skipped.
+type InfluxTagger = core.InfluxTagger
+
+// PointMarshaler is the interface implemented by types that can marshal
+// themselves into a point without reflection, such as those generated by
+// influxmarshalgen. Marshal, and MarshalWithOptions without options, use the
+// MarshalInflux method of v if it has one.
+type PointMarshaler interface {
+	MarshalInflux(measurement string) (influx.Point, error)
 }
 
+// Timestamper is the interface for your type to provide the timestamp of its
+// point. A zero time is treated as no timestamp.
+type Timestamper = core.Timestamper
+
+// Measurement can be embedded in a struct to declare the measurement name
+// with a struct tag:
+//
+//   type CPU struct {
+//       influxmarshal.Measurement `influx:"cpu"`
+//       Usage float64 `influx:"usage"`
+//   }
+//
+// It carries no data and is never encoded as a tag or field.
+type Measurement = core.Measurement
+
+// RetentionPolicy can be embedded in a struct to declare, with a struct tag,
+// the retention policy its points are written to by a Batcher, in the same
+// way as Measurement. It carries no data and is never encoded as a tag or
+// field.
+type RetentionPolicy = core.RetentionPolicy
+
 // Marshal returns an *influx.Point for v.
 //
 // Marshal traverses the first level of v. If an encountered value
 // implements the InfluxValuer or fmt.Stringer interfaces and is not
 // a nil pointer, Marshal will use the returned value to render the
-// tag or field. Nil pointers are skipped.
+// tag or field. Nil pointers are skipped. A value implementing
+// InfluxTagValuer or InfluxFieldValuer uses that interface instead when
+// rendered as a tag or a field respectively. A value implementing
+// encoding.TextMarshaler, such as net.IP, is rendered as the string
+// returned by MarshalText, in preference to fmt.Stringer but not to
+// InfluxValuer. This includes a time.Time that is not the timestamp, which
+// is written in RFC 3339 format. After these, a value implementing
+// driver.Valuer, such as sql.NullInt64, is rendered as the value returned by
+// its Value method, with a NULL skipped like a nil pointer.
 //
 // Otherwise, Marshal supports encoding integers, floats, strings and
-// booleans.
+// booleans. Byte slices, such as json.RawMessage, are encoded as strings.
 //
 // The encoding of each struct field can be customized by the format string
 // stored under the "influx" key in the struct field's tag.
@@ -32,16 +129,80 @@ type InfluxValuer interface {
 // specify options without overriding the default field name.
 //
 // The "omitzero" option specifies that the field should be omitted from the
-// encoding if the field has an zero value as defined by reflect.IsZero() Note:
-// implemented internally until it lands in tip.
-// (ref: https://go-review.googlesource.com/c/go/+/171337/ )
+// encoding if the field has a zero value as defined by reflect.Value.IsZero.
+//
+// The "omitnan" option specifies that a float field should be omitted if it
+// is NaN or infinite, which InfluxDB does not accept. Other such fields are
+// handled according to WithNaNPolicy.
+//
+// The "precision=N" option specifies that a float field should be rounded to
+// N decimal places, such as "precision=2", to avoid storing noise below the
+// resolution of the measurement. It is unrelated to WithPrecision, which
+// applies to timestamps.
+//
+// The "duration=unit" option specifies that an integer field, usually a
+// time.Duration, holds nanoseconds to be written as an integer count of
+// the unit, which is one of "ns", "us", "ms", "s", "m" or "h", truncating
+// any remainder. With "duration=float", it is written as a float count of
+// seconds instead. Without the option, a time.Duration is written using its
+// String method, like other fmt.Stringer values.
+//
+// The "boolint" option specifies that a boolean should be written as the
+// integer 1 or 0, which tags format as "1" and "0", rather than as true or
+// false. Without it, boolean tags have the values "true" and "false".
+//
+// The "timeformat=layout" option specifies that a time.Time field should be
+// written as a string formatted with the layout, as by time.Time.Format,
+// such as "timeformat=2006-01-02" for a date serving as a tag. The layout
+// may also be one of the names "rfc3339", "rfc3339nano", "rfc1123",
+// "rfc1123z", "rfc822", "rfc822z", "ansic", "unixdate", "kitchen" or "date"
+// ("2006-01-02"), for layouts that cannot appear in a struct tag. A zero
+// time is omitted with "omitzero" and rejected with "required".
+//
+// The "string" option specifies that a field should be written as the string
+// returned by its MarshalText or String method, in that order of preference,
+// bypassing InfluxValuer and driver.Valuer. It is an error on a field
+// implementing neither. With WithExplicitStringer, it is the only way
+// fmt.Stringer is used.
+//
+// The "json" option specifies that a field of any type, such as a struct,
+// map or slice, should be written as a string field holding its compact
+// JSON encoding, as by encoding/json, to carry structured context that has
+// no InfluxDB type.
+//
+// The "epoch=unit" option specifies that a time.Time field should be written
+// as an integer count of the unit since the Unix epoch, rather than as the
+// timestamp of the point, such as "epoch=s" for a secondary timestamp like
+// the time of the last restart. The unit is one of "ns", "us", "ms", "s",
+// "m" or "h". A zero time is treated as for "timeformat".
+//
+// The "omitempty" option specifies that the field should be omitted if it is
+// an empty string or byte slice, or nil. Unlike with encoding/json, zero
+// numbers and false are not empty, so counters that are legitimately zero
+// are still written.
+//
+// The "required" option specifies that the field must be set: if it is zero,
+// nil, or a nil pointer, or an InfluxValuer returns nil for it, marshaling
+// fails with an error wrapping ErrRequired rather than producing a point
+// without it, such as one missing the tag identifying its series.
 //
 // The "tag" option specifies that the field is a tag, and the value will be
 // converted to a string, following InfluxDB specifications.
 // (ref: https://docs.influxdata.com/influxdb/v1.7/concepts/key_concepts/#tag-value)
+// Tag values longer than InfluxDB allows, such as those of a runaway
+// fmt.Stringer, can be rejected or truncated with WithSizePolicy.
 // If the "tag" option is not present the field will be treated as an
 // InfluxDB field. (ref: https://docs.influxdata.com/influxdb/v1.7/concepts/key_concepts/#field-value)
 //
+// The "time" option specifies that the field holds the timestamp of the
+// point rather than a tag or field value. The field must be a time.Time or
+// *time.Time. As a special case, an untagged time.Time field named "Time" is
+// treated as if it had the "time" option. If v implements Timestamper, its
+// Timestamp method takes precedence over such a field. If neither provides a
+// timestamp, because the field is absent, nil or holds the zero time, the
+// point is stamped with the time given by WithTime, or else by WithClock,
+// or else time.Now().
+//
 // As a special case, if the field tag is "-", the field is always omitted.
 // Note that a field with name "-" can still be generated using the tag "-,".
 //
@@ -62,172 +223,455 @@ type InfluxValuer interface {
 //	 // will be ommitted if it has a zero value.
 //   Value int `influx:",omitzero"`
 //
+//   // Stamp appears in InfluxDB as the point timestamp.
+//   Stamp time.Time `influx:",time"`
+//
+//   // The members of CPU appear in InfluxDB with keys such as "cpu_user".
+//   CPU CPUStats `influx:"cpu,inline"`
+//
+//   // Each entry in Labels appears in InfluxDB as a tag.
+//   Labels map[string]string `influx:",tags"`
+//
 //   // Value is ignored by this package.
 //   Value int `influx:"-"`
 //
 //   // Value appears in InfluxDB with field key "-".
 //   Value int `influx:"-,"`
 //
-// Anonymous struct fields will be marshaled with their package-local type name unless
-// specified otherwise via tags.
+// The "inline" option specifies that a struct or struct pointer field should
+// be flattened into the point, with each of its members encoded as though it
+// belonged to the outer struct. The keys of the inlined members are prefixed
+// with the name of the field and a separator, which is "_" unless changed
+// with WithSeparator. Members of a nil inlined pointer are skipped, unless
+// it has the "required" option.
+//
+// The "prefix=p" option inlines a struct field like "inline", but with the
+// keys of its members prefixed with p instead of the name of the field and
+// the separator, such as "prefix=net_". An empty prefix, "prefix=", merges
+// the members into the point with their own keys.
 //
-// Pointer values encode as the value pointed to.
+// The "flatten" option specifies that a slice or array field, such as the
+// usage of each CPU core, should be written as one field per element, with
+// keys made of its name, the separator and the index of the element, such
+// as "core_0" and "core_1". With "flatten=Member", the elements are instead
+// labeled by the corresponding elements of Member, a slice or array of
+// strings in the same struct, such as "core_cpu0", which is itself usually
+// omitted with the tag "-".
+//
+// The "tags" option specifies that a map[string]string field holds a dynamic
+// set of tags, which are merged into the point's tags. Similarly, the
+// "fields" option specifies that a map with string keys holds a dynamic set
+// of fields, such as a map[string]float64 or a map[string]interface{} whose
+// values are of supported types. The name of such a field is not used.
+// The "dive" option is like "fields", but prefixes each key with the name of
+// the field and the separator, such as "queue_" for per-queue counters in a
+// map[string]int, or with p if the field also has the "prefix=p" option.
+//
+// An option that is not recognized, such as a misspelled "omitzero", is an
+// error: a *TagSyntaxError is returned the first time the struct type is
+// encoded, rather than the member being encoded other than intended.
+//
+// The exported members of an anonymous struct or struct pointer field are
+// promoted into the point as though they belonged to the outer struct,
+// following the rules of encoding/json: when members have the same key, the
+// least deeply embedded one is used, or the one named by its struct tag if
+// there are several, and otherwise none of them. Members of a nil embedded
+// pointer are skipped, unless it has the "required" option, which makes it an
+// error as for other members. An anonymous field named by its tag, or one
+// implementing InfluxValuer or fmt.Stringer, is encoded as a single member
+// instead, and other anonymous fields are marshaled with their package-local
+// type name unless specified otherwise via tags.
+//
+// Pointer values encode as the value pointed to, and interface values as
+// their dynamic value, following any pointers it holds. Nil pointers and
+// interfaces are skipped.
+//
+// A point must have at least one field, so ErrNoFields is returned if every
+// field of v is omitted or tagged.
+//
+// If measurement is empty, the measurement name is taken from v itself: first
+// from its Measurement method if it implements Measurementer, then from the
+// tag on an embedded Measurement field. It is an error if no name can be
+// found.
 //
 func Marshal(v interface{}, measurement string) (influx.Point, error) {
-	val := reflect.ValueOf(v)
+	return MarshalWithOptions(v, measurement)
+}
 
-	var p influx.Point
+// MarshalWithOptions is like Marshal, but its behavior can be customized with
+// one or more Options.
+func MarshalWithOptions(v interface{}, measurement string, opts ...Option) (influx.Point, error) {
+	if pm, ok := v.(PointMarshaler); ok && len(opts) == 0 {
+		return pm.MarshalInflux(measurement)
+	}
+	o := core.NewOptions(opts)
+	val, err := core.StructValue(v)
+	if err != nil {
+		return influx.Point{}, err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return influx.Point{}, err
+	}
+	return marshal(v, val, info, measurement, o)
+}
+
+// MarshalInto is like MarshalWithOptions, but stores the point in p, reusing
+// its Tags and Fields maps, which are cleared first, to avoid allocating new
+// ones. Together with AcquirePoint and ReleasePoint, this keeps allocations
+// in steady-state encoding to those of converting values to strings and
+// interfaces. On error, the contents of p are unspecified.
+func MarshalInto(p *influx.Point, v interface{}, measurement string, opts ...Option) error {
+	o := core.DefaultOptions
+	if len(opts) > 0 {
+		o = core.NewOptions(opts)
+	}
+	val, err := core.StructValue(v)
+	if err != nil {
+		return err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return err
+	}
+	return marshalInto(p, v, val, info, measurement, o)
+}
 
-	if val.Kind() == reflect.Ptr {
-		if val.IsNil() {
-			return p, fmt.Errorf("value is nil")
+// MarshalContext is like MarshalWithOptions, but adds the tags extracted from
+// ctx by the function given to WithContextTags. They are treated as extra
+// tags, except that those given to WithExtraTags take precedence.
+func MarshalContext(ctx context.Context, v interface{}, measurement string, opts ...Option) (influx.Point, error) {
+	o := core.NewOptions(opts)
+	if o.ContextTags != nil {
+		ctxTags := o.ContextTags(ctx)
+		if o.Tags == nil {
+			o.Tags = make(map[string]string, len(ctxTags))
 		}
-		val = val.Elem()
+		for k, tv := range ctxTags {
+			if _, ok := o.Tags[k]; !ok {
+				o.Tags[k] = tv
+			}
+		}
+	}
+	val, err := core.StructValue(v)
+	if err != nil {
+		return influx.Point{}, err
 	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return influx.Point{}, err
+	}
+	return marshal(v, val, info, measurement, o)
+}
 
-	if val.Kind() != reflect.Struct {
-		// XXX: check interface here, first?
-		return p, fmt.Errorf("not a struct")
+// MarshalV2 is like MarshalWithOptions, but returns a point of the v2
+// client package, ready to be added to a client.BatchPoints. Such points
+// take their precision from the batch, so WithPrecision has no effect.
+func MarshalV2(v interface{}, measurement string, opts ...Option) (*client.Point, error) {
+	p, err := MarshalWithOptions(v, measurement, opts...)
+	if err != nil {
+		return nil, err
 	}
+	return client.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time)
+}
 
-	p.Tags = make(map[string]string)
-	p.Fields = make(map[string]interface{})
-	p.Time = time.Now()
-	p.Measurement = measurement
+// marshal encodes the struct value val, originally passed as v, according to
+// the plan in info.
+func marshal(v interface{}, val reflect.Value, info *core.TypeInfo, measurement string, o *core.Options) (influx.Point, error) {
+	var p influx.Point
+	err := marshalInto(&p, v, val, info, measurement, o)
+	return p, err
+}
 
-	// TODO: Rename
-	vType := val.Type()
+// marshalInto is like marshal, but stores the point in p, reusing its maps.
+func marshalInto(p *influx.Point, v interface{}, val reflect.Value, info *core.TypeInfo, measurement string, o *core.Options) error {
+	errs := core.NewMemberErrors(o)
+	if err := encodeInto(p, v, val, info, measurement, o, &errs); err != nil {
+		return errs.Err(err)
+	}
+	if errs.Failed() {
+		return errs.Err(nil)
+	}
+	return nil
+}
 
-	for i := 0; i < val.NumField(); i++ {
-		f := val.Field(i)
-		structField := vType.Field(i)
+// encodeInto is marshalInto, recording the errors of members in errs and
+// returning any other error.
+func encodeInto(p *influx.Point, v interface{}, val reflect.Value, info *core.TypeInfo, measurement string, o *core.Options, errs *core.MemberErrors) error {
+	if err := core.BeforeMarshal(v); err != nil {
+		return err
+	}
+	if err := info.CheckRequired(val, errs); err != nil {
+		return err
+	}
+	if measurement == "" {
+		measurement = core.StructMeasurement(v, info)
+		if measurement == "" {
+			return fmt.Errorf("no measurement for %s", val.Type())
+		}
+	}
 
-		if structField.PkgPath != "" {
-			continue
+	// the plan gives the exact size, unless there are tags or fields maps
+	if p.Tags == nil {
+		p.Tags = make(map[string]string, len(o.Tags)+len(info.TagOrder))
+	} else {
+		for k := range p.Tags {
+			delete(p.Tags, k)
 		}
-		opts := getOpts(structField)
-		if opts == nil {
-			continue
+	}
+	if p.Fields == nil {
+		p.Fields = make(map[string]interface{}, len(o.Fields)+len(info.FieldOrder))
+	} else {
+		for k := range p.Fields {
+			delete(p.Fields, k)
 		}
-		if f.Kind() == reflect.Ptr {
-			if f.IsNil() {
-				// XXX: Error here? Maybe if omitzero not specified?
-				continue
+	}
+	p.Time = o.Now()
+	p.Precision = core.PrecisionString(o.Precision)
+	p.Measurement = measurement
+	p.Raw = ""
+
+	// extras go in first so that struct members take precedence
+	for k, v := range o.Tags {
+		p.Tags[k] = v
+	}
+	if tagger, ok := v.(InfluxTagger); ok {
+		for k, tv := range tagger.InfluxTags() {
+			if tv != "" {
+				p.Tags[k] = tv
 			}
-			f = f.Elem()
 		}
+	}
+	for k, v := range o.Fields {
+		p.Fields[k] = v
+	}
 
-		val := f.Interface()
-
-		// find out if the type implements InfluxValuer or fmt.Stringer
-		switch v := val.(type) {
-		case InfluxValuer:
-			val = v.InfluxValue()
-		case fmt.Stringer:
-			val = v.String()
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		switch {
+		case fi.TagMap || fi.FieldMap:
+			f, ok := fi.Member(val)
+			if !ok {
+				continue
+			}
+			if err := marshalMap(p, f, fi, o); err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return err
+				}
+			}
+		case fi.Flatten:
+			err := fi.FlatValues(val, o, func(key string, v reflect.Value) {
+				p.Fields[key] = fieldValue(v, o)
+			})
+			if err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return err
+				}
+			}
+		case fi.Time:
+			t, ok, err := fi.TimeValue(val)
+			if err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if ok {
+				p.Time = t
+			}
+		default:
+			var f reflect.Value
+			var ok bool
+			var err error
+			if fi.Tag {
+				f, ok, err = fi.TagValue(val, o)
+			} else {
+				f, ok, err = fi.FieldValue(val, o)
+			}
+			if err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if fi.Tag {
+				// empty values do not override extra tags
+				if tv := tagString(f, o); tv != "" {
+					p.Tags[fi.Name] = tv
+				}
+			} else {
+				p.Fields[fi.Name] = fieldValue(f, o)
+			}
 		}
-
-		// get new reflect.Value
-		// XXX: or move ValueOf call to isZero and similarly for a influx type checking func
-		vv := reflect.ValueOf(val)
-		if opts.omitzero && isZero(vv) {
-			continue
+	}
+	if errs.Failed() {
+		return nil
+	}
+	p.Time = core.StructTimestamp(v, p.Time)
+	if len(p.Fields) == 0 {
+		return ErrNoFields
+	}
+	if o.ValidateNames || o.TagSize != core.SizeIgnore {
+		if err := checkNames(p, o); err != nil {
+			return err
 		}
-
-		// Ensure this is a type Influx can handle
-		switch vv.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
-			// we're good
-		default:
-			return p, fmt.Errorf("Unsupported type for member %s", structField.Name)
+	}
+	if o.Schema != nil {
+		if err := checkSchema(p, o); err != nil {
+			return err
 		}
+	}
+	if am, ok := v.(AfterMarshaler); ok {
+		return am.AfterInfluxMarshal(p)
+	}
+	return nil
+}
 
-		if opts.tag {
-			p.Tags[opts.name] = fmt.Sprint(val)
-		} else {
-			p.Fields[opts.name] = val
+// checkNames returns an error if the measurement, or a tag or field key, of
+// p is one that InfluxDB reserves or is too long, and applies the size policy
+// of o to the tag values of p.
+func checkNames(p *influx.Point, o *core.Options) error {
+	if err := o.CheckName("measurement", p.Measurement); err != nil {
+		return err
+	}
+	for k, v := range p.Tags {
+		if err := o.CheckName("tag key", k); err != nil {
+			return err
+		}
+		v, err := o.LimitTag(k, v)
+		if err != nil {
+			return err
 		}
+		p.Tags[k] = v
 	}
-	return p, nil
+	for k := range p.Fields {
+		if err := o.CheckName("field key", k); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-type fieldOptions struct {
-	name     string
-	omitzero bool
-	tag      bool
+// checkSchema checks the types of the fields of p, in key order, with the
+// SchemaTracker of o.
+func checkSchema(p *influx.Point, o *core.Options) error {
+	fields := make([]core.SchemaField, 0, len(p.Fields))
+	for k, v := range p.Fields {
+		fields = append(fields, core.SchemaField{Key: k, Type: core.ValueType(reflect.ValueOf(v), o)})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Key < fields[j].Key
+	})
+	return o.Schema.Check(p.Measurement, fields)
 }
 
-func getOpts(f reflect.StructField) *fieldOptions {
-	o := &fieldOptions{
-		name: f.Name,
+// tagString returns the tag value of f, formatted as fmt.Sprint would, but
+// without its overhead for the common kinds.
+func tagString(f reflect.Value, o *core.Options) string {
+	switch f.Kind() {
+	case reflect.String:
+		return f.String()
+	case reflect.Slice:
+		if core.IsBytes(f) {
+			return core.BytesString(f.Bytes(), o.UnsafeStrings)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(f.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'g', -1, f.Type().Bits())
+	case reflect.Bool:
+		return strconv.FormatBool(f.Bool())
 	}
-	val, ok := f.Tag.Lookup("influx")
-	if val == "-" {
-		return nil
+	return fmt.Sprint(f.Interface())
+}
+
+// marshalMap merges the map f, a member with the "tags" or "fields" option,
+// into p.
+func marshalMap(p *influx.Point, f reflect.Value, fi *core.FieldInfo, o *core.Options) error {
+	if err := core.CheckMap(f, fi); err != nil {
+		return err
 	}
-	if ok {
-		opts := strings.Split(val, ",")
-		if len(opts) > 0 {
-			switch opts[0] {
-			case "":
-				// retain name
-			default:
-				// otherwise, use this name
-				o.name = opts[0]
+	iter := f.MapRange()
+	for iter.Next() {
+		k, v := iter.Key().String(), iter.Value()
+		if fi.TagMap {
+			if v.String() != "" {
+				p.Tags[k] = v.String()
 			}
-			// process the rest of the options
-			if len(opts) > 1 {
-				for _, opt := range opts[1:] {
-					switch opt {
-					case "omitzero":
-						o.omitzero = true
-					case "tag":
-						o.tag = true
-					default:
-						// TODO?: error reporting here?
-					}
-				}
+			continue
+		}
+		if v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				continue
 			}
+			v = v.Elem()
+		}
+		if !core.SupportedKind(v.Kind()) && !core.IsBytes(v) {
+			return &UnsupportedTypeError{Field: fmt.Sprintf("%s[%q]", fi.GoName, k), Type: v.Type()}
+		}
+		k = fi.MapKey(k)
+		v, ok, err := fi.FiniteValue(v, o)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", k, err)
+		}
+		if ok {
+			p.Fields[k] = fieldValue(v, o)
 		}
 	}
-	return o
+	return nil
 }
 
-// Until https://go-review.googlesource.com/c/go/+/171337/ lands...
-func isZero(v reflect.Value) bool {
-	switch v.Kind() {
-	case reflect.Bool:
-		return !v.Bool()
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return v.Int() == 0
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return v.Uint() == 0
-	case reflect.Float32, reflect.Float64:
-		return math.Float64bits(v.Float()) == 0
-	case reflect.Complex64, reflect.Complex128:
-		c := v.Complex()
-		return math.Float64bits(real(c)) == 0 && math.Float64bits(imag(c)) == 0
-	case reflect.Array:
-		for i := 0; i < v.Len(); i++ {
-			if !isZero(v.Index(i)) {
-				return false
-			}
+// fieldValue returns the field value to store in a point for f, converting
+// integers to float64 if o requires it. Values are extracted according to
+// their kind and boxed only here, which is cheaper than f.Interface() and
+// stores named types as their underlying types.
+func fieldValue(f reflect.Value, o *core.Options) interface{} {
+	if o.ForceFloat {
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(f.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(f.Uint())
 		}
-		return true
-	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice, reflect.UnsafePointer:
-		return v.IsNil()
+	}
+	switch f.Kind() {
+	case reflect.Int:
+		return int(f.Int())
+	case reflect.Int8:
+		return int8(f.Int())
+	case reflect.Int16:
+		return int16(f.Int())
+	case reflect.Int32:
+		return int32(f.Int())
+	case reflect.Int64:
+		return f.Int()
+	case reflect.Uint:
+		return uint(f.Uint())
+	case reflect.Uint8:
+		return uint8(f.Uint())
+	case reflect.Uint16:
+		return uint16(f.Uint())
+	case reflect.Uint32:
+		return uint32(f.Uint())
+	case reflect.Uint64:
+		return f.Uint()
+	case reflect.Float32:
+		return float32(f.Float())
+	case reflect.Float64:
+		return f.Float()
+	case reflect.Bool:
+		return f.Bool()
 	case reflect.String:
-		return v.Len() == 0
-	case reflect.Struct:
-		for i := 0; i < v.NumField(); i++ {
-			if !isZero(v.Field(i)) {
-				return false
-			}
+		return o.LimitString(f.String())
+	case reflect.Slice:
+		if core.IsBytes(f) {
+			return o.LimitString(core.BytesString(f.Bytes(), o.UnsafeStrings))
 		}
-		return true
-	default:
-		// This should never happens, but will act as a safeguard for
-		// later, as a default value doesn't makes sense here.
-		panic(fmt.Sprintf("isZero %v", v.Kind()))
 	}
+	return f.Interface()
 }
@@ -0,0 +1,1339 @@
+package influxmarshal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+func TestMarshalNilInterfaceOmitZero(t *testing.T) {
+	type value struct {
+		X interface{} `influx:"x,omitzero"`
+		Y int         `influx:"y"`
+	}
+	p, err := MarshalWithOptions(value{Y: 1}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Fields["x"]; ok || p.Fields["y"] != 1 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+	if _, err := MarshalLine(value{Y: 1}, "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = MarshalWithOptions(value{X: 2.5, Y: 1}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["x"] != 2.5 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+}
+
+func TestMarshalNilInterface(t *testing.T) {
+	type value struct {
+		X interface{} `influx:"x"`
+		Y int         `influx:"y"`
+	}
+	p, err := Marshal(value{Y: 1}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Fields["x"]; ok {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+	var nilPtr *int
+	if _, err := MarshalLine(value{X: nilPtr, Y: 1}, "m"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type ptrStringer struct{ s string }
+
+func (p *ptrStringer) String() string { return p.s }
+
+func TestMarshalInterfacePointer(t *testing.T) {
+	type value struct {
+		X interface{} `influx:"x"`
+		S interface{} `influx:"s"`
+	}
+	n := 3
+	pn := &n
+	got, err := MarshalLine(value{X: &pn, S: &ptrStringer{"a"}}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m x=3i,s="a" 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshalV2(t *testing.T) {
+	type value struct {
+		Host  string    `influx:"host,tag"`
+		Usage float64   `influx:"usage"`
+		Time  time.Time `influx:",time"`
+	}
+	p, err := MarshalV2(value{Host: "a", Usage: 0.5, Time: time.Unix(1, 0)}, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.String(), "cpu,host=a usage=0.5 1000000000"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if _, err := MarshalV2(value{Host: "a"}, ""); err == nil {
+		t.Fatal("expected error for missing measurement")
+	}
+}
+
+type nilValuer struct{}
+
+func (nilValuer) InfluxValue() interface{} { return nil }
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	type value struct {
+		Count int         `influx:"count,omitempty"`
+		Note  string      `influx:"note,omitempty"`
+		Raw   []byte      `influx:"raw,omitempty"`
+		Any   interface{} `influx:"any,omitempty"`
+		V     nilValuer   `influx:"v,omitempty"`
+		Ok    bool        `influx:"ok,omitempty"`
+	}
+	got, err := MarshalLine(value{}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m count=0i,ok=false 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	got, err = MarshalLine(value{Note: "n", Raw: []byte("r"), Any: 1.5}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m count=0i,note="n",raw="r",any=1.5,ok=false 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshalNaN(t *testing.T) {
+	type value struct {
+		A float64            `influx:"a"`
+		B float32            `influx:"b,omitnan"`
+		M map[string]float64 `influx:"m,fields"`
+		C int                `influx:"c"`
+	}
+	v := value{A: math.NaN(), B: float32(math.Inf(1)), M: map[string]float64{"d": math.Inf(-1)}, C: 1}
+	if _, err := MarshalLine(v, "m"); err == nil {
+		t.Fatal("expected error for NaN field")
+	}
+	if _, err := Marshal(v, "m"); err == nil {
+		t.Fatal("expected error for NaN field")
+	}
+	got, err := MarshalLine(v, "m", WithNaNPolicy(NaNSkip), WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m c=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	got, err = MarshalLine(v, "m", WithNaNPolicy(NaNReplaceWith(-1)), WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m a=-1,d=-1,c=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := MarshalWithOptions(v, "m", WithNaNPolicy(NaNReplaceWith(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Fields["b"]; ok || p.Fields["a"] != 0.0 || p.Fields["d"] != 0.0 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+}
+
+func TestMarshalRequired(t *testing.T) {
+	type value struct {
+		Host  string    `influx:"host,tag,required"`
+		Zone  *string   `influx:"zone,tag,required"`
+		Usage float64   `influx:"usage"`
+		Time  time.Time `influx:",time,required"`
+	}
+	zone := "z"
+	ok := value{Host: "a", Zone: &zone, Time: time.Unix(1, 0)}
+	if _, err := MarshalLine(ok, "m"); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []value{
+		{Zone: &zone, Time: time.Unix(1, 0)},
+		{Host: "a", Time: time.Unix(1, 0)},
+		{Host: "a", Zone: &zone},
+	} {
+		if _, err := MarshalLine(v, "m"); !errors.Is(err, ErrRequired) {
+			t.Errorf("%+v: got error %v from MarshalLine, want ErrRequired", v, err)
+		}
+		if _, err := Marshal(v, "m"); !errors.Is(err, ErrRequired) {
+			t.Errorf("%+v: got error %v from Marshal, want ErrRequired", v, err)
+		}
+	}
+}
+
+func TestMarshalTagSyntax(t *testing.T) {
+	type inner struct {
+		N int `influx:"n,omitzro"`
+	}
+	type value struct {
+		In    inner `influx:"in,inline"`
+		Count int   `influx:"count"`
+	}
+	_, err := Marshal(value{Count: 1}, "m")
+	var serr *TagSyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("got error %v, want TagSyntaxError", err)
+	}
+	if serr.Type != reflect.TypeOf(inner{}) || serr.Member != "N" || serr.Option != "omitzro" {
+		t.Fatalf("got %+v", serr)
+	}
+	if _, err := MarshalLine(value{Count: 1}, "m"); !errors.As(err, &serr) {
+		t.Fatalf("got error %v from MarshalLine, want TagSyntaxError", err)
+	}
+
+	type stray struct {
+		Count int `influx:"count,,omitzero"`
+	}
+	if _, err := Marshal(stray{Count: 1}, "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	// options that are valid, but not on the type of their member
+	tests := []struct {
+		v      interface{}
+		member string
+		option string
+		want   string
+	}{
+		{struct {
+			N int `influx:"n,prefix=x_"`
+		}{}, "N", "prefix=x_", "member is not a struct"},
+		{struct {
+			N int `influx:"n,tag,string"`
+		}{}, "N", "string", "member implements neither fmt.Stringer nor encoding.TextMarshaler"},
+		{struct {
+			N int `influx:"n,timeformat=2006"`
+		}{}, "N", "timeformat=2006", "member is not a time.Time"},
+		{struct {
+			N int `influx:"n,epoch=s"`
+		}{}, "N", "epoch=s", "member is not a time.Time"},
+		{struct {
+			N float64 `influx:"n,duration=s"`
+		}{}, "N", "duration=s", "member is not an integer"},
+		{struct {
+			N int `influx:"n,flatten"`
+		}{}, "N", "flatten", "member is not a slice or array"},
+		{struct {
+			N []int `influx:"n,flatten=L"`
+			L int   `influx:"-"`
+		}{}, "N", "flatten=L", "L is not a slice or array of strings"},
+	}
+	for _, tt := range tests {
+		_, err := Marshal(tt.v, "m")
+		if !errors.As(err, &serr) {
+			t.Fatalf("%T: got error %v, want TagSyntaxError", tt.v, err)
+		}
+		if serr.Type != reflect.TypeOf(tt.v) || serr.Member != tt.member || serr.Option != tt.option || serr.Reason != tt.want {
+			t.Fatalf("%T: got %+v", tt.v, serr)
+		}
+		if _, err := MarshalLine(tt.v, "m"); !errors.As(err, &serr) {
+			t.Fatalf("%T: got error %v from MarshalLine, want TagSyntaxError", tt.v, err)
+		}
+	}
+}
+
+func TestMarshalFloatPrecision(t *testing.T) {
+	type value struct {
+		Jitter float64 `influx:"jitter,precision=2"`
+		Loss   float32 `influx:"loss,precision=1"`
+		Rate   float64 `influx:"rate,tag,precision=0"`
+	}
+	v := value{Jitter: 0.123456, Loss: 2.25, Rate: 9.6}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,rate=10 jitter=0.12,loss=2.3 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["jitter"] != 0.12 || p.Tags["rate"] != "10" {
+		t.Fatalf("got %v %v", p.Tags, p.Fields)
+	}
+
+	type bad struct {
+		X float64 `influx:"x,precision=two"`
+	}
+	var serr *TagSyntaxError
+	if _, err := Marshal(bad{}, "m"); !errors.As(err, &serr) {
+		t.Fatalf("got error %v, want TagSyntaxError", err)
+	}
+}
+
+func TestMarshalDuration(t *testing.T) {
+	type value struct {
+		Latency time.Duration  `influx:"latency,duration=ms"`
+		Uptime  *time.Duration `influx:"uptime,duration=s"`
+		Wait    time.Duration  `influx:"wait,duration=float"`
+		Nanos   int64          `influx:"nanos,duration=us"`
+		Timeout time.Duration  `influx:"timeout"`
+	}
+	uptime := 90 * time.Minute
+	v := value{Latency: 1500 * time.Microsecond, Uptime: &uptime, Wait: 250 * time.Millisecond, Nanos: 2000, Timeout: time.Second}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m latency=1i,uptime=5400i,wait=0.25,nanos=2i,timeout="1s" 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["latency"] != int64(1) || p.Fields["wait"] != 0.25 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+
+	type bad struct {
+		X float64 `influx:"x,duration=s"`
+	}
+	if _, err := Marshal(bad{}, "m"); err == nil {
+		t.Fatal("expected error for duration option on float member")
+	}
+	type badUnit struct {
+		X time.Duration `influx:"x,duration=fortnight"`
+	}
+	var serr *TagSyntaxError
+	if _, err := Marshal(badUnit{}, "m"); !errors.As(err, &serr) {
+		t.Fatalf("got error %v, want TagSyntaxError", err)
+	}
+}
+
+func TestMarshalBoolInt(t *testing.T) {
+	type value struct {
+		Canary  bool `influx:"canary,tag,boolint"`
+		Primary bool `influx:"primary,tag"`
+		Up      bool `influx:"up,boolint"`
+	}
+	v := value{Canary: true, Up: false}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,canary=1,primary=false up=0i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tags["canary"] != "1" || p.Tags["primary"] != "false" || p.Fields["up"] != int64(0) {
+		t.Fatalf("got %v %v", p.Tags, p.Fields)
+	}
+}
+
+func TestMarshalTimeFormat(t *testing.T) {
+	type value struct {
+		Built   time.Time  `influx:"build_date,tag,timeformat=2006-01-02"`
+		Seen    time.Time  `influx:"seen,timeformat=rfc3339"`
+		Expires *time.Time `influx:"expires,timeformat=date,omitzero"`
+		Count   int        `influx:"count"`
+	}
+	v := value{
+		Built: time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC),
+		Seen:  time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC),
+		Count: 1,
+	}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m,build_date=2024-03-09 seen="2024-03-10T08:30:00Z",count=1i 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	v.Expires = &time.Time{}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Fields["expires"]; ok || p.Tags["build_date"] != "2024-03-09" {
+		t.Fatalf("got %v %v", p.Tags, p.Fields)
+	}
+
+	type bad struct {
+		X string `influx:"x,timeformat=date"`
+	}
+	if _, err := Marshal(bad{}, "m"); err == nil {
+		t.Fatal("expected error for timeformat option on non-time member")
+	}
+}
+
+func TestMarshalPrefix(t *testing.T) {
+	type netStats struct {
+		RxBytes int `influx:"rx_bytes"`
+		TxBytes int `influx:"tx_bytes"`
+	}
+	type diskStats struct {
+		Used int `influx:"used"`
+	}
+	type value struct {
+		Net  netStats   `influx:"net,prefix=net_"`
+		Eth  *netStats  `influx:",prefix=eth0."`
+		Disk *diskStats `influx:",prefix="`
+	}
+	v := value{Net: netStats{1, 2}, Eth: &netStats{3, 4}, Disk: &diskStats{5}}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m net_rx_bytes=1i,net_tx_bytes=2i,eth0.rx_bytes=3i,eth0.tx_bytes=4i,used=5i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	type bad struct {
+		X int `influx:"x,prefix=p_"`
+	}
+	if _, err := Marshal(bad{}, "m"); err == nil {
+		t.Fatal("expected error for prefix option on non-struct member")
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	type context struct {
+		Path  string `json:"path"`
+		Retry bool   `json:"retry"`
+	}
+	type value struct {
+		Ctx    context           `influx:"ctx,json"`
+		Labels map[string]string `influx:"labels,json,omitempty"`
+		Codes  []int             `influx:"codes,json"`
+		Count  int               `influx:"count"`
+	}
+	v := value{Ctx: context{Path: "/a&b", Retry: true}, Codes: []int{1, 2}, Count: 1}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m ctx="{\"path\":\"/a&b\",\"retry\":true}",codes="[1,2]",count=1i 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["codes"] != "[1,2]" {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+}
+
+type CommonTags struct {
+	Host   string `influx:"host,tag"`
+	Region string `influx:"region,tag"`
+}
+
+type commonFields struct {
+	Uptime int `influx:"uptime"`
+	Region int `influx:"region_id"`
+}
+
+func TestMarshalEmbedded(t *testing.T) {
+	type value struct {
+		CommonTags
+		*commonFields
+		Region string `influx:"region,tag"`
+		Usage  int    `influx:"usage"`
+	}
+	v := value{CommonTags: CommonTags{Host: "a", Region: "ignored"}, Region: "eu", Usage: 1}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,host=a,region=eu usage=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	type twoLevels struct {
+		commonFields
+		Usage int `influx:"usage"`
+	}
+	p, err := Marshal(twoLevels{commonFields: commonFields{Uptime: 5, Region: 2}, Usage: 1}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["uptime"] != 5 || p.Fields["region_id"] != 2 || p.Fields["usage"] != 1 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+
+	type shadowed struct {
+		CommonTags
+		Other struct {
+			Host string `influx:"host,tag"`
+		} `influx:",prefix="`
+		Usage int `influx:"usage"`
+	}
+	got, err = MarshalLine(shadowed{CommonTags: CommonTags{Host: "a"}, Usage: 1}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m usage=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshalFlatten(t *testing.T) {
+	type value struct {
+		Cores   []float64 `influx:"core,flatten"`
+		Temps   [2]int    `influx:"temp,flatten=Sensors,omitzero"`
+		Sensors []string  `influx:"-"`
+	}
+	v := value{Cores: []float64{0.5, 0.25}, Temps: [2]int{40, 0}, Sensors: []string{"cpu", "gpu"}}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m core_0=0.5,core_1=0.25,temp_cpu=40i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["core_1"] != 0.25 || p.Fields["temp_cpu"] != 40 || len(p.Fields) != 3 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+
+	v.Sensors = v.Sensors[:1]
+	if _, err := MarshalLine(v, "m"); err == nil {
+		t.Fatal("expected error for missing labels")
+	}
+	type bad struct {
+		X int `influx:"x,flatten"`
+	}
+	if _, err := Marshal(bad{}, "m"); err == nil {
+		t.Fatal("expected error for flatten option on non-slice member")
+	}
+}
+
+type selfInlined struct {
+	Value int          `influx:"value"`
+	Next  *selfInlined `influx:"next,inline"`
+}
+
+func TestMarshalInlineDepth(t *testing.T) {
+	if _, err := Marshal(selfInlined{Value: 1}, "m"); err == nil {
+		t.Fatal("expected error for self-inlined struct")
+	}
+
+	type leaf struct {
+		N int `influx:"n"`
+	}
+	type middle struct {
+		Leaf leaf `influx:"leaf,inline"`
+	}
+	type value struct {
+		Middle middle `influx:"middle,inline"`
+	}
+	v := value{middle{leaf{1}}}
+	if _, err := MarshalLine(v, "m", WithMaxDepth(1)); err == nil {
+		t.Fatal("expected error beyond the maximum depth")
+	}
+	got, err := MarshalLine(v, "m", WithMaxDepth(2), WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m middle_leaf_n=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestMarshalEmbeddedPointer(t *testing.T) {
+	type optional struct {
+		*CommonTags
+		Usage int `influx:"usage"`
+	}
+	got, err := MarshalLine(optional{Usage: 1}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m usage=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	got, err = MarshalLine(optional{CommonTags: &CommonTags{Host: "a"}, Usage: 1}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,host=a usage=1i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	type required struct {
+		*CommonTags `influx:",required"`
+		Usage       int `influx:"usage"`
+	}
+	if _, err := MarshalLine(required{Usage: 1}, "m"); !errors.Is(err, ErrRequired) {
+		t.Fatalf("got error %v from MarshalLine, want ErrRequired", err)
+	}
+	if _, err := Marshal(required{Usage: 1}, "m"); !errors.Is(err, ErrRequired) {
+		t.Fatalf("got error %v from Marshal, want ErrRequired", err)
+	}
+	if _, err := Marshal(required{CommonTags: &CommonTags{}, Usage: 1}, "m"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarshalDive(t *testing.T) {
+	type value struct {
+		Queues    map[string]int     `influx:"queue,dive"`
+		Endpoints map[string]float64 `influx:"latency,dive,prefix=ep."`
+	}
+	v := value{Queues: map[string]int{"b": 2, "a": 1}, Endpoints: map[string]float64{"/x": 0.5}}
+	got, err := MarshalLine(v, "m", WithSortedKeys(), WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m ep./x=0.5,queue_a=1i,queue_b=2i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["queue_a"] != 1 || p.Fields["ep./x"] != 0.5 {
+		t.Fatalf("got fields %v", p.Fields)
+	}
+
+	var back value
+	if err := UnmarshalLine(got, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Queues["b"] != 2 || back.Endpoints["/x"] != 0.5 || len(back.Queues) != 2 {
+		t.Fatalf("got %+v", back)
+	}
+}
+
+func TestMarshalKeyJoin(t *testing.T) {
+	type cpu struct {
+		User   float64 `influx:"user"`
+		System float64 `influx:"system"`
+	}
+	type value struct {
+		CPU    cpu            `influx:"cpu,inline"`
+		Queues map[string]int `influx:"queue,dive"`
+	}
+	v := value{CPU: cpu{0.5, 0.25}, Queues: map[string]int{"in": 1}}
+	for _, tc := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, "m cpu_user=0.5,cpu_system=0.25,queue_in=1i 1"},
+		{[]Option{WithSeparator(".")}, "m cpu.user=0.5,cpu.system=0.25,queue.in=1i 1"},
+		{[]Option{WithKeyJoin(JoinCamelCase)}, "m cpuUser=0.5,cpuSystem=0.25,queueIn=1i 1"},
+	} {
+		got, err := MarshalLine(v, "m", append(tc.opts, WithTime(time.Unix(0, 1)))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestMarshalEpoch(t *testing.T) {
+	type value struct {
+		Restart time.Time  `influx:"last_restart,epoch=s"`
+		Seen    *time.Time `influx:"seen,epoch=ms,omitzero"`
+		Time    time.Time
+	}
+	seen := time.Unix(5, 250e6)
+	v := value{Restart: time.Unix(100, 0), Seen: &seen, Time: time.Unix(0, 1)}
+	got, err := MarshalLine(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m last_restart=100i,seen=5250i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	v.Seen = &time.Time{}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Fields["seen"]; ok || p.Fields["last_restart"] != int64(100) || !p.Time.Equal(time.Unix(0, 1)) {
+		t.Fatalf("got %v at %v", p.Fields, p.Time)
+	}
+}
+
+type sizedFile struct {
+	Path string `influx:"path,tag"`
+	Size int    `influx:"size"`
+}
+
+func (f *sizedFile) InfluxTags() map[string]string {
+	class := "small"
+	if f.Size >= 1<<20 {
+		class = "large"
+	}
+	return map[string]string{"size_class": class, "path": "ignored", "empty": ""}
+}
+
+func TestMarshalInfluxTagger(t *testing.T) {
+	v := &sizedFile{Path: "/a", Size: 2 << 20}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)), WithExtraTags(map[string]string{"size_class": "x", "host": "h"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,host=h,path=/a,size_class=large size=2097152i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tags["size_class"] != "large" || p.Tags["path"] != "/a" || len(p.Tags) != 2 {
+		t.Fatalf("got tags %v", p.Tags)
+	}
+}
+
+// level renders as its name when a tag and its number when a field.
+type level int
+
+func (l level) InfluxTagValue() string { return [...]string{"debug", "info", "warn"}[l] }
+
+func (l level) InfluxFieldValue() interface{} { return int64(l) }
+
+func (l level) InfluxValue() interface{} { return "unused" }
+
+// grade only renders differently as a tag, falling back to InfluxValue.
+type grade int
+
+func (g grade) InfluxTagValue() string { return string(rune('A' + g)) }
+
+func (g grade) InfluxValue() interface{} { return int64(g) }
+
+func TestMarshalRoleValuers(t *testing.T) {
+	type entry struct {
+		Level      level `influx:"level,tag"`
+		LevelField level `influx:"level_num"`
+		Grade      grade `influx:"grade,tag"`
+		Score      grade `influx:"score"`
+	}
+	v := entry{Level: 2, LevelField: 1, Grade: 1, Score: 3}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,grade=B,level=warn level_num=1i,score=3i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tags["level"] != "warn" || p.Fields["level_num"] != int64(1) {
+		t.Fatalf("got tags %v, fields %v", p.Tags, p.Fields)
+	}
+}
+
+// status has a debug String method that MarshalText takes precedence over.
+type status int
+
+func (s status) String() string { return fmt.Sprintf("status(%d)", int(s)) }
+
+func (s status) MarshalText() ([]byte, error) {
+	if s < 0 {
+		return nil, errors.New("invalid status")
+	}
+	return []byte([...]string{"ok", "failing"}[s]), nil
+}
+
+func TestMarshalTextMarshaler(t *testing.T) {
+	type check struct {
+		Addr   net.IP `influx:"addr,tag"`
+		Status status `influx:"status"`
+	}
+	v := check{Addr: net.IPv4(10, 0, 0, 1), Status: 1}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m,addr=10.0.0.1 status="failing" 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if _, err := Marshal(check{Addr: v.Addr, Status: -1}, "m"); err == nil {
+		t.Fatal("expected error from MarshalText")
+	}
+}
+
+func TestMarshalDriverValuer(t *testing.T) {
+	type row struct {
+		Region sql.NullString `influx:"region,tag"`
+		Count  sql.NullInt64  `influx:"count"`
+		Ratio  sql.NullFloat64
+	}
+	v := row{
+		Region: sql.NullString{String: "eu", Valid: true},
+		Count:  sql.NullInt64{Int64: 3, Valid: true},
+	}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,region=eu count=3i 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+// debugged has a String method meant for debugging.
+type debugged struct{ n int }
+
+func (d debugged) String() string { return fmt.Sprintf("debugged{%d}", d.n) }
+
+func (d debugged) InfluxValue() interface{} { return int64(d.n) }
+
+func TestMarshalExplicitStringer(t *testing.T) {
+	type value struct {
+		Zone   zone     `influx:"zone"`
+		Named  zone     `influx:"named,string"`
+		Debug  debugged `influx:"debug"`
+		Forced debugged `influx:"forced,string"`
+	}
+	v := value{Zone: 1, Named: 2, Debug: debugged{3}, Forced: debugged{4}}
+	got, err := MarshalLine(v, "m", WithTime(time.Unix(0, 1)), WithExplicitStringer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m zone=1i,named="zone-2",debug=3i,forced="debugged{4}" 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	got, err = MarshalLine(v, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m zone="zone-1",named="zone-2",debug=3i,forced="debugged{4}" 1`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	type bad struct {
+		N int `influx:"n,string"`
+	}
+	if _, err := MarshalLine(bad{}, "m"); err == nil {
+		t.Fatal("expected error for string option on int")
+	}
+}
+
+// zone is an int with a String method.
+type zone int
+
+func (z zone) String() string { return fmt.Sprintf("zone-%d", int(z)) }
+
+type hooked struct {
+	Used  int64   `influx:"used"`
+	Total int64   `influx:"total"`
+	Ratio float64 `influx:"ratio"`
+}
+
+func (h *hooked) BeforeInfluxMarshal() error {
+	if h.Total == 0 {
+		return errors.New("total not set")
+	}
+	h.Ratio = float64(h.Used) / float64(h.Total)
+	return nil
+}
+
+func (h *hooked) AfterInfluxMarshal(p *influx.Point) error {
+	if h.Ratio > 0.9 {
+		p.Tags["alert"] = "true"
+	}
+	return nil
+}
+
+func TestMarshalHooks(t *testing.T) {
+	got, err := MarshalLine(&hooked{Used: 1, Total: 4}, "m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m used=1i,total=4i,ratio=0.25 1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	p, err := Marshal(&hooked{Used: 19, Total: 20}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["ratio"] != 0.95 || p.Tags["alert"] != "true" {
+		t.Fatalf("got tags %v, fields %v", p.Tags, p.Fields)
+	}
+	if _, err := Marshal(&hooked{}, "m"); err == nil || err.Error() != "total not set" {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestEncoderConverter(t *testing.T) {
+	type order struct {
+		ID    *big.Int `influx:"id,tag"`
+		Total big.Rat  `influx:"total"`
+	}
+	e, err := NewEncoder(order{}, WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RegisterConverter(reflect.TypeOf(big.Rat{}), func(v interface{}) (interface{}, error) {
+		r := v.(big.Rat)
+		f, _ := r.Float64()
+		return f, nil
+	})
+	e.RegisterConverter(reflect.TypeOf(big.Int{}), func(v interface{}) (interface{}, error) {
+		n := v.(big.Int)
+		if n.Sign() < 0 {
+			return nil, errors.New("negative id")
+		}
+		return n.Text(16), nil
+	})
+	v := order{ID: big.NewInt(255)}
+	v.Total.SetFrac64(5, 2)
+	b, err := e.AppendLine(nil, &v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,id=ff total=2.5 1\n"; string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+	p, err := e.Encode(&v, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tags["id"] != "ff" || p.Fields["total"] != 2.5 {
+		t.Fatalf("got tags %v, fields %v", p.Tags, p.Fields)
+	}
+	v.ID = big.NewInt(-1)
+	if _, err := e.Encode(&v, "m"); err == nil {
+		t.Fatal("expected error from converter")
+	}
+}
+
+func TestMarshalObservers(t *testing.T) {
+	type reading struct {
+		Host  string   `influx:"host,tag"`
+		Temp  *float64 `influx:"temp"`
+		Count int      `influx:"count,omitzero"`
+		Load  float64  `influx:"load,omitnan"`
+		Ok    bool     `influx:"ok"`
+	}
+	var skipped []string
+	onSkip := WithOnFieldSkipped(func(key string, reason SkipReason) {
+		skipped = append(skipped, key+":"+reason.String())
+	})
+	var failed []string
+	onError := WithOnFieldError(func(key string, err error) {
+		failed = append(failed, key)
+	})
+	v := reading{Load: math.NaN(), Ok: true}
+	if _, err := MarshalLine(v, "m", onSkip, onError); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"host:empty", "temp:nil", "count:zero", "load:nan"}
+	if !reflect.DeepEqual(skipped, want) || failed != nil {
+		t.Fatalf("got skipped %v, failed %v, want skipped %v", skipped, failed, want)
+	}
+
+	type bad struct {
+		Load float64 `influx:"load"`
+	}
+	if _, err := Marshal(bad{math.Inf(1)}, "m"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := MarshalWithOptions(bad{math.Inf(1)}, "m", onError); err == nil || !reflect.DeepEqual(failed, []string{"load"}) {
+		t.Fatalf("got error %v, failed %v", err, failed)
+	}
+}
+
+type tenantKey struct{}
+
+func TestMarshalContext(t *testing.T) {
+	type request struct {
+		Path    string `influx:"path,tag"`
+		Latency int    `influx:"latency"`
+	}
+	fromCtx := WithContextTags(func(ctx context.Context) map[string]string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return map[string]string{"tenant": tenant, "region": "eu"}
+	})
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	p, err := MarshalContext(ctx, request{"/", 5}, "m", fromCtx, WithExtraTags(map[string]string{"region": "us"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"path": "/", "tenant": "acme", "region": "us"}
+	if !reflect.DeepEqual(p.Tags, want) {
+		t.Fatalf("got tags %v, want %v", p.Tags, want)
+	}
+}
+
+func TestMarshalClock(t *testing.T) {
+	type value struct {
+		N int `influx:"n"`
+	}
+	tick := time.Unix(100, 0)
+	clock := WithClock(func() time.Time {
+		tick = tick.Add(time.Second)
+		return tick
+	})
+	e, err := NewEncoder(value{}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"m n=1i 101000000000\n", "m n=1i 102000000000\n"} {
+		b, err := e.AppendLine(nil, value{1}, "m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("got %q, want %q", b, want)
+		}
+	}
+	p, err := MarshalWithOptions(value{1}, "m", clock, WithTime(time.Unix(5, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Time.Equal(time.Unix(5, 0)) {
+		t.Fatalf("got time %v", p.Time)
+	}
+}
+
+func TestMarshalErrorTypes(t *testing.T) {
+	type inner struct {
+		CPU interface{} `influx:"cpu"`
+	}
+	type outer struct {
+		Stats inner `influx:"stats,inline"`
+	}
+	_, err := MarshalLine(outer{inner{struct{}{}}}, "m")
+	var ute *UnsupportedTypeError
+	if !errors.As(err, &ute) || ute.Field != "Stats.CPU" || ute.Type != reflect.TypeOf(struct{}{}) {
+		t.Fatalf("got %v", err)
+	}
+	_, err = MarshalLine(outer{inner{1}}, "m", WithExtraFields(map[string]interface{}{"x": []int{1}}))
+	if !errors.As(err, &ute) || ute.Field != "x" {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := Marshal(1, "m"); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestMarshalAllErrors(t *testing.T) {
+	type bad struct {
+		Host string      `influx:"host,tag,required"`
+		A    interface{} `influx:"a"`
+		B    float64     `influx:"b"`
+		C    int         `influx:"c"`
+	}
+	v := bad{A: struct{}{}, B: math.NaN(), C: 1}
+	_, err := MarshalLine(v, "m")
+	if !errors.Is(err, ErrRequired) {
+		t.Fatalf("got %v", err)
+	}
+	_, lerr := MarshalLine(v, "m", WithAllErrors())
+	_, perr := MarshalWithOptions(v, "m", WithAllErrors())
+	for _, err := range []error{lerr, perr} {
+		var ute *UnsupportedTypeError
+		if !errors.Is(err, ErrRequired) || !errors.As(err, &ute) || ute.Field != "A" {
+			t.Fatalf("got %v", err)
+		}
+		if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+			t.Fatalf("got %d errors: %v", n, err)
+		}
+	}
+}
+
+// countedNaN is a field valuer of NaN that counts its calls.
+type countedNaN struct{ calls *int }
+
+func (c countedNaN) InfluxFieldValue() interface{} {
+	*c.calls++
+	return math.NaN()
+}
+
+func TestMarshalAllErrorsOnePass(t *testing.T) {
+	type bad struct {
+		A countedNaN `influx:"a"`
+		B countedNaN `influx:"b"`
+		C int        `influx:"c"`
+	}
+	var calls int
+	var failed []string
+	v := bad{A: countedNaN{&calls}, B: countedNaN{&calls}, C: 1}
+	opts := []Option{WithAllErrors(), WithOnFieldError(func(key string, err error) {
+		failed = append(failed, key)
+	})}
+	for name, marshal := range map[string]func() error{
+		"line":  func() error { _, err := MarshalLine(v, "m", opts...); return err },
+		"point": func() error { _, err := MarshalWithOptions(v, "m", opts...); return err },
+	} {
+		calls, failed = 0, nil
+		err := marshal()
+		if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+			t.Fatalf("%s: got %d errors: %v", name, n, err)
+		}
+		if calls != 2 || len(failed) != 2 || failed[0] != "a" || failed[1] != "b" {
+			t.Fatalf("%s: got %d calls, errors for %v", name, calls, failed)
+		}
+	}
+}
+
+func TestMarshalNameValidation(t *testing.T) {
+	type value struct {
+		Time  int64 `influx:"time"`
+		Value int   `influx:"value"`
+	}
+	if _, err := MarshalLine(value{1, 2}, "m"); err != nil {
+		t.Fatal(err)
+	}
+	check := WithNameValidation()
+	if _, err := MarshalLine(value{1, 2}, "m", check); !errors.Is(err, ErrReservedName) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := MarshalWithOptions(value{1, 2}, "m", check); !errors.Is(err, ErrReservedName) {
+		t.Fatalf("got %v", err)
+	}
+	type tagged struct {
+		Kind  string `influx:"_kind,tag"`
+		Value int    `influx:"value"`
+	}
+	if _, err := MarshalLine(tagged{"a", 1}, "m", check); !errors.Is(err, ErrReservedName) {
+		t.Fatalf("got %v", err)
+	}
+	type plain struct {
+		Value int `influx:"value"`
+	}
+	if _, err := MarshalWithOptions(plain{1}, "m\n", check); !errors.Is(err, ErrLineBreak) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := MarshalLine(plain{1}, "_m", check); !errors.Is(err, ErrReservedName) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestMarshalDuplicateKey(t *testing.T) {
+	type stats struct {
+		Status int `influx:"status"`
+	}
+	type value struct {
+		Status string `influx:"status,tag"`
+		Stats  stats  `influx:",prefix="`
+	}
+	_, err := MarshalLine(value{}, "m")
+	var dke *DuplicateKeyError
+	if !errors.As(err, &dke) || dke.Key != "status" || dke.Members != [2]string{"Status", "Stats.Status"} {
+		t.Fatalf("got %v", err)
+	}
+
+	// keys may repeat across the points of MarshalMulti
+	type multi struct {
+		Used int `influx:"used"`
+		Mem  int `influx:"used,measurement=mem"`
+	}
+	if _, err := MarshalMulti(multi{1, 2}, "m"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type valid struct {
+		Host  string        `influx:"host,tag"`
+		Load  float64       `influx:"load"`
+		Addr  net.IP        `influx:"addr"`
+		Attrs interface{}   `influx:"attrs"`
+		Up    time.Duration `influx:"up"`
+	}
+	if err := Validate(valid{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(reflect.TypeOf(&valid{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(1); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("got %v", err)
+	}
+
+	type invalid struct {
+		Points []int           `influx:"points"`
+		Time   string          `influx:"_time"`
+		Labels map[string]int  `influx:",tags"`
+		Extra  map[int]float64 `influx:",fields"`
+		Host   string          `influx:"host,tag"`
+		Load   *complex128     `influx:"load"`
+	}
+	err := Validate(invalid{})
+	var ute *UnsupportedTypeError
+	if !errors.As(err, &ute) || ute.Field != "Points" {
+		t.Fatalf("got %v", err)
+	}
+	if !errors.Is(err, ErrReservedName) {
+		t.Fatalf("got %v", err)
+	}
+	for _, want := range []string{"Labels", "Extra", "complex128"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	type malformed struct {
+		Load float64 `influx:"load,omitzeor"`
+	}
+	var tse *TagSyntaxError
+	if err := Validate(malformed{}); !errors.As(err, &tse) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestMarshalSizePolicy(t *testing.T) {
+	long := strings.Repeat("é", MaxNameSize)
+	type value struct {
+		Host string `influx:"host,tag"`
+		Load int    `influx:"load"`
+	}
+	v := value{Host: long, Load: 1}
+	if _, err := MarshalLine(v, "m"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalLine(v, "m", WithSizePolicy(SizeError)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := MarshalWithOptions(v, "m", WithSizePolicy(SizeError)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := MarshalLine(v, "m", WithSizePolicy(SizeError), WithExtraTags(map[string]string{"rack": long})); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("got %v", err)
+	}
+
+	p, err := MarshalWithOptions(v, "m", WithSizePolicy(SizeTruncate("...")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := p.Tags["host"]
+	if len(host) > MaxNameSize || !strings.HasSuffix(host, "é...") || !utf8.ValidString(host) {
+		t.Errorf("got tag of %d bytes ending %q", len(host), host[len(host)-8:])
+	}
+	line, err := MarshalLine(v, "m", WithSizePolicy(SizeTruncate("...")), WithTime(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,host=" + host + " load=1i 0"; line != want {
+		t.Errorf("got line of %d bytes, want %d", len(line), len(want))
+	}
+
+	type longKey struct {
+		Fields map[string]int `influx:",fields"`
+	}
+	k := longKey{Fields: map[string]int{strings.Repeat("k", MaxNameSize+1): 1}}
+	if _, err := MarshalLine(k, "m", WithSizePolicy(SizeTruncate("..."))); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestMarshalMaxStringLength(t *testing.T) {
+	type value struct {
+		Msg  string `influx:"msg"`
+		Raw  []byte `influx:"raw"`
+		Code string `influx:"code"`
+	}
+	v := value{Msg: "disk full on /var/lib", Raw: []byte("ééééé"), Code: "E1"}
+	line, err := MarshalLine(v, "m", WithMaxStringLength(8, "..."), WithTime(time.Unix(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `m msg="disk ...",raw="éé...",code="E1" 0`; line != want {
+		t.Errorf("got %s, want %s", line, want)
+	}
+	p, err := MarshalWithOptions(v, "m", WithMaxStringLength(8, "..."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["msg"] != "disk ..." || p.Fields["raw"] != "éé..." || p.Fields["code"] != "E1" {
+		t.Errorf("got %v", p.Fields)
+	}
+
+	// a marker longer than the limit is shortened without splitting runes
+	p, err = MarshalWithOptions(v, "m", WithMaxStringLength(4, "……"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fields["msg"] != "…" || p.Fields["code"] != "E1" {
+		t.Errorf("got %q", p.Fields)
+	}
+}
+
+func TestMarshalSchemaTracker(t *testing.T) {
+	type v1 struct {
+		Load float64 `influx:"load"`
+		Up   bool    `influx:"up"`
+	}
+	type v2 struct {
+		Load int    `influx:"load"`
+		Up   string `influx:"up"`
+	}
+	s := NewSchemaTracker(nil)
+	enc, err := NewEncoder(v1{}, WithSchemaTracker(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.AppendLine(nil, v1{Load: 1}, "cpu"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = MarshalLine(v2{Load: 1}, "cpu", WithSchemaTracker(s))
+	var sce *SchemaConflictError
+	if !errors.As(err, &sce) || sce.Field != "load" || sce.Type != FieldFloat || sce.Conflict != FieldInteger {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := MarshalWithOptions(v2{Load: 1}, "cpu", WithSchemaTracker(s)); !errors.As(err, &sce) {
+		t.Fatalf("got %v", err)
+	}
+	// the same key may have another type in another measurement
+	if _, err := MarshalLine(v2{Load: 1}, "mem", WithSchemaTracker(s)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalLine(v2{Load: 1}, "cpu", WithSchemaTracker(s), WithForceFloat()); !errors.As(err, &sce) || sce.Field != "up" {
+		t.Fatalf("got %v", err)
+	}
+
+	var conflicts []string
+	warn := NewSchemaTracker(func(err *SchemaConflictError) {
+		conflicts = append(conflicts, err.Error())
+	})
+	for _, v := range []interface{}{v1{}, v2{}, v2{}} {
+		if _, err := MarshalLine(v, "cpu", WithSchemaTracker(warn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"field load of measurement cpu written as integer, but was float",
+		"field up of measurement cpu written as string, but was boolean",
+	}
+	if !reflect.DeepEqual(conflicts[:2], want) || len(conflicts) != 4 {
+		t.Errorf("got %q", conflicts)
+	}
+}
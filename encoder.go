@@ -0,0 +1,111 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// Encoder encodes values of a single struct type, with options fixed when
+// the Encoder is created. The encoding plan for the type is looked up once
+// rather than on every call, and the options are not rebuilt, which makes an
+// Encoder preferable to Marshal when encoding many values of the same type.
+//
+// An Encoder is safe for concurrent use.
+type Encoder struct {
+	typ  reflect.Type
+	info *core.TypeInfo
+	opts *core.Options
+}
+
+// NewEncoder returns an Encoder for the type of v, which may be a struct, a
+// pointer to a struct, or the reflect.Type of either. The options apply to
+// every value encoded.
+func NewEncoder(v interface{}, opts ...Option) (*Encoder, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("value is nil")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	o := core.NewOptions(opts)
+	info, err := core.CompileType(t, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{
+		typ:  t,
+		info: info,
+		opts: o,
+	}, nil
+}
+
+// RegisterConverter registers fn to convert members of type t, or pointers
+// to it, to a tag or field value, such as a decimal type to a float64. It
+// takes precedence over the interfaces t implements, such as InfluxValuer
+// and fmt.Stringer, and an error from fn is returned by the encoding
+// methods. A nil result is treated like a nil InfluxValue. Converters must
+// be registered before the Encoder is used.
+func (e *Encoder) RegisterConverter(t reflect.Type, fn func(v interface{}) (interface{}, error)) {
+	registerConverter(e.opts, t, fn)
+}
+
+// registerConverter adds the converter fn for t to o.
+func registerConverter(o *core.Options, t reflect.Type, fn func(v interface{}) (interface{}, error)) {
+	if o.Converters == nil {
+		o.Converters = make(map[reflect.Type]func(v interface{}) (interface{}, error))
+	}
+	o.Converters[t] = fn
+}
+
+// Encode returns an *influx.Point for v, as Marshal does. v must be of the
+// type the Encoder was created for, or a pointer to it.
+func (e *Encoder) Encode(v interface{}, measurement string) (influx.Point, error) {
+	val, err := e.structValue(v)
+	if err != nil {
+		return influx.Point{}, err
+	}
+	return marshal(v, val, e.info, measurement, e.opts)
+}
+
+// AppendLine appends the line protocol representation of v to dst, as the
+// package-level AppendLine does.
+func (e *Encoder) AppendLine(dst []byte, v interface{}, measurement string) ([]byte, error) {
+	return e.AppendPoint(dst, v, measurement, e.opts.Now())
+}
+
+// AppendPoint appends the line protocol representation of v to dst, as the
+// package-level AppendPoint does. As the struct tags have already been
+// parsed, it does not allocate under the conditions described there.
+func (e *Encoder) AppendPoint(dst []byte, v interface{}, measurement string, t time.Time) ([]byte, error) {
+	val, err := e.structValue(v)
+	if err != nil {
+		return dst, err
+	}
+	b, err := core.AppendStruct(dst, v, val, e.info, measurement, t, e.opts)
+	if err != nil {
+		return dst, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (e *Encoder) structValue(v interface{}) (reflect.Value, error) {
+	val, err := core.StructValue(v)
+	if err != nil {
+		return val, err
+	}
+	if val.Type() != e.typ {
+		return val, fmt.Errorf("encoder for %s cannot encode %s", e.typ, val.Type())
+	}
+	return val, nil
+}
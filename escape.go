@@ -0,0 +1,74 @@
+package influxmarshal
+
+import (
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	"github.com/flowchartsman/influxmarshal/lineprotocol"
+)
+
+// ErrLineBreak is returned when a measurement, tag key, tag value or field
+// key contains a newline or carriage return, which line protocol has no way
+// to escape.
+var ErrLineBreak = core.ErrLineBreak
+
+// ErrReservedName is returned, with WithNameValidation, for a measurement,
+// tag key or field key that InfluxDB reserves: "time", or one beginning with
+// an underscore.
+var ErrReservedName = core.ErrReservedName
+
+// ErrTooLong is returned, with WithSizePolicy, for a measurement, tag key,
+// tag value or field key longer than MaxNameSize.
+var ErrTooLong = core.ErrTooLong
+
+// MaxNameSize is the length in bytes that InfluxDB allows for measurements,
+// tag keys, tag values and field keys.
+const MaxNameSize = lineprotocol.MaxNameSize
+
+// EscapeMeasurement escapes s for use as a measurement name in line
+// protocol. Commas and spaces are escaped with a backslash. Newlines and
+// carriage returns cannot be escaped, and are passed through unchanged; the
+// encoding functions reject them with ErrLineBreak.
+func EscapeMeasurement(s string) string {
+	return lineprotocol.EscapeMeasurement(s)
+}
+
+// EscapeTagKey escapes s for use as a tag key in line protocol. Commas,
+// equals signs and spaces are escaped with a backslash. Line breaks are
+// passed through as described for EscapeMeasurement.
+func EscapeTagKey(s string) string {
+	return lineprotocol.EscapeTagKey(s)
+}
+
+// EscapeTagValue escapes s for use as a tag value in line protocol, following
+// the same rules as EscapeTagKey.
+func EscapeTagValue(s string) string {
+	return lineprotocol.EscapeTagValue(s)
+}
+
+// EscapeFieldKey escapes s for use as a field key in line protocol, following
+// the same rules as EscapeTagKey.
+func EscapeFieldKey(s string) string {
+	return lineprotocol.EscapeFieldKey(s)
+}
+
+// EscapeStringField escapes s for use as a string field value in line
+// protocol. Double quotes and backslashes are escaped with a backslash. The
+// surrounding double quotes are not included.
+func EscapeStringField(s string) string {
+	return lineprotocol.EscapeStringField(s)
+}
+
+// AppendEscapedMeasurement appends s to b escaped as by EscapeMeasurement. It
+// is intended for generated code, such as that of influxmarshalgen.
+func AppendEscapedMeasurement(b []byte, s string) []byte {
+	return lineprotocol.AppendEscapedMeasurement(b, s)
+}
+
+// AppendEscapedTagValue appends s to b escaped as by EscapeTagValue.
+func AppendEscapedTagValue(b []byte, s string) []byte {
+	return lineprotocol.AppendEscapedTagValue(b, s)
+}
+
+// AppendEscapedStringField appends s to b escaped as by EscapeStringField.
+func AppendEscapedStringField(b []byte, s string) []byte {
+	return lineprotocol.AppendEscapedStringField(b, s)
+}
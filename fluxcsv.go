@@ -0,0 +1,215 @@
+package influxmarshal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// DecodeFluxCSV decodes an annotated CSV response from a Flux query, as
+// returned by InfluxDB 2.x, into dest, which must be a pointer to a slice of
+// structs or a slice of struct pointers.
+// (ref: https://docs.influxdata.com/influxdb/v2.0/reference/syntax/annotated-csv/)
+//
+// Rows that share a measurement, tag set, and _time are combined into a
+// single element, with each row's _value stored in the member named by its
+// _field column. If the query pivots fields into columns instead, each
+// column outside the group key is decoded as a field. Columns in the group
+// key are decoded as tags. In both cases, members are matched using the
+// same "influx" struct tags understood by Marshal, and _time is decoded into
+// the timestamp member.
+//
+// The #datatype annotation is required so that values can be decoded into
+// their proper types.
+func DecodeFluxCSV(r io.Reader, dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer to a slice")
+	}
+	sv := dv.Elem()
+	if sv.Kind() != reflect.Slice {
+		return fmt.Errorf("dest must be a pointer to a slice")
+	}
+	elemType := sv.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+
+	points, err := readFluxCSV(r)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		ev := reflect.New(structType)
+		if err := unmarshalPoint(p, ev.Interface()); err != nil {
+			return err
+		}
+		if elemType.Kind() != reflect.Ptr {
+			ev = ev.Elem()
+		}
+		sv.Set(reflect.Append(sv, ev))
+	}
+	return nil
+}
+
+// fluxTable holds the annotations and header of the table being read.
+type fluxTable struct {
+	datatypes []string
+	groups    []bool
+	defaults  []string
+	columns   []string
+}
+
+// readFluxCSV reads all of the tables in r, returning one point per distinct
+// measurement, tag set, and timestamp, in order of first appearance.
+func readFluxCSV(r io.Reader) ([]influx.Point, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	var (
+		t      fluxTable
+		points []influx.Point
+		index  = make(map[string]int)
+	)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch rec[0] {
+		case "#datatype":
+			t = fluxTable{datatypes: append([]string(nil), rec...)}
+			continue
+		case "#group":
+			t.groups = make([]bool, len(rec))
+			for i, g := range rec {
+				t.groups[i] = g == "true"
+			}
+			continue
+		case "#default":
+			t.defaults = append([]string(nil), rec...)
+			continue
+		}
+		if t.columns == nil {
+			if t.datatypes == nil {
+				return nil, fmt.Errorf("missing #datatype annotation")
+			}
+			t.columns = append([]string(nil), rec...)
+			continue
+		}
+
+		p, err := t.point(rec)
+		if err != nil {
+			return nil, err
+		}
+		key := pointKey(p)
+		if i, ok := index[key]; ok {
+			for k, v := range p.Fields {
+				points[i].Fields[k] = v
+			}
+			continue
+		}
+		index[key] = len(points)
+		points = append(points, p)
+	}
+}
+
+// point converts a single data row of t into a point.
+func (t *fluxTable) point(rec []string) (influx.Point, error) {
+	p := influx.Point{
+		Tags:   make(map[string]string),
+		Fields: make(map[string]interface{}),
+	}
+	var (
+		field    string
+		value    interface{}
+		hasField bool
+	)
+	for i, col := range t.columns {
+		if i >= len(rec) {
+			break
+		}
+		s := rec[i]
+		if s == "" && i < len(t.defaults) {
+			s = t.defaults[i]
+		}
+		switch col {
+		case "", "result", "table", "_start", "_stop":
+			continue
+		case "error":
+			if s != "" {
+				return p, fmt.Errorf("flux error: %s", s)
+			}
+			continue
+		}
+		if s == "" {
+			continue
+		}
+		v, err := fluxValue(t.datatype(i), s)
+		if err != nil {
+			return p, fmt.Errorf("column %s: %v", col, err)
+		}
+		switch {
+		case col == "_measurement":
+			p.Measurement = s
+		case col == "_time":
+			tv, ok := v.(time.Time)
+			if !ok {
+				return p, fmt.Errorf("column _time is not a dateTime")
+			}
+			p.Time = tv
+		case col == "_field":
+			field, hasField = s, true
+		case col == "_value":
+			value = v
+		case i < len(t.groups) && t.groups[i]:
+			p.Tags[col] = s
+		default:
+			p.Fields[col] = v
+		}
+	}
+	if hasField && value != nil {
+		p.Fields[field] = value
+	}
+	return p, nil
+}
+
+func (t *fluxTable) datatype(i int) string {
+	if i < len(t.datatypes) {
+		return t.datatypes[i]
+	}
+	return "string"
+}
+
+// fluxValue converts s according to its annotated datatype.
+func fluxValue(datatype, s string) (interface{}, error) {
+	switch datatype {
+	case "long":
+		return strconv.ParseInt(s, 10, 64)
+	case "unsignedLong":
+		return strconv.ParseUint(s, 10, 64)
+	case "double":
+		return strconv.ParseFloat(s, 64)
+	case "boolean":
+		return strconv.ParseBool(s)
+	case "duration":
+		return time.ParseDuration(s)
+	case "dateTime:RFC3339", "dateTime:RFC3339Nano", "dateTime":
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return s, nil
+}
@@ -0,0 +1,96 @@
+package influxmarshal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type fluxCPU struct {
+	Host   string    `influx:"host,tag"`
+	User   float64   `influx:"usage_user"`
+	System float64   `influx:"usage_system"`
+	Time   time.Time `influx:",time"`
+}
+
+func TestDecodeFluxCSVMultiTable(t *testing.T) {
+	const in = `#datatype,string,long,dateTime:RFC3339,double,string,string,string
+#group,false,false,false,false,true,true,true
+#default,_result,,,,,,
+,result,table,_time,_value,_field,_measurement,host
+,,0,2020-01-01T00:00:00Z,1.5,usage_user,cpu,a
+,,0,2020-01-01T00:00:10Z,2.5,usage_user,cpu,a
+
+#datatype,string,long,dateTime:RFC3339,double,string,string,string
+#group,false,false,false,false,true,true,true
+#default,_result,,,,,,
+,result,table,_time,_value,_field,_measurement,host
+,,1,2020-01-01T00:00:00Z,0.5,usage_system,cpu,a
+,,1,2020-01-01T00:00:00Z,9,usage_system,cpu,b
+`
+	var got []fluxCPU
+	if err := DecodeFluxCSV(strings.NewReader(in), &got); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := []fluxCPU{
+		{Host: "a", User: 1.5, System: 0.5, Time: t0},
+		{Host: "a", User: 2.5, Time: t0.Add(10 * time.Second)},
+		{Host: "b", System: 9, Time: t0},
+	}
+	checkFluxCPU(t, got, want)
+}
+
+func TestDecodeFluxCSVPivoted(t *testing.T) {
+	const in = `#datatype,string,long,dateTime:RFC3339,string,string,double,double
+#group,false,false,false,true,true,false,false
+#default,_result,,,,,,
+,result,table,_time,_measurement,host,usage_user,usage_system
+,,0,2020-01-01T00:00:00Z,cpu,a,1.5,0.5
+,,0,2020-01-01T00:00:10Z,cpu,a,2.5,
+`
+	var got []*fluxCPU
+	if err := DecodeFluxCSV(strings.NewReader(in), &got); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := []fluxCPU{
+		{Host: "a", User: 1.5, System: 0.5, Time: t0},
+		{Host: "a", User: 2.5, Time: t0.Add(10 * time.Second)},
+	}
+	var vals []fluxCPU
+	for _, v := range got {
+		vals = append(vals, *v)
+	}
+	checkFluxCPU(t, vals, want)
+}
+
+func TestDecodeFluxCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"missing datatype", ",result,table,_value\n,,0,1\n"},
+		{"flux error", "#datatype,string,string\n,error,reference\n,query failed,\n"},
+		{"bad value", "#datatype,string,long,double\n,result,table,_value\n,,0,x\n"},
+	}
+	for _, tt := range tests {
+		var got []fluxCPU
+		if err := DecodeFluxCSV(strings.NewReader(tt.in), &got); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func checkFluxCPU(t *testing.T, got, want []fluxCPU) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Host != want[i].Host || got[i].User != want[i].User ||
+			got[i].System != want[i].System || !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("row %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
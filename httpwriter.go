@@ -0,0 +1,277 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default retry settings of an HTTPWriter.
+const (
+	DefaultMaxRetries = 5
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// HTTPError is returned by an HTTPWriter when the server rejects a batch.
+type HTTPError struct {
+	StatusCode int
+	// Message is the body of the response, which describes the error
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("write failed: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("write failed: %s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// retryable reports whether the request may succeed if repeated.
+func (e *HTTPError) retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Retryable reports whether a write that failed with err may succeed if it
+// is repeated: if err is, or wraps, an *HTTPError with a status indicating a
+// temporary condition, such as 503 Service Unavailable, or a network error,
+// such as a timeout. Other errors, such as a 400 Bad Request for a field type
+// conflict, are permanent.
+func Retryable(err error) bool {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.retryable()
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// HTTPWriter writes batches of line protocol to the HTTP write API of an
+// InfluxDB server, without a client library. Each call to Write sends one
+// batch, so it is suited to being the underlying writer of a LineWriter, or
+// to writing the batches of a Collector.
+//
+// Batches that fail because of a network error or a status that indicates a
+// temporary condition, such as 429 Too Many Requests or 503 Service
+// Unavailable, are retried with exponential backoff, waiting instead for the
+// time given by a Retry-After header if there is one, up to the maximum
+// backoff.
+//
+// An HTTPWriter is safe for concurrent use.
+type HTTPWriter struct {
+	client     *http.Client
+	url        string
+	header     http.Header
+	gzip       bool
+	level      int
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// HTTPWriterOption customizes an HTTPWriter.
+type HTTPWriterOption func(*HTTPWriter)
+
+// WithToken authenticates the requests of an HTTPWriter with an API token,
+// as used by InfluxDB 2.x and 3.
+func WithToken(token string) HTTPWriterOption {
+	return func(w *HTTPWriter) {
+		w.header.Set("Authorization", "Token "+token)
+	}
+}
+
+// WithBasicAuth authenticates the requests of an HTTPWriter with a username
+// and password, as used by InfluxDB 1.x.
+func WithBasicAuth(username, password string) HTTPWriterOption {
+	return func(w *HTTPWriter) {
+		r := http.Request{Header: http.Header{}}
+		r.SetBasicAuth(username, password)
+		w.header.Set("Authorization", r.Header.Get("Authorization"))
+	}
+}
+
+// WithHTTPClient sets the client used to send requests. The default is
+// http.DefaultClient.
+func WithHTTPClient(c *http.Client) HTTPWriterOption {
+	return func(w *HTTPWriter) {
+		w.client = c
+	}
+}
+
+// WithHTTPGzip causes an HTTPWriter to compress each batch at the given
+// compression level, such as gzip.BestSpeed. Batches compressed by a
+// LineWriter with WithGzip must not be compressed again.
+func WithHTTPGzip(level int) HTTPWriterOption {
+	return func(w *HTTPWriter) {
+		w.gzip = true
+		w.level = level
+	}
+}
+
+// WithRetry sets the number of times a batch is retried, and the bounds of
+// the exponential backoff between attempts. A maxRetries of zero disables
+// retries. The defaults are DefaultMaxRetries, DefaultMinBackoff and
+// DefaultMaxBackoff.
+func WithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) HTTPWriterOption {
+	return func(w *HTTPWriter) {
+		w.maxRetries = maxRetries
+		w.minBackoff = minBackoff
+		w.maxBackoff = maxBackoff
+	}
+}
+
+// NewHTTPWriter returns an HTTPWriter writing to target on the server at
+// base, such as "http://localhost:8086".
+func NewHTTPWriter(base string, target WriteTarget, opts ...HTTPWriterOption) (*HTTPWriter, error) {
+	url, err := target.URL(base)
+	if err != nil {
+		return nil, err
+	}
+	w := &HTTPWriter{
+		client:     http.DefaultClient,
+		url:        url,
+		header:     http.Header{},
+		maxRetries: DefaultMaxRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	w.header.Set("Content-Type", "text/plain; charset=utf-8")
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.gzip {
+		w.header.Set("Content-Encoding", "gzip")
+	}
+	return w, nil
+}
+
+// Write sends p, a batch of whole lines, to the server, as WriteContext does
+// with a background context.
+func (w *HTTPWriter) Write(p []byte) (int, error) {
+	if err := w.WriteContext(context.Background(), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteContext sends batch, which must consist of whole lines, to the
+// server, retrying as described for HTTPWriter until ctx is done. A batch the
+// server rejects outright is reported by an *HTTPError.
+func (w *HTTPWriter) WriteContext(ctx context.Context, batch []byte) error {
+	if w.gzip {
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, w.level)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(batch); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		batch = buf.Bytes()
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		if he, ok := err.(*HTTPError); (ok && !he.retryable()) || attempt >= w.maxRetries || ctx.Err() != nil {
+			return err
+		}
+		wait, ok := retryAfter(retry, w.maxBackoff)
+		if !ok {
+			wait = w.backoff(attempt)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// backoff returns the time to wait before the retry following attempt.
+func (w *HTTPWriter) backoff(attempt int) time.Duration {
+	return backoff(attempt, w.minBackoff, w.maxBackoff)
+}
+
+// backoff returns the time to wait before the retry following attempt,
+// doubling from min up to max.
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// post sends a single request, returning the Retry-After header of the
+// response, if any, along with the error.
+func (w *HTTPWriter) post(ctx context.Context, body []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return "", nil
+	}
+	return resp.Header.Get("Retry-After"), &HTTPError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(msg)),
+	}
+}
+
+// retryAfter returns the wait given by the value of a Retry-After header,
+// either a number of seconds or a date, limited to between 0, for a date
+// that has passed, and max. It reports false if v is empty or malformed.
+func retryAfter(v string, max time.Duration) (time.Duration, bool) {
+	var d time.Duration
+	if s, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if s > int64(max/time.Second) {
+			return max, true
+		}
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > max {
+		d = max
+	}
+	return d, true
+}
@@ -0,0 +1,112 @@
+package influxmarshal
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPWriterRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "b" || r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if b, _ := io.ReadAll(zr); string(b) != "m v=1i\n" {
+				t.Errorf("got body %q", b)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	w, err := NewHTTPWriter(srv.URL, WriteTarget{API: WriteAPIv3, Database: "b"},
+		WithToken("secret"),
+		WithHTTPGzip(gzip.BestSpeed),
+		WithRetry(3, time.Millisecond, 10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("m v=1i\n")); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("got %d requests, want 3", calls)
+	}
+}
+
+func TestHTTPWriterReject(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		user, pass, _ := r.BasicAuth()
+		if user != "u" || pass != "p" {
+			t.Errorf("got credentials %q, %q", user, pass)
+		}
+		http.Error(w, `{"error":"unable to parse"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w, err := NewHTTPWriter(srv.URL, WriteTarget{Database: "db"}, WithBasicAuth("u", "p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteContext(context.Background(), []byte("bad\n"))
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusBadRequest || he.Message != `{"error":"unable to parse"}` {
+		t.Fatalf("got error %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d requests, want 1", calls)
+	}
+}
+
+func TestHTTPWriterBackoff(t *testing.T) {
+	w := &HTTPWriter{minBackoff: time.Second, maxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := w.backoff(attempt); got != want {
+			t.Errorf("attempt %d: got %s, want %s", attempt, got, want)
+		}
+	}
+
+	max := 10 * time.Second
+	tests := []struct {
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"soon", 0, false},
+		{"3", 3 * time.Second, true},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"3600", max, true},
+		{"99999999999999999", max, true},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), max, true},
+	}
+	for _, tt := range tests {
+		if got, ok := retryAfter(tt.v, max); got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q): got %s, %v, want %s, %v", tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
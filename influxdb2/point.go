@@ -0,0 +1,35 @@
+// Package influxdb2 adapts influxmarshal to the InfluxDB 2.x client,
+// github.com/influxdata/influxdb-client-go/v2, so that the same struct tags
+// can be written to buckets through its write APIs. It is a separate module
+// so that only its users depend on that client.
+package influxdb2
+
+import (
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// MarshalV2Point is like influxmarshal.MarshalWithOptions, but returns a
+// point for the WritePoint methods of the InfluxDB 2.x write APIs. Such
+// points take their precision from the write options of the client, so
+// WithPrecision has no effect.
+func MarshalV2Point(v interface{}, measurement string, opts ...influxmarshal.Option) (*write.Point, error) {
+	p, err := influxmarshal.MarshalWithOptions(v, measurement, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return write.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time), nil
+}
+
+// Sink returns an influxmarshal.PointSink writing the points passed to it to
+// w, the non-blocking write API of an InfluxDB 2.x client. Errors are
+// reported by w.Errors rather than by the sink.
+func Sink(w api.WriteAPI) influxmarshal.PointSink {
+	return influxmarshal.PointSinkFunc(func(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+		w.WritePoint(write.NewPoint(measurement, tags, fields, t))
+		return nil
+	})
+}
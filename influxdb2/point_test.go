@@ -0,0 +1,68 @@
+package influxdb2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+type cpu struct {
+	Host  string    `influx:"host,tag"`
+	Usage float64   `influx:"usage"`
+	Count int       `influx:"count"`
+	Time  time.Time `influx:",time"`
+}
+
+func checkPoint(t *testing.T, p *write.Point, at time.Time) {
+	t.Helper()
+	if p.Name() != "cpu" || !p.Time().Equal(at) {
+		t.Fatalf("got %s at %v", p.Name(), p.Time())
+	}
+	tags := p.TagList()
+	if len(tags) != 1 || tags[0].Key != "host" || tags[0].Value != "a" {
+		t.Fatalf("got tags %v", tags)
+	}
+	// the client converts integers to int64 and sorts fields by key
+	fields := p.FieldList()
+	if len(fields) != 2 || fields[0].Key != "count" || fields[0].Value != int64(2) || fields[1].Key != "usage" || fields[1].Value != 0.5 {
+		t.Fatalf("got fields %v", fields)
+	}
+}
+
+func TestMarshalV2Point(t *testing.T) {
+	at := time.Unix(100, 0)
+	p, err := MarshalV2Point(cpu{Host: "a", Usage: 0.5, Count: 2, Time: at}, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoint(t, p, at)
+
+	if _, err := MarshalV2Point(struct{}{}, "cpu"); err == nil {
+		t.Fatal("expected error for a struct without fields")
+	}
+}
+
+// writeAPI records the points written to it. Its other methods are not used.
+type writeAPI struct {
+	api.WriteAPI
+	points []*write.Point
+}
+
+func (w *writeAPI) WritePoint(p *write.Point) {
+	w.points = append(w.points, p)
+}
+
+func TestSink(t *testing.T) {
+	at := time.Unix(100, 0)
+	var w writeAPI
+	if err := influxmarshal.MarshalTo(Sink(&w), cpu{Host: "a", Usage: 0.5, Count: 2, Time: at}, "cpu"); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.points) != 1 {
+		t.Fatalf("got %d points", len(w.points))
+	}
+	checkPoint(t, w.points[0], at)
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// SetDecoded reverses the conversion of the "epoch", "timeformat",
+// "duration", "boolint" or "json" option of the member for src, a value read
+// back from InfluxDB, and stores the result in dst, the member itself. It
+// reports false if the member has none of those options, in which case src
+// is stored as it is.
+func (fi *FieldInfo) SetDecoded(dst reflect.Value, src interface{}) (bool, error) {
+	switch {
+	case fi.Time:
+		// the timestamp of the point, whatever its options
+		return false, nil
+	case fi.epoch != 0:
+		n, err := decodedInt(src)
+		if err != nil {
+			return true, err
+		}
+		dst.Set(reflect.ValueOf(epochTime(n, fi.epoch)))
+	case fi.timeFormat != "":
+		s, ok := src.(string)
+		if !ok {
+			return true, fmt.Errorf("cannot decode %T into %s with timeformat option", src, dst.Type())
+		}
+		t, err := time.Parse(fi.timeFormat, s)
+		if err != nil {
+			return true, err
+		}
+		dst.Set(reflect.ValueOf(t))
+	case fi.durationUnit != 0:
+		var d time.Duration
+		if fi.durationUnit == durationFloat {
+			s, err := decodedText(src)
+			if err != nil {
+				return true, err
+			}
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return true, fmt.Errorf("cannot decode %s into %s", s, dst.Type())
+			}
+			d = time.Duration(math.Round(f * float64(time.Second)))
+		} else {
+			n, err := decodedInt(src)
+			if err != nil {
+				return true, err
+			}
+			d = time.Duration(n) * fi.durationUnit
+		}
+		if dst.OverflowInt(int64(d)) {
+			return true, fmt.Errorf("value %v overflows %s", d, dst.Type())
+		}
+		dst.SetInt(int64(d))
+	case fi.boolInt && dst.Kind() == reflect.Bool:
+		n, err := decodedInt(src)
+		if err != nil {
+			return true, err
+		}
+		dst.SetBool(n != 0)
+	case fi.json:
+		s, ok := src.(string)
+		if !ok {
+			return true, fmt.Errorf("cannot decode %T into %s with json option", src, dst.Type())
+		}
+		if err := json.Unmarshal([]byte(s), dst.Addr().Interface()); err != nil {
+			return true, err
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// decodedText returns the text of src, a number or string read back from
+// InfluxDB.
+func decodedText(src interface{}) (string, error) {
+	switch v := src.(type) {
+	case json.Number:
+		return string(v), nil
+	case string:
+		// tags are always strings
+		return v, nil
+	}
+	sv := reflect.ValueOf(src)
+	switch sv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(sv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(sv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(sv.Float(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("cannot decode %T as a number", src)
+}
+
+// decodedInt returns src, an integer read back from InfluxDB, as an int64.
+func decodedInt(src interface{}) (int64, error) {
+	s, err := decodedText(src)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// integral floats are allowed, as JSON numbers may be written so
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != float64(int64(f)) {
+			return 0, fmt.Errorf("cannot decode %s as an integer", s)
+		}
+		n = int64(f)
+	}
+	return n, nil
+}
+
+// epochTime returns the time n units after the Unix epoch, reversing
+// epochValue.
+func epochTime(n int64, unit time.Duration) time.Time {
+	switch unit {
+	case time.Nanosecond:
+		return time.Unix(0, n)
+	case time.Microsecond:
+		return time.UnixMicro(n)
+	case time.Millisecond:
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n*int64(unit/time.Second), 0)
+}
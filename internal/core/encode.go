@@ -0,0 +1,1457 @@
+package core
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ErrNoFields is returned when a value would produce a point without any
+// fields, which InfluxDB does not accept.
+var ErrNoFields = errors.New("point has no fields")
+
+// ErrNotStruct is returned when a value to encode or decode is not a struct
+// or a pointer to one.
+var ErrNotStruct = errors.New("not a struct")
+
+// ErrRequired is returned, wrapped with the name of the member, when a member
+// with the "required" option is zero or nil.
+var ErrRequired = errors.New("required member not set")
+
+// InfluxValuer is the interface for your type to return a tag or field value
+type InfluxValuer interface {
+	InfluxValue() (value interface{})
+}
+
+// InfluxTagValuer is the interface for your type to return its value as a
+// tag. It takes precedence over InfluxValuer for tags.
+type InfluxTagValuer interface {
+	InfluxTagValue() string
+}
+
+// InfluxFieldValuer is the interface for your type to return its value as a
+// field. It takes precedence over InfluxValuer for fields.
+type InfluxFieldValuer interface {
+	InfluxFieldValue() (value interface{})
+}
+
+// BeforeMarshaler is the interface for your type to be called before it is
+// encoded, such as to populate derived members or validate invariants.
+type BeforeMarshaler interface {
+	BeforeInfluxMarshal() error
+}
+
+// BeforeMarshal calls the BeforeInfluxMarshal method of v if it has one.
+func BeforeMarshal(v interface{}) error {
+	if bm, ok := v.(BeforeMarshaler); ok {
+		return bm.BeforeInfluxMarshal()
+	}
+	return nil
+}
+
+// Measurementer is the interface for your type to provide its own
+// measurement name.
+type Measurementer interface {
+	Measurement() string
+}
+
+// Timestamper is the interface for your type to provide the timestamp of its
+// point. A zero time is treated as no timestamp.
+type Timestamper interface {
+	Timestamp() time.Time
+}
+
+// InfluxTagger is the interface for your type to provide computed tags in
+// addition to those of its members, which take precedence over them. Tags
+// with empty values are skipped.
+type InfluxTagger interface {
+	InfluxTags() map[string]string
+}
+
+// Measurement can be embedded in a struct to declare the measurement name
+// with a struct tag:
+//
+//	type CPU struct {
+//	    influxmarshal.Measurement `influx:"cpu"`
+//	    Usage float64 `influx:"usage"`
+//	}
+//
+// It carries no data and is never encoded as a tag or field.
+type Measurement struct{}
+
+var measurementType = reflect.TypeOf(Measurement{})
+
+// RetentionPolicy can be embedded in a struct to declare, with a struct tag,
+// the retention policy its points are written to by a Batcher, in the same
+// way as Measurement. It carries no data and is never encoded as a tag or
+// field.
+type RetentionPolicy struct{}
+
+var retentionPolicyType = reflect.TypeOf(RetentionPolicy{})
+
+// StructValue returns the struct value held by v, following a pointer if
+// necessary.
+func StructValue(v interface{}) (reflect.Value, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return val, fmt.Errorf("value is nil")
+		}
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		// XXX: check interface here, first?
+		return val, ErrNotStruct
+	}
+	return val, nil
+}
+
+// SupportedKind reports whether values of kind k can be stored in InfluxDB.
+func SupportedKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// IsBytes reports whether f is a byte slice, which is encoded as a string.
+func IsBytes(f reflect.Value) bool {
+	return f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8
+}
+
+// BytesString returns b as a string. If noCopy is set, the string shares
+// memory with b, and is only valid while b is unmodified.
+func BytesString(b []byte, noCopy bool) string {
+	if noCopy {
+		return *(*string)(unsafe.Pointer(&b))
+	}
+	return string(b)
+}
+
+// StructMeasurement returns the measurement name declared by v, whose
+// encoding plan is info, or "" if there is none.
+func StructMeasurement(v interface{}, info *TypeInfo) string {
+	if m, ok := v.(Measurementer); ok {
+		return m.Measurement()
+	}
+	return info.measurement
+}
+
+// StructTimestamp returns the timestamp provided by v through Timestamper,
+// or t if there is none.
+func StructTimestamp(v interface{}, t time.Time) time.Time {
+	if ts, ok := v.(Timestamper); ok {
+		if vt := ts.Timestamp(); !vt.IsZero() {
+			return vt
+		}
+	}
+	return t
+}
+
+var (
+	valuerType          = reflect.TypeOf((*InfluxValuer)(nil)).Elem()
+	tagValuerType       = reflect.TypeOf((*InfluxTagValuer)(nil)).Elem()
+	fieldValuerType     = reflect.TypeOf((*InfluxFieldValuer)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	driverValuerType    = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	MeasurementerType   = reflect.TypeOf((*Measurementer)(nil)).Elem()
+	TimestamperType     = reflect.TypeOf((*Timestamper)(nil)).Elem()
+	InfluxTaggerType    = reflect.TypeOf((*InfluxTagger)(nil)).Elem()
+	BeforeMarshalerType = reflect.TypeOf((*BeforeMarshaler)(nil)).Elem()
+)
+
+// Member returns the member fi of the struct val, following pointers. It
+// reports false if the member is, or is inside, a nil pointer.
+func (fi *FieldInfo) Member(val reflect.Value) (reflect.Value, bool) {
+	f, ok := FieldByIndex(val, fi.Index)
+	if !ok {
+		// inside a nil inlined struct
+		return f, false
+	}
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			// XXX: Error here? Maybe if omitzero not specified?
+			return f, false
+		}
+		f = f.Elem()
+	}
+	return f, true
+}
+
+// valueSource identifies the interface, if any, that provides the value of a
+// member in place of the member itself.
+type valueSource int
+
+const (
+	sourceNone valueSource = iota
+	sourceTagValuer
+	sourceFieldValuer
+	sourceValuer
+	sourceText
+	sourceDriver
+	sourceStringer
+)
+
+// sourceOf returns the interface that provides the value of a member of type
+// t, as a field if field is set and as a tag otherwise. The interface for
+// the role comes first, then InfluxValuer, encoding.TextMarshaler,
+// driver.Valuer and fmt.Stringer.
+func sourceOf(t reflect.Type, field bool) valueSource {
+	switch {
+	case !field && t.Implements(tagValuerType):
+		return sourceTagValuer
+	case field && t.Implements(fieldValuerType):
+		return sourceFieldValuer
+	case t.Implements(valuerType):
+		return sourceValuer
+	case t.Implements(textMarshalerType):
+		return sourceText
+	case t.Implements(driverValuerType):
+		return sourceDriver
+	case t.Implements(stringerType):
+		return sourceStringer
+	}
+	return sourceNone
+}
+
+// stringSource returns the interface that provides the value of a member of
+// type t with the "string" option: encoding.TextMarshaler, then fmt.Stringer.
+func stringSource(t reflect.Type) valueSource {
+	switch {
+	case t.Implements(textMarshalerType):
+		return sourceText
+	case t.Implements(stringerType):
+		return sourceStringer
+	}
+	return sourceNone
+}
+
+// sourceOf is like the function sourceOf, applying the "string" option of fi
+// and ignoring fmt.Stringer unless it may be used implicitly.
+func (fi *FieldInfo) sourceOf(t reflect.Type, field bool) valueSource {
+	if fi.str {
+		return stringSource(t)
+	}
+	s := sourceOf(t, field)
+	if s == sourceStringer && !fi.stringer {
+		return sourceNone
+	}
+	return s
+}
+
+// value calls the interface s on iv.
+func (s valueSource) value(iv interface{}) (reflect.Value, error) {
+	switch s {
+	case sourceTagValuer:
+		return reflect.ValueOf(iv.(InfluxTagValuer).InfluxTagValue()), nil
+	case sourceFieldValuer:
+		return reflect.ValueOf(iv.(InfluxFieldValuer).InfluxFieldValue()), nil
+	case sourceValuer:
+		return reflect.ValueOf(iv.(InfluxValuer).InfluxValue()), nil
+	case sourceText:
+		text, err := iv.(encoding.TextMarshaler).MarshalText()
+		return reflect.ValueOf(string(text)), err
+	case sourceDriver:
+		dv, err := iv.(driver.Valuer).Value()
+		if t, ok := dv.(time.Time); ok {
+			// the one driver.Value that is not a primitive
+			dv = t.Format(time.RFC3339Nano)
+		}
+		return reflect.ValueOf(dv), err
+	}
+	return reflect.ValueOf(iv.(fmt.Stringer).String()), nil
+}
+
+// TagValue returns the tag value of the member fi of the struct val, after
+// applying a converter registered in o, or else InfluxTagValuer,
+// InfluxValuer, encoding.TextMarshaler, driver.Valuer or fmt.Stringer. It
+// reports false if the member should be omitted.
+func (fi *FieldInfo) TagValue(val reflect.Value, o *Options) (reflect.Value, bool, error) {
+	f, ok, err := fi.value(val, false, o)
+	if err != nil {
+		return f, false, fi.failed(o, err)
+	}
+	if ok && emptyString(f) {
+		// line protocol has no empty tag values
+		return f, false, fi.skip(o, SkipEmpty)
+	}
+	return f, ok, nil
+}
+
+// value returns the tag or field value of the member fi of the struct val.
+func (fi *FieldInfo) value(val reflect.Value, field bool, o *Options) (reflect.Value, bool, error) {
+	f, ok := fi.Member(val)
+	if !ok {
+		return f, false, fi.absent(o)
+	}
+	if fi.timeFormat != "" || fi.epoch != 0 {
+		return fi.formatTime(f, o)
+	}
+	if fi.json {
+		return fi.jsonValue(f, o)
+	}
+
+	source := fi.tagSource
+	if field {
+		source = fi.fieldSource
+	}
+	if f.Kind() == reflect.Interface {
+		// the dynamic type is only known now, and is followed through
+		// pointers like the static type, unless they provide the value
+		for f.Kind() == reflect.Interface || f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				// skipped like a nil pointer member
+				return f, false, fi.absent(o)
+			}
+			if f.Kind() == reflect.Ptr && fi.sourceOf(f.Type(), field) != sourceNone {
+				break
+			}
+			f = f.Elem()
+		}
+		source = fi.sourceOf(f.Type(), field)
+	}
+
+	// use a converter registered for the type, or else the interface
+	// providing the value if the type implements one
+	if conv := o.Converters[f.Type()]; conv != nil {
+		cv, err := conv(f.Interface())
+		if err != nil {
+			return f, false, fmt.Errorf("member %s: %w", fi.GoName, err)
+		}
+		f = reflect.ValueOf(cv)
+	} else if source != sourceNone {
+		// a pointer to an addressable member boxes without copying it
+		var iv interface{}
+		if f.CanAddr() {
+			iv = f.Addr().Interface()
+		} else {
+			iv = f.Interface()
+		}
+		var err error
+		if f, err = source.value(iv); err != nil {
+			return f, false, fmt.Errorf("member %s: %w", fi.GoName, err)
+		}
+		if !f.IsValid() && source == sourceDriver {
+			// a SQL NULL is skipped like a nil pointer
+			return f, false, fi.absent(o)
+		}
+	}
+
+	if !f.IsValid() {
+		if fi.required {
+			return f, false, fi.missing()
+		}
+		if fi.omitempty {
+			// a nil value from InfluxValuer, InfluxFieldValuer or a
+			// converter
+			return f, false, fi.skip(o, SkipNil)
+		}
+		return f, false, &UnsupportedTypeError{Field: fi.GoName}
+	}
+	if reason := fi.omitted(f); reason != 0 {
+		return f, false, fi.skip(o, reason)
+	}
+
+	// Ensure this is a type Influx can handle
+	if !SupportedKind(f.Kind()) && !IsBytes(f) {
+		return f, false, &UnsupportedTypeError{Field: fi.GoName, Type: f.Type()}
+	}
+	if fi.required && IsZero(f) {
+		return f, false, fi.missing()
+	}
+	if fi.round {
+		f = roundFloat(f, fi.decimals)
+	}
+	if fi.durationUnit != 0 {
+		f = durationValue(f, fi.durationUnit)
+	}
+	if fi.boolInt && f.Kind() == reflect.Bool {
+		// written as 1 or 0, which tags format as "1" and "0"
+		var n int64
+		if f.Bool() {
+			n = 1
+		}
+		f = reflect.ValueOf(n)
+	}
+	return f, true, nil
+}
+
+// durationFloat is the unit of the "duration=float" option, which writes
+// durations as fractional seconds.
+const durationFloat time.Duration = -1
+
+// durationValue converts f, an integer count of nanoseconds such as a
+// time.Duration, to the unit of the "duration" option.
+func durationValue(f reflect.Value, unit time.Duration) reflect.Value {
+	d := time.Duration(f.Int())
+	if unit == durationFloat {
+		return reflect.ValueOf(d.Seconds())
+	}
+	return reflect.ValueOf(int64(d / unit))
+}
+
+// roundFloat rounds f to the given number of decimal places if it is a
+// float, as set by the "precision" option. NaN and infinite values are
+// returned unchanged.
+func roundFloat(f reflect.Value, decimals int) reflect.Value {
+	if k := f.Kind(); k != reflect.Float32 && k != reflect.Float64 {
+		return f
+	}
+	x := f.Float()
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return f
+	}
+	p := math.Pow10(decimals)
+	return reflect.ValueOf(math.Round(x*p) / p).Convert(f.Type())
+}
+
+// missing returns the error for an unset member, which is only an error if
+// the member has the "required" option.
+func (fi *FieldInfo) missing() error {
+	if !fi.required {
+		return nil
+	}
+	return fmt.Errorf("member %s: %w", fi.GoName, ErrRequired)
+}
+
+// absent is like missing, but reports the member as skipped to o if it is
+// not an error.
+func (fi *FieldInfo) absent(o *Options) error {
+	if fi.required {
+		return fi.missing()
+	}
+	return fi.skip(o, SkipNil)
+}
+
+// omitted returns the reason the value f of the member is omitted by the
+// "omitzero" or "omitempty" option, or 0 if it is not.
+func (fi *FieldInfo) omitted(f reflect.Value) SkipReason {
+	switch {
+	case fi.omitzero && IsZero(f):
+		return SkipZero
+	case fi.omitempty && isEmpty(f):
+		return SkipEmpty
+	}
+	return 0
+}
+
+// skip reports the member as skipped for reason to the OnSkip function of
+// o, if any. It returns nil for the convenience of callers.
+func (fi *FieldInfo) skip(o *Options, reason SkipReason) error {
+	if o.OnSkip != nil {
+		o.OnSkip(fi.Name, reason)
+	}
+	return nil
+}
+
+// failed reports err to the OnError function of o, if any, and returns it.
+func (fi *FieldInfo) failed(o *Options, err error) error {
+	if o.OnError != nil {
+		o.OnError(fi.Name, err)
+	}
+	return err
+}
+
+// FieldValue is like TagValue for a member encoded as a field, applying
+// InfluxFieldValuer in place of InfluxTagValuer, and also the "omitnan"
+// option and the NaN policy of o.
+func (fi *FieldInfo) FieldValue(val reflect.Value, o *Options) (reflect.Value, bool, error) {
+	f, ok, err := fi.value(val, true, o)
+	if err != nil {
+		return f, false, fi.failed(o, err)
+	}
+	if !ok {
+		return f, false, nil
+	}
+	f, ok, err = fi.FiniteValue(f, o)
+	if err != nil {
+		return f, false, fi.failed(o, fmt.Errorf("member %s: %w", fi.GoName, err))
+	}
+	return f, ok, nil
+}
+
+// FiniteValue applies the "omitnan" option and the NaN policy of o to f, a
+// field value of the member, such as an entry of a "fields" map.
+func (fi *FieldInfo) FiniteValue(f reflect.Value, o *Options) (reflect.Value, bool, error) {
+	f, ok, err := o.FiniteValue(f, fi.omitnan)
+	if !ok && err == nil {
+		fi.skip(o, SkipNaN)
+	}
+	return f, ok, err
+}
+
+// formatTime returns the time.Time f formatted as a string by the
+// "timeformat" option, or as an integer by the "epoch" option. Zero times
+// are treated as zero values.
+func (fi *FieldInfo) formatTime(f reflect.Value, o *Options) (reflect.Value, bool, error) {
+	t := f.Interface().(time.Time)
+	if t.IsZero() {
+		if fi.required {
+			return f, false, fi.missing()
+		}
+		if fi.omitzero {
+			return f, false, fi.skip(o, SkipZero)
+		}
+		if fi.omitempty {
+			return f, false, fi.skip(o, SkipEmpty)
+		}
+	}
+	if fi.epoch != 0 {
+		return reflect.ValueOf(epochValue(t, fi.epoch)), true, nil
+	}
+	return reflect.ValueOf(t.Format(fi.timeFormat)), true, nil
+}
+
+// epochValue returns t as a count of unit since the Unix epoch.
+func epochValue(t time.Time, unit time.Duration) int64 {
+	switch unit {
+	case time.Nanosecond:
+		return t.UnixNano()
+	case time.Microsecond:
+		return t.UnixMicro()
+	case time.Millisecond:
+		return t.UnixMilli()
+	}
+	return t.Unix() / int64(unit/time.Second)
+}
+
+// jsonValue returns f encoded as compact JSON by the "json" option.
+func (fi *FieldInfo) jsonValue(f reflect.Value, o *Options) (reflect.Value, bool, error) {
+	if fi.required && IsZero(f) {
+		return f, false, fi.missing()
+	}
+	if reason := fi.omitted(f); reason != 0 {
+		return f, false, fi.skip(o, reason)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(f.Interface()); err != nil {
+		return f, false, fmt.Errorf("member %s: %w", fi.GoName, err)
+	}
+	return reflect.ValueOf(strings.TrimSuffix(buf.String(), "\n")), true, nil
+}
+
+// timeLayouts holds the named layouts of the "timeformat" option, which
+// serve for layouts that contain commas.
+var timeLayouts = map[string]string{
+	"ansic":       time.ANSIC,
+	"unixdate":    time.UnixDate,
+	"rfc822":      time.RFC822,
+	"rfc822z":     time.RFC822Z,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"kitchen":     time.Kitchen,
+	"date":        "2006-01-02",
+}
+
+// TimeValue returns the timestamp held by the member fi of the struct val,
+// which must have the "time" option. It reports false if there is none.
+func (fi *FieldInfo) TimeValue(val reflect.Value) (time.Time, bool, error) {
+	f, ok := fi.Member(val)
+	if !ok {
+		return time.Time{}, false, fi.missing()
+	}
+	if f.Type() != TimeType {
+		return time.Time{}, false, fmt.Errorf("time option on non-time member %s", fi.GoName)
+	}
+	var t time.Time
+	if f.CanAddr() {
+		// avoid copying the time into an interface
+		t = *f.Addr().Interface().(*time.Time)
+	} else {
+		t = f.Interface().(time.Time)
+	}
+	if t.IsZero() {
+		return t, false, fi.missing()
+	}
+	return t, true, nil
+}
+
+// TypeInfo is the encoding plan for a struct type, computed once from its
+// struct tags.
+type TypeInfo struct {
+	// measurement is the name given by an embedded Measurement field
+	measurement string
+	// RetentionPolicy is the name given by an embedded RetentionPolicy field
+	RetentionPolicy string
+	Fields          []FieldInfo
+	// TagOrder holds the indexes in fields of the members with the "tag"
+	// option, sorted by key
+	TagOrder []int
+	// FieldOrder holds the indexes in fields of the plain field members, in
+	// struct order, and sortedFields holds the same sorted by key
+	FieldOrder   []int
+	sortedFields []int
+	// dynamic is set if there are members with the "tags" or "fields"
+	// options, whose keys are only known at encoding time
+	dynamic bool
+	// err is the error found in the struct tags, if any
+	err error
+	// required holds the inlined and embedded struct pointer members with
+	// the "required" option
+	required []FieldInfo
+}
+
+// TagSyntaxError is returned when a struct tag holds an option that is not
+// recognized, such as a misspelled "omitzero", an option with an invalid
+// argument, or an option that does not apply to the type of the member, so
+// that the member is not silently encoded differently than intended.
+type TagSyntaxError struct {
+	// Type is the struct type declaring the member
+	Type   reflect.Type
+	Member string
+	// Tag is the whole struct tag value for the tag key
+	Tag    string
+	Option string
+	// Reason says why the option does not apply to the member, if it is
+	// otherwise valid
+	Reason string
+}
+
+func (e *TagSyntaxError) Error() string {
+	msg := fmt.Sprintf("struct tag %q of member %s.%s: invalid option %q", e.Tag, e.Type, e.Member, e.Option)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// optionError returns a *TagSyntaxError for the option name in the struct
+// tag of the member sf of t, which does not apply to the member for reason.
+func optionError(t reflect.Type, sf reflect.StructField, tagKey, name, reason string) *TagSyntaxError {
+	tag := sf.Tag.Get(tagKey)
+	option := name
+	for _, opt := range strings.Split(tag, ",")[1:] {
+		if k, _, _ := strings.Cut(opt, "="); k == name {
+			option = opt
+			break
+		}
+	}
+	return &TagSyntaxError{Type: t, Member: sf.Name, Tag: tag, Option: option, Reason: reason}
+}
+
+// UnsupportedTypeError is returned when a member holds a value that cannot be
+// encoded as a tag or field.
+type UnsupportedTypeError struct {
+	// Field is the path of the member through inlined and embedded structs,
+	// such as "Stats.CPU", followed by the index or key of an element of a
+	// slice or map member, or the key of an extra field
+	Field string
+	// Type is the type of the value, or nil for a nil value
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Type == nil {
+		return fmt.Sprintf("unsupported nil value for member %s", e.Field)
+	}
+	return fmt.Sprintf("unsupported type %s for member %s", e.Type, e.Field)
+}
+
+// DuplicateKeyError is returned when two members of a struct are encoded
+// with the same key, which would make one overwrite the other.
+type DuplicateKeyError struct {
+	Type reflect.Type
+	Key  string
+	// Members are the paths of the members, as for UnsupportedTypeError
+	Members [2]string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("members %s and %s of %s have the same key %q", e.Members[0], e.Members[1], e.Type, e.Key)
+}
+
+// FieldInfo describes how a single struct member is encoded.
+type FieldInfo struct {
+	Index []int
+	// GoName is the path of Go names of the member, such as "Stats.CPU"
+	GoName string
+	fieldOptions
+
+	// tagSource and fieldSource are the interfaces, if any, providing the
+	// member's value as a tag and as a field
+	tagSource   valueSource
+	fieldSource valueSource
+	// stringer is set if fmt.Stringer may provide the member's value
+	stringer bool
+	// depth is the number of embedded structs the member was promoted
+	// through
+	depth int
+	// labels is the index of the member labeling the elements of a
+	// member with the "flatten" option
+	labels []int
+}
+
+// typeKey identifies an encoding plan in typeCache. Only the options that
+// affect a plan are part of the key.
+type typeKey struct {
+	t                reflect.Type
+	tagKey           string
+	separator        string
+	maxDepth         int
+	keyJoin          KeyJoin
+	explicitStringer bool
+}
+
+var (
+	// defaultTypeCache holds the plans compiled with the default tag key and
+	// separator, keyed by reflect.Type alone so that lookups do not allocate
+	defaultTypeCache sync.Map // map[reflect.Type]*TypeInfo
+	typeCache        sync.Map // map[typeKey]*TypeInfo
+)
+
+// CompileType returns the encoding plan for the struct type t according to
+// o. Plans are cached, like those of encoding/json, and must not be modified.
+// It returns a *TagSyntaxError if a struct tag of t is malformed.
+func CompileType(t reflect.Type, o *Options) (*TypeInfo, error) {
+	if o.TagKey == defaultTagKey && o.Separator == defaultSeparator && o.MaxDepth == 0 && o.KeyJoin == JoinSeparator && !o.ExplicitStringer {
+		if info, ok := defaultTypeCache.Load(t); ok {
+			return info.(*TypeInfo), info.(*TypeInfo).err
+		}
+		info, _ := defaultTypeCache.LoadOrStore(t, buildType(t, o))
+		return info.(*TypeInfo), info.(*TypeInfo).err
+	}
+	key := typeKey{t, o.TagKey, o.Separator, o.MaxDepth, o.KeyJoin, o.ExplicitStringer}
+	if info, ok := typeCache.Load(key); ok {
+		return info.(*TypeInfo), info.(*TypeInfo).err
+	}
+	info, _ := typeCache.LoadOrStore(key, buildType(t, o))
+	return info.(*TypeInfo), info.(*TypeInfo).err
+}
+
+// ValidateType checks the struct type t as encoded according to o without
+// encoding a value: its struct tags, as CompileType does, and then the types
+// of its members and the names of its measurement and keys, as far as they
+// are known without a value. It returns the errors of every member joined.
+func ValidateType(t reflect.Type, o *Options) error {
+	info, err := CompileType(t, o)
+	if err != nil {
+		return err
+	}
+	names := *o
+	names.ValidateNames = true
+	var errs []error
+	if info.measurement != "" {
+		if err := names.CheckName("measurement", info.measurement); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		if err := fi.validate(memberType(t, fi.Index), &names); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// memberType returns the type of the member of the struct type t at index,
+// following pointers.
+func memberType(t reflect.Type, index []int) reflect.Type {
+	for _, x := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		t = t.Field(x).Type
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// validate checks that the member fi, of type t, can be encoded, and that its
+// key is allowed by o.
+func (fi *FieldInfo) validate(t reflect.Type, o *Options) error {
+	switch {
+	case fi.Time:
+		if t != TimeType {
+			return fmt.Errorf("time option on non-time member %s", fi.GoName)
+		}
+		return nil
+	case fi.TagMap || fi.FieldMap:
+		// the keys of maps are only known from a value
+		return checkMapType(t, fi)
+	case fi.Flatten:
+		return nil
+	}
+	kind := "field key"
+	if fi.Tag {
+		kind = "tag key"
+	}
+	if err := o.CheckName(kind, fi.Name); err != nil {
+		return err
+	}
+	switch {
+	case fi.json || fi.timeFormat != "" || fi.epoch != 0:
+		// checked by CompileType
+	case t.Kind() == reflect.Interface || o.Converters[t] != nil:
+		// the value is only known when encoding
+	case fi.tagSource != sourceNone || fi.fieldSource != sourceNone:
+	case SupportedKind(t.Kind()) || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+	default:
+		return &UnsupportedTypeError{Field: fi.GoName, Type: t}
+	}
+	return nil
+}
+
+// fieldPath returns the Go names of the member of the struct type t at index
+// joined by dots, such as "Stats.CPU" for a member of an inlined struct.
+func fieldPath(t reflect.Type, index []int) string {
+	var b strings.Builder
+	for i, x := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		sf := t.Field(x)
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(sf.Name)
+		t = sf.Type
+	}
+	return b.String()
+}
+
+// buildType builds the encoding plan for the struct type t according to o.
+func buildType(t reflect.Type, o *Options) *TypeInfo {
+	info := &TypeInfo{}
+	if err := compileFields(info, t, nil, "", "", 0, nil, o); err != nil {
+		return &TypeInfo{err: err}
+	}
+	info.Fields = dominantFields(info.Fields)
+	for i := range info.Fields {
+		info.Fields[i].GoName = fieldPath(t, info.Fields[i].Index)
+	}
+	for i := range info.required {
+		info.required[i].GoName = fieldPath(t, info.required[i].Index)
+	}
+	if err := checkDuplicates(t, info.Fields); err != nil {
+		return &TypeInfo{err: err}
+	}
+	for i, fi := range info.Fields {
+		switch {
+		case fi.TagMap || fi.FieldMap || fi.Flatten:
+			info.dynamic = true
+		case fi.Time:
+		case fi.Tag:
+			info.TagOrder = append(info.TagOrder, i)
+		default:
+			info.FieldOrder = append(info.FieldOrder, i)
+		}
+	}
+	info.sortedFields = append([]int(nil), info.FieldOrder...)
+	info.sortByName(info.TagOrder)
+	info.sortByName(info.sortedFields)
+	return info
+}
+
+// sortByName sorts indexes into info.fields by the key of the member.
+func (info *TypeInfo) sortByName(indexes []int) {
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return info.Fields[indexes[i]].Name < info.Fields[indexes[j]].Name
+	})
+}
+
+// compileFields adds the members of the struct type t to info. index is the
+// index sequence of t within the top-level struct and prefix is prepended to
+// every key, both of which are empty unless t is inlined. measurement is
+// the "measurement" option of the inlined member, inherited by members
+// without their own. depth is the number of embedded structs t was promoted
+// through, and path holds the types of the structs enclosing t, to detect
+// structs that inline themselves.
+func compileFields(info *TypeInfo, t reflect.Type, index []int, prefix, measurement string, depth int, path []reflect.Type, o *Options) error {
+	for _, pt := range path {
+		if pt == t {
+			return fmt.Errorf("struct %s is inlined within itself", t)
+		}
+	}
+	if len(path) > o.maxDepth() {
+		return fmt.Errorf("struct %s is inlined more than %d levels deep", t, o.maxDepth())
+	}
+	path = append(path[:len(path):len(path)], t)
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+
+		if structField.Anonymous && structField.Type == measurementType {
+			if index == nil {
+				info.measurement = structField.Tag.Get(o.TagKey)
+			}
+			continue
+		}
+		if structField.Anonymous && structField.Type == retentionPolicyType {
+			if index == nil {
+				info.RetentionPolicy = structField.Tag.Get(o.TagKey)
+			}
+			continue
+		}
+		// the exported members of embedded structs are promoted, even if
+		// the struct type is unexported, as with encoding/json
+		embedded := structField.Anonymous && isPromoted(structField.Type)
+		if structField.PkgPath != "" && !(embedded && structField.Type.Kind() != reflect.Ptr) {
+			continue
+		}
+		opts, err := getOpts(structField, o.TagKey)
+		if err != nil {
+			err.Type = t
+			return err
+		}
+		if opts == nil {
+			continue
+		}
+
+		fieldIndex := append(index[:len(index):len(index)], i)
+		if opts.Measurement == "" {
+			opts.Measurement = measurement
+		}
+
+		if embedded && !opts.named && !opts.inline && !opts.hasPrefix {
+			ft := structField.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				info.addRequired(structField, fieldIndex, opts)
+			}
+			if err := compileFields(info, ft, fieldIndex, prefix, opts.Measurement, depth+1, path, o); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if opts.inline || opts.hasPrefix {
+			ft := structField.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != TimeType {
+				if structField.Type.Kind() == reflect.Ptr {
+					info.addRequired(structField, fieldIndex, opts)
+				}
+				inner := o.joinKey(prefix, opts.Name) + o.keySeparator()
+				if opts.hasPrefix {
+					inner = prefix + opts.prefix
+				}
+				if err := compileFields(info, ft, fieldIndex, inner, opts.Measurement, depth, path, o); err != nil {
+					return err
+				}
+				continue
+			}
+			if opts.hasPrefix && !opts.dive {
+				return optionError(t, structField, o.TagKey, "prefix", "member is not a struct")
+			}
+		}
+
+		opts.Name = o.joinKey(prefix, opts.Name)
+		if opts.dive {
+			opts.keyPrefix = opts.Name + o.keySeparator()
+			if opts.hasPrefix {
+				opts.keyPrefix = prefix + opts.prefix
+			}
+			opts.camelKeys = o.KeyJoin == JoinCamelCase
+		}
+		ft := structField.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		fi := FieldInfo{
+			Index:        fieldIndex,
+			GoName:       structField.Name,
+			fieldOptions: *opts,
+			stringer:     opts.str || !o.ExplicitStringer,
+			depth:        depth,
+		}
+		fi.tagSource, fi.fieldSource = fi.sourceOf(ft, false), fi.sourceOf(ft, true)
+		if opts.str && fi.tagSource == sourceNone && ft.Kind() != reflect.Interface {
+			return optionError(t, structField, o.TagKey, "string", "member implements neither fmt.Stringer nor encoding.TextMarshaler")
+		}
+		if opts.timeFormat != "" && ft != TimeType {
+			return optionError(t, structField, o.TagKey, "timeformat", "member is not a time.Time")
+		}
+		if opts.epoch != 0 && ft != TimeType {
+			return optionError(t, structField, o.TagKey, "epoch", "member is not a time.Time")
+		}
+		if opts.Flatten {
+			if err := compileFlatten(&fi, t, structField, index, o.TagKey); err != nil {
+				return err
+			}
+		}
+		if opts.durationUnit != 0 {
+			switch ft.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return optionError(t, structField, o.TagKey, "duration", "member is not an integer")
+			}
+			// a time.Duration is written as a number rather than with its
+			// String method
+			fi.tagSource, fi.fieldSource = sourceNone, sourceNone
+		}
+		info.Fields = append(info.Fields, fi)
+	}
+	return nil
+}
+
+// addRequired records the inlined or embedded struct pointer member sf at
+// index if it has the "required" option.
+func (info *TypeInfo) addRequired(sf reflect.StructField, index []int, opts *fieldOptions) {
+	if opts.required {
+		info.required = append(info.required, FieldInfo{Index: index, GoName: sf.Name, fieldOptions: *opts})
+	}
+}
+
+// CheckRequired records, in errs, an error wrapping ErrRequired for each
+// inlined or embedded struct pointer member of val with the "required"
+// option that is nil, returning it if the encoding stops there.
+func (info *TypeInfo) CheckRequired(val reflect.Value, errs *MemberErrors) error {
+	for i := range info.required {
+		fi := &info.required[i]
+		if _, ok := fi.Member(val); !ok {
+			if err := errs.Add(-1-i, fi.missing()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MemberErrors collects the errors of the members of a struct value as it
+// is encoded. The first one stops the encoding unless the options ask for
+// all of them, in which case encoding goes on past each failing member, so
+// that a struct with several bad members can be fixed at once.
+type MemberErrors struct {
+	all    bool
+	failed []int
+	errs   []error
+}
+
+// NewMemberErrors returns a MemberErrors for encoding with o.
+func NewMemberErrors(o *Options) MemberErrors {
+	return MemberErrors{all: o.AllErrors}
+}
+
+// Add records err, the error of the member at index i of the Fields of the
+// TypeInfo, or of its required members if negative, and returns it if the
+// encoding stops there. A member visited again is only recorded once.
+func (e *MemberErrors) Add(i int, err error) error {
+	if !e.all {
+		return err
+	}
+	for _, j := range e.failed {
+		if j == i {
+			return nil
+		}
+	}
+	e.failed = append(e.failed, i)
+	e.errs = append(e.errs, err)
+	return nil
+}
+
+// Failed reports whether a member error has been recorded.
+func (e *MemberErrors) Failed() bool {
+	return len(e.errs) > 0
+}
+
+// Err returns the recorded errors followed by err, if it is not nil, joined
+// as by errors.Join. A single error is returned as it is.
+func (e *MemberErrors) Err(err error) error {
+	errs := e.errs
+	if err != nil {
+		errs = append(errs[:len(errs):len(errs)], err)
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// MapKey returns the field key of the entry k of a "fields" map member.
+func (fi *FieldInfo) MapKey(k string) string {
+	if fi.keyPrefix != "" && fi.camelKeys {
+		return fi.keyPrefix + upperFirst(k)
+	}
+	return fi.keyPrefix + k
+}
+
+// MapEntryKey is the inverse of MapKey, returning the map key for the field
+// key, or false if the field does not belong to the map.
+func (fi *FieldInfo) MapEntryKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, fi.keyPrefix) {
+		return "", false
+	}
+	k := key[len(fi.keyPrefix):]
+	if fi.keyPrefix != "" && fi.camelKeys {
+		k = lowerFirst(k)
+	}
+	return k, true
+}
+
+// compileFlatten checks the member fi, declared by sf in the struct type t at
+// index, which has the "flatten" option in its tagKey tag, and resolves its
+// labels member.
+func compileFlatten(fi *FieldInfo, t reflect.Type, sf reflect.StructField, index []int, tagKey string) error {
+	ft := sf.Type
+	if k := ft.Kind(); k != reflect.Slice && k != reflect.Array || ft.Elem().Kind() == reflect.Uint8 {
+		return optionError(t, sf, tagKey, "flatten", "member is not a slice or array")
+	}
+	if fi.labelsName == "" {
+		return nil
+	}
+	lf, ok := t.FieldByName(fi.labelsName)
+	if !ok || lf.Type.Kind() != reflect.Slice && lf.Type.Kind() != reflect.Array || lf.Type.Elem().Kind() != reflect.String {
+		return optionError(t, sf, tagKey, "flatten", fi.labelsName+" is not a slice or array of strings")
+	}
+	fi.labels = append(index[:len(index):len(index)], lf.Index...)
+	return nil
+}
+
+// FlatValues calls fn with the key and value of each element of the member
+// fi of the struct val, which has the "flatten" option. Keys are the name of
+// the member, the separator, and the index or label of the element.
+func (fi *FieldInfo) FlatValues(val reflect.Value, o *Options, fn func(key string, v reflect.Value)) error {
+	f, ok := fi.Member(val)
+	if !ok {
+		return fi.missing()
+	}
+	var labels reflect.Value
+	if fi.labels != nil {
+		if labels, ok = FieldByIndex(val, fi.labels); !ok || labels.Len() < f.Len() {
+			return fmt.Errorf("member %s has more elements than %s has labels", fi.GoName, fi.labelsName)
+		}
+	}
+	for i := 0; i < f.Len(); i++ {
+		v := f.Index(i)
+		if v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		if !SupportedKind(v.Kind()) {
+			return &UnsupportedTypeError{Field: fmt.Sprintf("%s[%d]", fi.GoName, i), Type: v.Type()}
+		}
+		if fi.omitzero && IsZero(v) {
+			continue
+		}
+		var key string
+		if labels.IsValid() {
+			key = o.joinKey(fi.Name+o.keySeparator(), labels.Index(i).String())
+		} else {
+			key = fi.Name + o.keySeparator() + strconv.Itoa(i)
+		}
+		v, ok, err := fi.FiniteValue(v, o)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", key, err)
+		}
+		if ok {
+			fn(key, v)
+		}
+	}
+	return nil
+}
+
+// isPromoted reports whether the members of an embedded field of type t are
+// promoted: it must be a struct or struct pointer, other than a time.Time,
+// that does not provide its own value.
+func isPromoted(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == TimeType {
+		return false
+	}
+	pt := reflect.PtrTo(t)
+	return sourceOf(pt, false) == sourceNone && sourceOf(pt, true) == sourceNone
+}
+
+// checkDuplicates returns a *DuplicateKeyError if two of the members of the
+// struct type t, as resolved by dominantFields, are encoded with the same key
+// in the same point, whether as tags or fields.
+func checkDuplicates(t reflect.Type, fields []FieldInfo) error {
+	type key struct{ measurement, name string }
+	seen := make(map[key]int, len(fields))
+	for i, fi := range fields {
+		if fi.Time || fi.TagMap || fi.FieldMap || fi.Flatten {
+			continue
+		}
+		k := key{fi.Measurement, fi.Name}
+		if j, ok := seen[k]; ok {
+			return &DuplicateKeyError{Type: t, Key: fi.Name, Members: [2]string{fields[j].GoName, fi.GoName}}
+		}
+		seen[k] = i
+	}
+	return nil
+}
+
+// dominantFields resolves the members with the same key where at least one
+// was promoted from an embedded struct, following the rules of
+// encoding/json: the least deeply embedded member is kept, or if there are
+// several, the one whose key was given by its struct tag. If that leaves
+// more than one, all are dropped.
+func dominantFields(fields []FieldInfo) []FieldInfo {
+	byName := make(map[string][]int, len(fields))
+	for i, fi := range fields {
+		if !fi.TagMap && !fi.FieldMap {
+			byName[fi.Name] = append(byName[fi.Name], i)
+		}
+	}
+	drop := make(map[int]bool)
+	for _, group := range byName {
+		if len(group) < 2 {
+			continue
+		}
+		minDepth, maxDepth := fields[group[0]].depth, fields[group[0]].depth
+		for _, i := range group[1:] {
+			if d := fields[i].depth; d < minDepth {
+				minDepth = d
+			} else if d > maxDepth {
+				maxDepth = d
+			}
+		}
+		if maxDepth == 0 {
+			// duplicates among the struct's own members are reported by
+			// checkDuplicates
+			continue
+		}
+		var shallow, named []int
+		for _, i := range group {
+			if fields[i].depth == minDepth {
+				shallow = append(shallow, i)
+				if fields[i].named {
+					named = append(named, i)
+				}
+			}
+		}
+		keep := -1
+		switch {
+		case len(shallow) == 1:
+			keep = shallow[0]
+		case len(named) == 1:
+			keep = named[0]
+		}
+		for _, i := range group {
+			if i != keep {
+				drop[i] = true
+			}
+		}
+	}
+	if len(drop) == 0 {
+		return fields
+	}
+	kept := fields[:0:0]
+	for i, fi := range fields {
+		if !drop[i] {
+			kept = append(kept, fi)
+		}
+	}
+	return kept
+}
+
+// FieldByIndex returns the nested field of v at index, following pointers to
+// inlined structs. It reports false if one of those pointers is nil.
+func FieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+type fieldOptions struct {
+	Name string
+	// named is set if the name was given by the struct tag
+	named     bool
+	omitzero  bool
+	omitempty bool
+	omitnan   bool
+	required  bool
+	// round is set by the "precision" option, rounding floats to decimals
+	// decimal places
+	round    bool
+	decimals int
+	// durationUnit is set by the "duration" option
+	durationUnit time.Duration
+	boolInt      bool
+	// timeFormat is the layout given by the "timeformat" option
+	timeFormat string
+	// epoch is the unit given by the "epoch" option
+	epoch time.Duration
+	// prefix is given by the "prefix" option, which inlines a struct with
+	// it in place of the name and separator
+	prefix    string
+	hasPrefix bool
+	json      bool
+	// str is set by the "string" option, requiring the value to come from
+	// encoding.TextMarshaler or fmt.Stringer
+	str      bool
+	Tag      bool
+	Time     bool
+	inline   bool
+	TagMap   bool
+	FieldMap bool
+	// keyPrefix is prepended to the keys of a "fields" map by the "dive"
+	// option
+	keyPrefix string
+	dive      bool
+	// camelKeys is set if the keys of a "dive" map are joined in camel case
+	camelKeys bool
+	// Flatten is set by the "flatten" option, and labelsName is the Go name
+	// of the member labeling the elements, if given
+	Flatten    bool
+	labelsName string
+	// Measurement is the point the member belongs to in MarshalMulti
+	Measurement string
+}
+
+var TimeType = reflect.TypeOf(time.Time{})
+
+// getOpts parses the options of the struct field f. It returns nil if the
+// field is omitted, and an error without its Type set if an option is not
+// recognized.
+func getOpts(f reflect.StructField, tagKey string) (*fieldOptions, *TagSyntaxError) {
+	o := &fieldOptions{
+		Name: f.Name,
+	}
+	val, ok := f.Tag.Lookup(tagKey)
+	if val == "-" {
+		return nil, nil
+	}
+	if ok {
+		opts := strings.Split(val, ",")
+		if len(opts) > 0 {
+			switch opts[0] {
+			case "":
+				// retain name
+			default:
+				// otherwise, use this name
+				o.Name = opts[0]
+				o.named = true
+			}
+			// process the rest of the options
+			if len(opts) > 1 {
+				for _, opt := range opts[1:] {
+					switch opt {
+					case "omitzero":
+						o.omitzero = true
+					case "omitempty":
+						o.omitempty = true
+					case "omitnan":
+						o.omitnan = true
+					case "required":
+						o.required = true
+					case "boolint":
+						o.boolInt = true
+					case "json":
+						o.json = true
+					case "string":
+						o.str = true
+					case "tag":
+						o.Tag = true
+					case "time":
+						o.Time = true
+					case "inline":
+						o.inline = true
+					case "tags":
+						o.TagMap = true
+					case "fields":
+						o.FieldMap = true
+					case "dive":
+						o.FieldMap, o.dive = true, true
+					case "flatten":
+						o.Flatten = true
+					case "":
+						// tolerate stray commas
+					default:
+						name, arg, _ := strings.Cut(opt, "=")
+						switch name {
+						case "measurement":
+							o.Measurement = arg
+						case "precision":
+							n, err := strconv.Atoi(arg)
+							if err != nil || n < 0 {
+								return nil, &TagSyntaxError{Member: f.Name, Tag: val, Option: opt}
+							}
+							o.round, o.decimals = true, n
+						case "flatten":
+							if arg == "" {
+								return nil, &TagSyntaxError{Member: f.Name, Tag: val, Option: opt}
+							}
+							o.Flatten, o.labelsName = true, arg
+						case "prefix":
+							o.prefix, o.hasPrefix = arg, true
+						case "epoch":
+							if o.epoch = PrecisionDuration(arg); o.epoch == 0 {
+								return nil, &TagSyntaxError{Member: f.Name, Tag: val, Option: opt}
+							}
+						case "timeformat":
+							if arg == "" {
+								return nil, &TagSyntaxError{Member: f.Name, Tag: val, Option: opt}
+							}
+							o.timeFormat = arg
+							if layout, ok := timeLayouts[arg]; ok {
+								o.timeFormat = layout
+							}
+						case "duration":
+							if arg == "float" {
+								o.durationUnit = durationFloat
+							} else if o.durationUnit = PrecisionDuration(arg); o.durationUnit == 0 {
+								return nil, &TagSyntaxError{Member: f.Name, Tag: val, Option: opt}
+							}
+						default:
+							return nil, &TagSyntaxError{Member: f.Name, Tag: val, Option: opt}
+						}
+					}
+				}
+			}
+		}
+	}
+	if !ok && f.Name == "Time" && f.Type == TimeType {
+		o.Time = true
+	}
+	return o, nil
+}
+
+// isEmpty reports whether v is empty as defined by the "omitempty" option:
+// an empty string, slice, array or map, or a nil pointer or interface.
+// Numbers and booleans are never empty, unlike with encoding/json.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// IsZero reports whether v is the zero value for its type, as
+// reflect.Value.IsZero does. An invalid Value, such as the value of a nil
+// interface, is zero rather than a panic.
+func IsZero(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrLineBreak is returned when a measurement, tag key, tag value or field
+// key contains a newline or carriage return, which line protocol has no way
+// to escape.
+var ErrLineBreak = errors.New("line break in name, key or tag value")
+
+// ErrReservedName is returned, when names are validated, for a measurement,
+// tag key or field key that InfluxDB reserves: "time", or one beginning with
+// an underscore.
+var ErrReservedName = errors.New("reserved name")
+
+// ErrTooLong is returned, when sizes are limited, for a measurement, tag key,
+// tag value or field key longer than MaxNameSize.
+var ErrTooLong = errors.New("longer than InfluxDB allows")
+
+// MaxNameSize is the length in bytes that InfluxDB allows for measurements,
+// tag keys, tag values and field keys.
+const MaxNameSize = 1<<16 - 1
+
+// CheckName returns an error if o validates names and name, a measurement,
+// tag key or field key as given by kind, is reserved or contains a line
+// break, or if o limits sizes and name is longer than MaxNameSize.
+func (o *Options) CheckName(kind, name string) error {
+	if o.TagSize.mode != sizeIgnore && len(name) > MaxNameSize {
+		return fmt.Errorf("%s of %d bytes beginning %q: %w", kind, len(name), name[:32], ErrTooLong)
+	}
+	if !o.ValidateNames {
+		return nil
+	}
+	switch {
+	case name == "time":
+		return fmt.Errorf("%s %q: %w", kind, name, ErrReservedName)
+	case strings.HasPrefix(name, "_"):
+		return fmt.Errorf("%s %q begins with an underscore: %w", kind, name, ErrReservedName)
+	case hasLineBreak(name):
+		return fmt.Errorf("%s %q: %w", kind, name, ErrLineBreak)
+	}
+	return nil
+}
+
+// escapeTable maps each byte that must be escaped to the byte written after
+// the backslash, or 0 if it is written as is.
+type escapeTable [256]byte
+
+// newEscapeTable returns a table escaping each of chars as itself.
+func newEscapeTable(chars string) *escapeTable {
+	var t escapeTable
+	for i := 0; i < len(chars); i++ {
+		t[chars[i]] = chars[i]
+	}
+	return &t
+}
+
+// The escaping rules for each part of a line. A backslash is only an escape
+// before these characters, so other characters, including backslashes and
+// tabs, are written as is.
+// (ref: https://docs.influxdata.com/influxdb/v1.7/write_protocols/line_protocol_reference/#special-characters)
+var (
+	NameEscapes   = newEscapeTable(", ")
+	KeyEscapes    = newEscapeTable(",= ")
+	StringEscapes = newEscapeTable(`"\`)
+)
+
+// hasLineBreak reports whether s contains a newline or carriage return.
+func hasLineBreak(s string) bool {
+	return strings.ContainsAny(s, "\n\r")
+}
+
+// bytesHaveLineBreak reports whether b contains a newline or carriage
+// return.
+func bytesHaveLineBreak(b []byte) bool {
+	return bytes.ContainsAny(b, "\n\r")
+}
+
+// AppendEscaped appends s to b, escaping it according to t.
+func AppendEscaped(b []byte, s string, t *escapeTable) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if r := t[c]; r != 0 {
+			b = append(b, '\\', r)
+			continue
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// Escape returns s escaped according to t, without allocating if nothing
+// needs escaping.
+func Escape(s string, t *escapeTable) string {
+	for i := 0; i < len(s); i++ {
+		if t[s[i]] != 0 {
+			b := make([]byte, 0, len(s)+8)
+			b = append(b, s[:i]...)
+			return string(AppendEscaped(b, s[i:], t))
+		}
+	}
+	return s
+}
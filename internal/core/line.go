@@ -0,0 +1,585 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// AppendStruct appends the line protocol for the struct value val,
+// originally passed as v, to dst, without a newline. t is the timestamp
+// unless val has one of its own.
+func AppendStruct(dst []byte, v interface{}, val reflect.Value, info *TypeInfo, measurement string, t time.Time, o *Options) ([]byte, error) {
+	errs := NewMemberErrors(o)
+	b, err := appendSeriesKey(dst, v, val, info, measurement, o, &errs)
+	if err != nil {
+		return dst, errs.Err(err)
+	}
+
+	var n int
+	var fields []lineField
+	if info.dynamic || len(o.Fields) > 0 || o.Schema != nil {
+		b, fields, err = appendAllFields(b, val, info, o, &errs)
+		n = len(fields)
+	} else {
+		b, n, err = appendFields(b, val, info, o, &errs)
+	}
+	if err != nil {
+		return dst, errs.Err(err)
+	}
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		if !fi.Time {
+			continue
+		}
+		ts, ok, err := fi.TimeValue(val)
+		if err != nil {
+			if err := errs.Add(i, err); err != nil {
+				return dst, err
+			}
+			continue
+		}
+		if ok {
+			t = ts
+		}
+	}
+	if errs.Failed() {
+		return dst, errs.Err(nil)
+	}
+	if n == 0 {
+		return dst, ErrNoFields
+	}
+
+	if o.Schema != nil {
+		if measurement == "" {
+			measurement = StructMeasurement(v, info)
+		}
+		if err := o.Schema.Check(measurement, schemaFields(fields, o)); err != nil {
+			return dst, err
+		}
+	}
+	return appendTimestamp(b, StructTimestamp(v, t), o), nil
+}
+
+// appendTimestamp appends t to b at the precision of o, or nothing if t is
+// the zero time.
+func appendTimestamp(b []byte, t time.Time, o *Options) []byte {
+	if t.IsZero() {
+		return b
+	}
+	ts := t.UnixNano()
+	if o.Precision > 1 {
+		ts /= int64(o.Precision)
+	}
+	b = append(b, ' ')
+	return strconv.AppendInt(b, ts, 10)
+}
+
+// AppendMaps appends the line protocol for a point given by its parts to
+// dst, without a newline. Tags and fields are written in key order, and tags
+// with empty values are skipped.
+func AppendMaps(dst []byte, measurement string, tags map[string]string, fields map[string]interface{}, t time.Time, o *Options) ([]byte, error) {
+	if err := o.CheckName("measurement", measurement); err != nil {
+		return dst, err
+	}
+	b := AppendEscaped(dst, measurement, NameEscapes)
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := o.CheckName("tag key", k); err != nil {
+			return dst, err
+		}
+		v, err := o.LimitTag(k, tags[k])
+		if err != nil {
+			return dst, err
+		}
+		b = append(b, ',')
+		b = AppendEscaped(b, k, KeyEscapes)
+		b = append(b, '=')
+		b = AppendEscaped(b, v, KeyEscapes)
+	}
+	if bytesHaveLineBreak(b[len(dst):]) {
+		return dst, fmt.Errorf("series %q: %w", b[len(dst):], ErrLineBreak)
+	}
+
+	if len(fields) == 0 {
+		return dst, ErrNoFields
+	}
+	keys = keys[:0]
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var schema []SchemaField
+	n := 0
+	for _, k := range keys {
+		f, ok, err := o.FiniteValue(reflect.ValueOf(fields[k]), false)
+		if err != nil {
+			return dst, fmt.Errorf("field %s: %w", k, err)
+		}
+		if !ok {
+			continue
+		}
+		if b, err = appendField(b, n, k, f, o); err != nil {
+			return dst, err
+		}
+		if o.Schema != nil {
+			schema = append(schema, SchemaField{Key: k, Type: ValueType(f, o)})
+		}
+		n++
+	}
+	if n == 0 {
+		return dst, ErrNoFields
+	}
+	if o.Schema != nil {
+		if err := o.Schema.Check(measurement, schema); err != nil {
+			return dst, err
+		}
+	}
+	return appendTimestamp(b, t, o), nil
+}
+
+// AppendSeriesKey appends the measurement and tag set of the struct value
+// val, originally passed as v, to dst.
+func AppendSeriesKey(dst []byte, v interface{}, val reflect.Value, info *TypeInfo, measurement string, o *Options) ([]byte, error) {
+	errs := NewMemberErrors(o)
+	b, err := appendSeriesKey(dst, v, val, info, measurement, o, &errs)
+	if err != nil {
+		return dst, errs.Err(err)
+	}
+	if errs.Failed() {
+		return dst, errs.Err(nil)
+	}
+	return b, nil
+}
+
+// appendSeriesKey is AppendSeriesKey, recording the errors of members in
+// errs. The series key is incomplete if errs has failed.
+func appendSeriesKey(dst []byte, v interface{}, val reflect.Value, info *TypeInfo, measurement string, o *Options, errs *MemberErrors) ([]byte, error) {
+	if err := BeforeMarshal(v); err != nil {
+		return dst, err
+	}
+	if err := info.CheckRequired(val, errs); err != nil {
+		return dst, err
+	}
+	if measurement == "" {
+		measurement = StructMeasurement(v, info)
+		if measurement == "" {
+			return dst, fmt.Errorf("no measurement for %s", val.Type())
+		}
+	}
+	if err := o.CheckName("measurement", measurement); err != nil {
+		return dst, err
+	}
+	b := AppendEscaped(dst, measurement, NameEscapes)
+
+	var err error
+	tagger, _ := v.(InfluxTagger)
+	if info.dynamic || len(o.Tags) > 0 || tagger != nil {
+		b, err = appendAllTags(b, val, info, tagger, o, errs)
+	} else {
+		b, err = appendTags(b, val, info, o, errs)
+	}
+	if err != nil || errs.Failed() {
+		return dst, err
+	}
+	if bytesHaveLineBreak(b[len(dst):]) {
+		return dst, fmt.Errorf("series %q: %w", b[len(dst):], ErrLineBreak)
+	}
+	return b, nil
+}
+
+// appendTags appends the tags of val in their precomputed order. It is used
+// when there are no dynamic or extra tags to merge.
+func appendTags(b []byte, val reflect.Value, info *TypeInfo, o *Options, errs *MemberErrors) ([]byte, error) {
+	for _, i := range info.TagOrder {
+		fi := &info.Fields[i]
+		f, ok, err := fi.TagValue(val, o)
+		if err != nil {
+			if err := errs.Add(i, err); err != nil {
+				return b, err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := o.CheckName("tag key", fi.Name); err != nil {
+			return b, err
+		}
+		b = append(b, ',')
+		b = AppendEscaped(b, fi.Name, KeyEscapes)
+		b = append(b, '=')
+		if b, err = appendTagValue(b, fi.Name, f, o); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// lineTag is a tag collected for sorting. If val is valid, it holds the
+// value, and otherwise value does.
+type lineTag struct {
+	key   string
+	value string
+	val   reflect.Value
+}
+
+// appendAllTags appends the extra tags in o, the tags of tagger if it is not
+// nil, and every tag of val, including dynamic ones, in key order. Where keys
+// collide, members take precedence over the tags of tagger, those over extra
+// tags, and later members over earlier ones.
+func appendAllTags(b []byte, val reflect.Value, info *TypeInfo, tagger InfluxTagger, o *Options, errs *MemberErrors) ([]byte, error) {
+	tags := make([]lineTag, 0, len(o.Tags)+len(info.TagOrder))
+	for k, v := range o.Tags {
+		tags = append(tags, lineTag{key: k, value: v})
+	}
+	if tagger != nil {
+		for k, v := range tagger.InfluxTags() {
+			if v != "" {
+				tags = append(tags, lineTag{key: k, value: v})
+			}
+		}
+	}
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		switch {
+		case fi.TagMap:
+			f, ok := fi.Member(val)
+			if !ok {
+				continue
+			}
+			if err := CheckMap(f, fi); err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return b, err
+				}
+				continue
+			}
+			iter := f.MapRange()
+			for iter.Next() {
+				if v := iter.Value().String(); v != "" {
+					tags = append(tags, lineTag{key: iter.Key().String(), value: v})
+				}
+			}
+		case fi.Tag && !fi.Time:
+			f, ok, err := fi.TagValue(val, o)
+			if err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return b, err
+				}
+				continue
+			}
+			// empty values, which are not ok, do not override extra tags
+			if ok {
+				tags = append(tags, lineTag{key: fi.Name, val: f})
+			}
+		}
+	}
+	if errs.Failed() {
+		return b, nil
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].key < tags[j].key
+	})
+	for i, tag := range tags {
+		if i+1 < len(tags) && tags[i+1].key == tag.key {
+			// overridden by a later tag
+			continue
+		}
+		if !tag.val.IsValid() && tag.value == "" {
+			continue
+		}
+		if err := o.CheckName("tag key", tag.key); err != nil {
+			return b, err
+		}
+		b = append(b, ',')
+		b = AppendEscaped(b, tag.key, KeyEscapes)
+		b = append(b, '=')
+		var err error
+		if tag.val.IsValid() {
+			b, err = appendTagValue(b, tag.key, tag.val, o)
+		} else {
+			var v string
+			v, err = o.LimitTag(tag.key, tag.value)
+			b = AppendEscaped(b, v, KeyEscapes)
+		}
+		if err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// appendFields appends the fields of val in struct order, or key order if o
+// requires it, returning the number written. It is used when there are no
+// dynamic or extra fields to merge.
+func appendFields(b []byte, val reflect.Value, info *TypeInfo, o *Options, errs *MemberErrors) ([]byte, int, error) {
+	order := info.FieldOrder
+	if o.SortKeys {
+		order = info.sortedFields
+	}
+	n := 0
+	for _, i := range order {
+		fi := &info.Fields[i]
+		f, ok, err := fi.FieldValue(val, o)
+		if err != nil {
+			if err := errs.Add(i, err); err != nil {
+				return b, n, err
+			}
+			continue
+		}
+		if !ok || errs.Failed() {
+			continue
+		}
+		if b, err = appendField(b, n, fi.Name, f, o); err != nil {
+			return b, n, err
+		}
+		n++
+	}
+	return b, n, nil
+}
+
+// lineField is a field collected for merging.
+type lineField struct {
+	key string
+	val reflect.Value
+}
+
+// appendAllFields appends the extra fields in o and every field of val,
+// including dynamic ones, returning those written. Where keys collide,
+// members take precedence over extra fields, and later members over earlier
+// ones.
+func appendAllFields(b []byte, val reflect.Value, info *TypeInfo, o *Options, errs *MemberErrors) ([]byte, []lineField, error) {
+	fields := make([]lineField, 0, len(o.Fields)+len(info.FieldOrder))
+	for k, v := range o.Fields {
+		fields = append(fields, lineField{key: k, val: reflect.ValueOf(v)})
+	}
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		switch {
+		case fi.Tag || fi.Time || fi.TagMap:
+			continue
+		case fi.FieldMap:
+			f, ok := fi.Member(val)
+			if !ok {
+				continue
+			}
+			if err := CheckMap(f, fi); err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return b, nil, err
+				}
+				continue
+			}
+			iter := f.MapRange()
+			for iter.Next() {
+				v := iter.Value()
+				if v.Kind() == reflect.Interface {
+					if v.IsNil() {
+						continue
+					}
+					v = v.Elem()
+				}
+				key := fi.MapKey(iter.Key().String())
+				v, ok, err := fi.FiniteValue(v, o)
+				if err != nil {
+					if err := errs.Add(i, fmt.Errorf("field %s: %w", key, err)); err != nil {
+						return b, nil, err
+					}
+					break
+				}
+				if ok {
+					fields = append(fields, lineField{key: key, val: v})
+				}
+			}
+		case fi.Flatten:
+			err := fi.FlatValues(val, o, func(key string, v reflect.Value) {
+				fields = append(fields, lineField{key: key, val: v})
+			})
+			if err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return b, nil, err
+				}
+			}
+		default:
+			f, ok, err := fi.FieldValue(val, o)
+			if err != nil {
+				if err := errs.Add(i, err); err != nil {
+					return b, nil, err
+				}
+				continue
+			}
+			if ok {
+				fields = append(fields, lineField{key: fi.Name, val: f})
+			}
+		}
+	}
+	if errs.Failed() {
+		return b, nil, nil
+	}
+
+	if o.SortKeys {
+		sort.SliceStable(fields, func(i, j int) bool {
+			return fields[i].key < fields[j].key
+		})
+	}
+
+	n := 0
+outer:
+	for i, field := range fields {
+		for _, later := range fields[i+1:] {
+			if later.key == field.key {
+				continue outer
+			}
+		}
+		var err error
+		if b, err = appendField(b, n, field.key, field.val, o); err != nil {
+			return b, nil, err
+		}
+		fields[n] = field
+		n++
+	}
+	return b, fields[:n], nil
+}
+
+// schemaFields returns the keys and types of fields as written according to
+// o.
+func schemaFields(fields []lineField, o *Options) []SchemaField {
+	schema := make([]SchemaField, len(fields))
+	for i, f := range fields {
+		schema[i] = SchemaField{Key: f.key, Type: ValueType(f.val, o)}
+	}
+	return schema
+}
+
+// appendField appends the field key=f to b, preceded by the appropriate
+// separator given the number of fields already written.
+func appendField(b []byte, n int, key string, f reflect.Value, o *Options) ([]byte, error) {
+	if hasLineBreak(key) {
+		return b, fmt.Errorf("field %q: %w", key, ErrLineBreak)
+	}
+	if err := o.CheckName("field key", key); err != nil {
+		return b, err
+	}
+	if n == 0 {
+		b = append(b, ' ')
+	} else {
+		b = append(b, ',')
+	}
+	b = AppendEscaped(b, key, KeyEscapes)
+	b = append(b, '=')
+	b, err := appendFieldValue(b, f, o)
+	if ute, ok := err.(*UnsupportedTypeError); ok {
+		ute.Field = key
+		return b, ute
+	}
+	if err != nil {
+		return b, fmt.Errorf("field %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// appendFieldValue appends the line protocol representation of the field
+// value v to b. Integers are written with the "i" suffix, or "u" for
+// unsigned integers if o allows it, unless o forces them to be floats.
+func appendFieldValue(b []byte, v reflect.Value, o *Options) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o.ForceFloat {
+			return strconv.AppendFloat(b, float64(v.Int()), 'f', -1, 64), nil
+		}
+		b = strconv.AppendInt(b, v.Int(), 10)
+		return append(b, 'i'), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		switch {
+		case o.ForceFloat:
+			return strconv.AppendFloat(b, float64(u), 'f', -1, 64), nil
+		case o.Unsigned:
+			b = strconv.AppendUint(b, u, 10)
+			return append(b, 'u'), nil
+		case u > math.MaxInt64:
+			return b, fmt.Errorf("value %d overflows int64", u)
+		}
+		b = strconv.AppendUint(b, u, 10)
+		return append(b, 'i'), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return b, fmt.Errorf("unsupported value %v", f)
+		}
+		return strconv.AppendFloat(b, f, 'f', -1, v.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.AppendBool(b, v.Bool()), nil
+	case reflect.String:
+		b = append(b, '"')
+		b = AppendEscaped(b, o.LimitString(v.String()), StringEscapes)
+		return append(b, '"'), nil
+	case reflect.Slice:
+		if IsBytes(v) {
+			b = append(b, '"')
+			b = AppendEscaped(b, o.LimitString(BytesString(v.Bytes(), true)), StringEscapes)
+			return append(b, '"'), nil
+		}
+	}
+	if !v.IsValid() {
+		return b, &UnsupportedTypeError{}
+	}
+	return b, &UnsupportedTypeError{Type: v.Type()}
+}
+
+// emptyString reports whether f is an empty string or byte slice, which
+// cannot be a tag value.
+func emptyString(f reflect.Value) bool {
+	return (f.Kind() == reflect.String || IsBytes(f)) && f.Len() == 0
+}
+
+// appendTagValue appends the string form of the value v of the tag key to b,
+// matching the formatting of fmt.Sprint, and limited by the size policy of o.
+func appendTagValue(b []byte, key string, v reflect.Value, o *Options) ([]byte, error) {
+	var s string
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(b, v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(b, v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.AppendFloat(b, v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.AppendBool(b, v.Bool()), nil
+	case reflect.Slice:
+		s = BytesString(v.Bytes(), true)
+	default:
+		s = v.String()
+	}
+	s, err := o.LimitTag(key, s)
+	if err != nil {
+		return b, err
+	}
+	return AppendEscaped(b, s, KeyEscapes), nil
+}
+
+// CheckMap returns an error if f, a member with the "tags" or "fields"
+// option, is not a suitable map.
+func CheckMap(f reflect.Value, fi *FieldInfo) error {
+	return checkMapType(f.Type(), fi)
+}
+
+// checkMapType is CheckMap for a member of type t.
+func checkMapType(t reflect.Type, fi *FieldInfo) error {
+	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+		return fmt.Errorf("member %s must be a map with string keys", fi.GoName)
+	}
+	if fi.TagMap && t.Elem().Kind() != reflect.String {
+		return fmt.Errorf("member %s must be a map of strings", fi.GoName)
+	}
+	return nil
+}
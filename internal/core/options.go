@@ -0,0 +1,336 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultTagKey    = "influx"
+	defaultSeparator = "_"
+	defaultMaxDepth  = 16
+)
+
+// Option customizes the encoding of a value. The public packages provide
+// Options through their With functions.
+type Option func(*Options)
+
+// Options holds the settings of an encoding.
+type Options struct {
+	Time      time.Time
+	Tags      map[string]string
+	Fields    map[string]interface{}
+	TagKey    string
+	Separator string
+	Precision time.Duration
+
+	Unsigned   bool
+	ForceFloat bool
+	SortKeys   bool
+
+	// Duplicates holds the DuplicatePolicy of batches
+	Duplicates    int
+	Parallelism   int
+	UnsafeStrings bool
+	NaN           NaNPolicy
+	// MaxDepth limits the nesting of inlined and embedded structs, or is 0
+	// for the default
+	MaxDepth int
+	KeyJoin  KeyJoin
+	// ExplicitStringer limits fmt.Stringer to members with the "string"
+	// option
+	ExplicitStringer bool
+	// Converters convert members by type, in place of the interfaces they
+	// implement
+	Converters map[reflect.Type]func(v interface{}) (interface{}, error)
+	// OnSkip and OnError observe the members omitted from a point and
+	// those that fail to encode, by key
+	OnSkip  func(key string, reason SkipReason)
+	OnError func(key string, err error)
+	// ContextTags extracts tags from the context given to MarshalContext
+	ContextTags func(ctx context.Context) map[string]string
+	// Clock replaces time.Now when Time is not set
+	Clock func() time.Time
+	// AllErrors reports the errors of every member rather than the first
+	AllErrors bool
+	// ValidateNames rejects names that InfluxDB reserves
+	ValidateNames bool
+	// TagSize limits tag values, and names, to MaxNameSize
+	TagSize SizePolicy
+	// MaxString limits the length of string fields, which are truncated to
+	// end with StringMarker, or is 0 for no limit
+	MaxString    int
+	StringMarker string
+	// Schema checks the types of the fields written
+	Schema *SchemaTracker
+}
+
+// SkipReason is the reason a member was omitted from a point.
+type SkipReason int
+
+const (
+	// SkipNil is for a nil pointer or interface, a SQL NULL, or a nil value
+	// with the "omitempty" option.
+	SkipNil SkipReason = iota + 1
+	// SkipZero is for a zero value with the "omitzero" option.
+	SkipZero
+	// SkipEmpty is for an empty value with the "omitempty" option, or an
+	// empty tag value, which line protocol cannot represent.
+	SkipEmpty
+	// SkipNaN is for a NaN or infinite float omitted by the "omitnan" option
+	// or the NaN policy.
+	SkipNaN
+)
+
+var skipReasons = [...]string{
+	SkipNil:   "nil",
+	SkipZero:  "zero",
+	SkipEmpty: "empty",
+	SkipNaN:   "nan",
+}
+
+// String returns the name of r, such as "nil", for diagnostics.
+func (r SkipReason) String() string {
+	if r > 0 && int(r) < len(skipReasons) {
+		return skipReasons[r]
+	}
+	return fmt.Sprintf("SkipReason(%d)", int(r))
+}
+
+// KeyJoin determines how the keys of nested members, such as those of
+// inlined structs, are joined to the names they are nested in.
+type KeyJoin int
+
+const (
+	// JoinSeparator joins keys with the separator, as in "cpu_user". It is
+	// the default.
+	JoinSeparator KeyJoin = iota
+	// JoinCamelCase joins keys by capitalizing the first letter of the
+	// nested key, as in "cpuUser".
+	JoinCamelCase
+)
+
+// keySeparator returns the separator placed after a name that keys are
+// nested in.
+func (o *Options) keySeparator() string {
+	if o.KeyJoin == JoinCamelCase {
+		return ""
+	}
+	return o.Separator
+}
+
+// joinKey returns key nested in prefix, which ends with the separator if
+// there is one.
+func (o *Options) joinKey(prefix, key string) string {
+	if prefix == "" || o.KeyJoin != JoinCamelCase {
+		return prefix + key
+	}
+	return prefix + upperFirst(key)
+}
+
+// upperFirst returns s with its first letter in upper case.
+func upperFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 || unicode.IsUpper(r) {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// lowerFirst returns s with its first letter in lower case.
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 || unicode.IsLower(r) {
+		return s
+	}
+	return string(unicode.ToLower(r)) + s[n:]
+}
+
+// DefaultOptions is used when no Options are given. It must not be modified.
+var DefaultOptions = NewOptions(nil)
+
+// NewOptions returns the defaults, with the "influx" tag key and "_"
+// separator, modified by each of opts in turn.
+func NewOptions(opts []Option) *Options {
+	o := &Options{
+		TagKey:    defaultTagKey,
+		Separator: defaultSeparator,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// maxDepth returns the limit on the nesting of inlined and embedded structs.
+func (o *Options) maxDepth() int {
+	if o.MaxDepth > 0 {
+		return o.MaxDepth
+	}
+	return defaultMaxDepth
+}
+
+// Now returns the timestamp to use when a value does not provide one.
+func (o *Options) Now() time.Time {
+	if !o.Time.IsZero() {
+		return o.Time
+	}
+	if o.Clock != nil {
+		return o.Clock()
+	}
+	return time.Now()
+}
+
+// PrecisionString returns the InfluxDB name of the precision d, or "" if it
+// has none.
+func PrecisionString(d time.Duration) string {
+	switch d {
+	case time.Nanosecond:
+		return "n"
+	case time.Microsecond:
+		return "u"
+	case time.Millisecond:
+		return "ms"
+	case time.Second:
+		return "s"
+	case time.Minute:
+		return "m"
+	case time.Hour:
+		return "h"
+	}
+	return ""
+}
+
+// PrecisionDuration returns the duration of the InfluxDB precision name s,
+// or 0 if it is not one.
+func PrecisionDuration(s string) time.Duration {
+	switch s {
+	case "n", "ns":
+		return time.Nanosecond
+	case "u", "us":
+		return time.Microsecond
+	case "ms":
+		return time.Millisecond
+	case "s":
+		return time.Second
+	case "m":
+		return time.Minute
+	case "h":
+		return time.Hour
+	}
+	return 0
+}
+
+// NaNPolicy determines how NaN and infinite float fields, which InfluxDB does
+// not accept, are encoded.
+type NaNPolicy struct {
+	mode  int
+	value float64
+}
+
+const (
+	nanError = iota
+	nanSkip
+	nanReplace
+)
+
+var (
+	// NaNError makes NaN and infinite fields an error. It is the default.
+	NaNError = NaNPolicy{mode: nanError}
+	// NaNSkip omits NaN and infinite fields.
+	NaNSkip = NaNPolicy{mode: nanSkip}
+)
+
+// NaNReplaceWith returns a NaNPolicy writing x in place of NaN and infinite
+// fields.
+func NaNReplaceWith(x float64) NaNPolicy {
+	return NaNPolicy{mode: nanReplace, value: x}
+}
+
+// SizePolicy determines how tag values longer than MaxNameSize, which InfluxDB
+// does not accept, are encoded.
+type SizePolicy struct {
+	mode   int
+	marker string
+}
+
+const (
+	sizeIgnore = iota
+	sizeError
+	sizeTruncate
+)
+
+var (
+	// SizeIgnore writes long tag values and names as they are. It is the
+	// default.
+	SizeIgnore = SizePolicy{mode: sizeIgnore}
+	// SizeError makes long tag values and names an error.
+	SizeError = SizePolicy{mode: sizeError}
+)
+
+// SizeTruncate returns a SizePolicy truncating long tag values to
+// MaxNameSize, ending with marker, and making long names an error.
+func SizeTruncate(marker string) SizePolicy {
+	return SizePolicy{mode: sizeTruncate, marker: marker}
+}
+
+// LimitTag applies the size policy of o to the value s of the tag key.
+func (o *Options) LimitTag(key, s string) (string, error) {
+	if len(s) <= MaxNameSize || o.TagSize.mode == sizeIgnore {
+		return s, nil
+	}
+	if o.TagSize.mode == sizeError {
+		return s, fmt.Errorf("tag %s: value of %d bytes: %w", key, len(s), ErrTooLong)
+	}
+	return truncate(s, MaxNameSize, o.TagSize.marker), nil
+}
+
+// LimitString applies the length limit of o to the string field value s.
+func (o *Options) LimitString(s string) string {
+	if o.MaxString <= 0 {
+		return s
+	}
+	return truncate(s, o.MaxString, o.StringMarker)
+}
+
+// truncate returns s shortened to at most n bytes, including marker, which
+// replaces the end of s. It does not split UTF-8 sequences of either.
+func truncate(s string, n int, marker string) string {
+	if len(s) <= n {
+		return s
+	}
+	if len(marker) >= n {
+		// no room for any of s, so the marker is shortened instead
+		return truncate(marker, n, "")
+	}
+	n -= len(marker)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + marker
+}
+
+// FiniteValue applies the NaN policy of o to the field value f if it is a
+// NaN or infinite float, omitting it regardless of the policy if omit is
+// set. It reports false if the field should be omitted.
+func (o *Options) FiniteValue(f reflect.Value, omit bool) (reflect.Value, bool, error) {
+	if k := f.Kind(); k != reflect.Float32 && k != reflect.Float64 {
+		return f, true, nil
+	}
+	x := f.Float()
+	if !math.IsNaN(x) && !math.IsInf(x, 0) {
+		return f, true, nil
+	}
+	switch {
+	case omit || o.NaN.mode == nanSkip:
+		return f, false, nil
+	case o.NaN.mode == nanReplace:
+		return reflect.ValueOf(o.NaN.value).Convert(f.Type()), true, nil
+	}
+	return f, false, fmt.Errorf("unsupported value %v", x)
+}
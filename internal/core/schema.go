@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// FieldType is the InfluxDB type of a field, which is fixed for each field
+// key of a measurement within a shard.
+type FieldType int
+
+const (
+	FieldFloat FieldType = iota + 1
+	FieldInteger
+	FieldUnsigned
+	FieldString
+	FieldBoolean
+)
+
+var fieldTypes = [...]string{
+	FieldFloat:    "float",
+	FieldInteger:  "integer",
+	FieldUnsigned: "unsigned",
+	FieldString:   "string",
+	FieldBoolean:  "boolean",
+}
+
+// String returns the InfluxDB name of t, such as "float".
+func (t FieldType) String() string {
+	if t > 0 && int(t) < len(fieldTypes) {
+		return fieldTypes[t]
+	}
+	return fmt.Sprintf("FieldType(%d)", int(t))
+}
+
+// ValueType returns the type of the field value v as written according to o,
+// or 0 if it is not a supported value.
+func ValueType(v reflect.Value, o *Options) FieldType {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o.ForceFloat {
+			return FieldFloat
+		}
+		return FieldInteger
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch {
+		case o.ForceFloat:
+			return FieldFloat
+		case o.Unsigned:
+			return FieldUnsigned
+		}
+		return FieldInteger
+	case reflect.Float32, reflect.Float64:
+		return FieldFloat
+	case reflect.String:
+		return FieldString
+	case reflect.Bool:
+		return FieldBoolean
+	case reflect.Slice:
+		if IsBytes(v) {
+			return FieldString
+		}
+	}
+	return 0
+}
+
+// SchemaConflictError is returned, with a SchemaTracker, when a field is
+// written with a type other than the one it was first written with, which
+// InfluxDB would reject.
+type SchemaConflictError struct {
+	Measurement string
+	Field       string
+	// Type is the type the field was first written with, and Conflict the
+	// type it is now written with
+	Type     FieldType
+	Conflict FieldType
+}
+
+func (e *SchemaConflictError) Error() string {
+	return fmt.Sprintf("field %s of measurement %s written as %s, but was %s", e.Field, e.Measurement, e.Conflict, e.Type)
+}
+
+// schemaKey identifies a field in a SchemaTracker.
+type schemaKey struct {
+	measurement string
+	field       string
+}
+
+// SchemaTracker remembers the type of each field written per measurement.
+// It is safe for concurrent use.
+type SchemaTracker struct {
+	onConflict func(err *SchemaConflictError)
+
+	mu    sync.Mutex
+	types map[schemaKey]FieldType
+}
+
+// NewSchemaTracker returns an empty SchemaTracker. If onConflict is nil,
+// conflicts are errors.
+func NewSchemaTracker(onConflict func(err *SchemaConflictError)) *SchemaTracker {
+	return &SchemaTracker{
+		onConflict: onConflict,
+		types:      make(map[schemaKey]FieldType),
+	}
+}
+
+// SchemaField is a field checked by a SchemaTracker.
+type SchemaField struct {
+	Key  string
+	Type FieldType
+}
+
+// Check compares the types of fields, written together to measurement, with
+// those seen before. If there is a conflict and s has no function to report
+// it to, it returns a *SchemaConflictError for the first, and records none of
+// fields. Otherwise, it records the types of the fields not seen before.
+func (s *SchemaTracker) Check(measurement string, fields []SchemaField) error {
+	s.mu.Lock()
+	var conflicts []*SchemaConflictError
+	for _, f := range fields {
+		t, ok := s.types[schemaKey{measurement, f.Key}]
+		if ok && t != f.Type && f.Type != 0 {
+			conflicts = append(conflicts, &SchemaConflictError{
+				Measurement: measurement,
+				Field:       f.Key,
+				Type:        t,
+				Conflict:    f.Type,
+			})
+		}
+	}
+	if len(conflicts) > 0 && s.onConflict == nil {
+		s.mu.Unlock()
+		return conflicts[0]
+	}
+	for _, f := range fields {
+		k := schemaKey{measurement, f.Key}
+		if _, ok := s.types[k]; !ok && f.Type != 0 {
+			s.types[k] = f.Type
+		}
+	}
+	s.mu.Unlock()
+
+	// outside the lock, in case onConflict uses s
+	for _, err := range conflicts {
+		s.onConflict(err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	influx "github.com/influxdata/influxdb1-client"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// ResponseReader is the interface for reading a stream of query responses,
+// such as the chunks of a chunked query. It is implemented by
+// *influx.ChunkedResponse. NextResponse must return io.EOF when there are no
+// more responses.
+type ResponseReader interface {
+	NextResponse() (*influx.Response, error)
+}
+
+// RowIterator decodes the rows of a streamed query response one at a time,
+// reading a new response only when the rows of the previous one have been
+// consumed. This keeps memory use bounded for large chunked queries:
+//
+//	it := influxmarshal.NewRowIterator(influx.NewChunkedResponse(body))
+//	for it.Next() {
+//	    var row CPU
+//	    if err := it.Scan(&row); err != nil {
+//	        return err
+//	    }
+//	    ...
+//	}
+//	if err := it.Err(); err != nil {
+//	    return err
+//	}
+type RowIterator struct {
+	d    *Decoder
+	r    ResponseReader
+	resp *influx.Response
+
+	// position of the current row within resp
+	result, series, row int
+
+	cur    *models.Row
+	values []interface{}
+	err    error
+
+	plans map[reflect.Type]*decodePlan
+}
+
+// NewRowIterator returns a RowIterator reading responses from r.
+func NewRowIterator(r ResponseReader) *RowIterator {
+	return defaultDecoder.NewRowIterator(r)
+}
+
+// NewRowIterator returns a RowIterator reading responses from r, which
+// decodes rows using the settings of d.
+func (d *Decoder) NewRowIterator(r ResponseReader) *RowIterator {
+	return &RowIterator{
+		d:     d,
+		r:     r,
+		plans: make(map[reflect.Type]*decodePlan),
+	}
+}
+
+// Next advances to the next row, reporting whether there is one. It returns
+// false at the end of the stream or on error, which is reported by Err.
+func (it *RowIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		if it.resp != nil {
+			if it.advance() {
+				return true
+			}
+		}
+		resp, err := it.r.NextResponse()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if resp.Err != nil {
+			it.err = resp.Err
+			return false
+		}
+		it.resp = resp
+		it.result, it.series, it.row = 0, 0, -1
+	}
+}
+
+// advance moves to the next row in the current response, reporting whether
+// there is one.
+func (it *RowIterator) advance() bool {
+	for it.result < len(it.resp.Results) {
+		res := &it.resp.Results[it.result]
+		if res.Err != nil {
+			it.err = res.Err
+			return false
+		}
+		for it.series < len(res.Series) {
+			row := &res.Series[it.series]
+			it.row++
+			if it.row < len(row.Values) {
+				it.cur = row
+				it.values = row.Values[it.row]
+				return true
+			}
+			it.series++
+			it.row = -1
+		}
+		it.result++
+		it.series = 0
+	}
+	it.resp = nil
+	return false
+}
+
+// Scan decodes the current row into dest, which must be a pointer to a
+// struct. Columns are matched to struct fields as they are by Unmarshal.
+func (it *RowIterator) Scan(dest interface{}) error {
+	if it.cur == nil {
+		return fmt.Errorf("Scan called without a successful call to Next")
+	}
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer to a struct")
+	}
+	sv := dv.Elem()
+	if sv.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+
+	plan, ok := it.plans[sv.Type()]
+	if !ok {
+		var err error
+		if plan, err = newDecodePlan(sv.Type()); err != nil {
+			return err
+		}
+		it.plans[sv.Type()] = plan
+	}
+	colFields := plan.columnFields(it.cur.Columns)
+	if err := it.d.checkColumns(it.cur.Columns, colFields, sv.Type()); err != nil {
+		return err
+	}
+	return plan.decodeRow(it.d, sv, it.cur, colFields, it.values)
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *RowIterator) Err() error {
+	return it.err
+}
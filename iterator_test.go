@@ -0,0 +1,105 @@
+package influxmarshal
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	influx "github.com/influxdata/influxdb1-client"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// chunks is a ResponseReader returning its responses in turn, then err, or
+// io.EOF.
+type chunks struct {
+	resps []*influx.Response
+	err   error
+	reads int
+}
+
+func (c *chunks) NextResponse() (*influx.Response, error) {
+	if len(c.resps) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, io.EOF
+	}
+	c.reads++
+	resp := c.resps[0]
+	c.resps = c.resps[1:]
+	return resp, nil
+}
+
+func TestRowIterator(t *testing.T) {
+	c := &chunks{resps: []*influx.Response{
+		{Results: []influx.Result{{Series: append(hostRows("a", "b"), hostRows("c")...)}}},
+		// empty chunks are skipped
+		{Results: []influx.Result{{}}},
+		{},
+		{Results: []influx.Result{{Series: hostRows()}, {Series: hostRows("d")}}},
+	}}
+	it := NewRowIterator(c)
+	var hosts []string
+	for it.Next() {
+		if len(hosts) == 0 && c.reads != 1 {
+			t.Errorf("read %d responses before the first row", c.reads)
+		}
+		var row decodedCPU
+		if err := it.Scan(&row); err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, row.Host)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 4 || hosts[0] != "a" || hosts[1] != "b" || hosts[2] != "c" || hosts[3] != "d" {
+		t.Fatalf("got %q", hosts)
+	}
+	if it.Next() {
+		t.Error("Next after the end")
+	}
+}
+
+func TestRowIteratorErrors(t *testing.T) {
+	it := NewRowIterator(&chunks{})
+	var row decodedCPU
+	if err := it.Scan(&row); err == nil {
+		t.Error("Scan before Next: no error")
+	}
+
+	broken := errors.New("unexpected EOF")
+	for _, c := range []*chunks{
+		{resps: []*influx.Response{{Results: []influx.Result{{Series: hostRows("a")}}}}, err: broken},
+		{resps: []*influx.Response{{Results: []influx.Result{{Series: hostRows("a")}}}, {Err: broken}}},
+		{resps: []*influx.Response{{Results: []influx.Result{{Series: hostRows("a")}, {Err: broken}}}}},
+	} {
+		it := NewRowIterator(c)
+		n := 0
+		for it.Next() {
+			n++
+		}
+		if n != 1 || it.Err() != broken {
+			t.Errorf("got %d rows and %v", n, it.Err())
+		}
+		if it.Next() {
+			t.Error("Next after an error")
+		}
+	}
+
+	it = NewDecoder(DisallowUnknownColumns()).NewRowIterator(&chunks{resps: []*influx.Response{
+		{Results: []influx.Result{{Series: []models.Row{{Columns: []string{"host", "other"}, Values: [][]interface{}{{"a", "b"}}}}}}},
+	}})
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	if err := it.Scan(&row); err == nil {
+		t.Error("unknown column: no error")
+	}
+	var n int
+	for _, dest := range []interface{}{row, &n, nil} {
+		if err := it.Scan(dest); err == nil {
+			t.Errorf("%T: no error", dest)
+		}
+	}
+}
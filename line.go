@@ -0,0 +1,47 @@
+package influxmarshal
+
+import (
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/lineprotocol"
+)
+
+// MarshalLine returns the InfluxDB line protocol representation of v,
+// without a trailing newline. It accepts the same values and options as
+// MarshalWithOptions.
+// (ref: https://docs.influxdata.com/influxdb/v1.7/write_protocols/line_protocol_reference/)
+//
+// Tags are written in key order, as recommended by InfluxDB. Fields are
+// written in struct order, followed by any dynamic fields, unless
+// WithSortedKeys is used. Tags with empty values are not permitted by line
+// protocol and are skipped.
+func MarshalLine(v interface{}, measurement string, opts ...Option) (string, error) {
+	return lineprotocol.MarshalLine(v, measurement, opts...)
+}
+
+// LineAppender is the interface implemented by types that can append their
+// own line protocol, followed by a newline, without reflection, such as those
+// generated by influxmarshalgen. t is used as by AppendPoint. AppendPoint,
+// and AppendLine without options, use the AppendInfluxLine method of v if it
+// has one.
+type LineAppender = lineprotocol.LineAppender
+
+// AppendLine appends the line protocol representation of v to dst, followed
+// by a newline, and returns the extended buffer. On error, dst is returned
+// unmodified.
+func AppendLine(dst []byte, v interface{}, measurement string, opts ...Option) ([]byte, error) {
+	return lineprotocol.AppendLine(dst, v, measurement, opts...)
+}
+
+// AppendPoint is like AppendLine, but writes t as the timestamp of the point
+// unless v has a timestamp member of its own. If t is the zero time, the
+// timestamp is left for the server to assign.
+//
+// AppendPoint writes each member directly into dst without building an
+// intermediate point, and so does not allocate unless v has members with
+// the "tags" or "fields" options or members implementing fmt.Stringer,
+// encoding.TextMarshaler or InfluxValuer, or dst must grow. Passing v as a
+// pointer avoids the allocation of converting it to an interface.
+func AppendPoint(dst []byte, v interface{}, measurement string, t time.Time) ([]byte, error) {
+	return lineprotocol.AppendPoint(dst, v, measurement, t)
+}
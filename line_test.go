@@ -0,0 +1,116 @@
+package influxmarshal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type escapeValue struct {
+	Path  string    `influx:"path,tag"`
+	Name  string    `influx:"name,tag"`
+	Text  string    `influx:"text"`
+	Count int       `influx:"count"`
+	Time  time.Time `influx:",time"`
+}
+
+func TestMarshalLineEscaping(t *testing.T) {
+	tests := []struct {
+		name string
+		v    escapeValue
+		want string
+	}{
+		{
+			name: "special characters",
+			v:    escapeValue{Path: "a b", Name: "x,y=z", Text: `say "hi" \o/`, Count: 1},
+			want: `m,name=x\,y\=z,path=a\ b count=1i,text="say \"hi\" \\o/" 1`,
+		},
+		{
+			name: "backslashes and tabs are literal",
+			v:    escapeValue{Path: `C:\temp\new`, Name: "a\tb", Count: 1},
+			want: "m,name=a\tb,path=C:\\temp\\new count=1i,text=\"\" 1",
+		},
+		{
+			name: "line breaks in string fields",
+			v:    escapeValue{Path: "p", Text: "two\nlines", Count: 1},
+			want: "m,path=p count=1i,text=\"two\nlines\" 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.v.Time = time.Unix(0, 1)
+			got, err := MarshalLine(tt.v, "m", WithSortedKeys())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+
+			var back escapeValue
+			if err := UnmarshalLine(got, &back); err != nil {
+				t.Fatal(err)
+			}
+			if back.Path != tt.v.Path || back.Name != tt.v.Name || back.Text != tt.v.Text {
+				t.Fatalf("round trip: got %+v, want %+v", back, tt.v)
+			}
+		})
+	}
+}
+
+func TestMarshalLineLineBreak(t *testing.T) {
+	for _, v := range []escapeValue{
+		{Path: "a\nb", Count: 1},
+		{Name: "a\rb", Count: 1},
+	} {
+		if _, err := MarshalLine(v, "m"); !errors.Is(err, ErrLineBreak) {
+			t.Errorf("%q: got error %v, want ErrLineBreak", v.Path+v.Name, err)
+		}
+	}
+	if _, err := MarshalLine(escapeValue{Count: 1}, "m\n"); !errors.Is(err, ErrLineBreak) {
+		t.Errorf("measurement: got error %v, want ErrLineBreak", err)
+	}
+}
+
+func TestUnmarshalLineEscapes(t *testing.T) {
+	tests := []struct {
+		line string
+		want escapeValue
+	}{
+		// Telegraf writes Windows paths without escaping backslashes
+		{`m,path=C:\temp\new count=1i`, escapeValue{Path: `C:\temp\new`, Count: 1}},
+		{`m,path=a\ b\,c\=d count=1i`, escapeValue{Path: `a b,c=d`, Count: 1}},
+		{`m,path=a\"b count=1i`, escapeValue{Path: `a\"b`, Count: 1}},
+		{`m count=1i,text="a\"b\\c\n"`, escapeValue{Text: `a"b\c\n`, Count: 1}},
+	}
+	for _, tt := range tests {
+		var got escapeValue
+		if err := UnmarshalLine(tt.line, &got); err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeFunctions(t *testing.T) {
+	tests := []struct {
+		fn   func(string) string
+		in   string
+		want string
+	}{
+		{EscapeMeasurement, "cpu load,x=1", `cpu\ load\,x=1`},
+		{EscapeTagKey, "a b,c=d", `a\ b\,c\=d`},
+		{EscapeTagValue, `C:\temp`, `C:\temp`},
+		{EscapeTagValue, "a\tb", "a\tb"},
+		{EscapeFieldKey, "ok", "ok"},
+		{EscapeStringField, `a"b\c`, `a\"b\\c`},
+	}
+	for _, tt := range tests {
+		if got := tt.fn(tt.in); got != tt.want {
+			t.Errorf("escape %q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
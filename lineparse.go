@@ -0,0 +1,280 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// UnmarshalLine parses a single line of InfluxDB line protocol and stores
+// the result in dest, which must be a pointer to a struct.
+//
+// Tags and fields are matched to struct members using the same "influx"
+// struct tags understood by Marshal. Tags are decoded into members with the
+// "tag" option, and may be parsed into numeric or boolean members as well
+// as strings. Tags and fields without a matching member are collected by
+// members with the "tags" and "fields" options, if present, and ignored
+// otherwise. The timestamp, if any, is decoded into the timestamp member and
+// must be in nanoseconds.
+func UnmarshalLine(line string, dest interface{}) error {
+	p, err := parseLine(line, time.Nanosecond)
+	if err != nil {
+		return err
+	}
+	return unmarshalPoint(p, dest)
+}
+
+// unmarshalPoint stores the tags, fields, and time of p in dest.
+func unmarshalPoint(p influx.Point, dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer to a struct")
+	}
+	sv := dv.Elem()
+	if sv.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+
+	info, err := core.CompileType(sv.Type(), core.NewOptions(nil))
+	if err != nil {
+		return err
+	}
+
+	// first pass: named members, remembering which keys were claimed
+	usedTags := make(map[string]bool, len(info.TagOrder))
+	usedFields := make(map[string]bool, len(info.FieldOrder))
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		switch {
+		case fi.TagMap, fi.FieldMap, fi.Flatten:
+			continue
+		case fi.Time:
+			if !p.Time.IsZero() {
+				fieldByIndexAlloc(sv, fi.Index).Set(reflect.ValueOf(p.Time))
+			}
+		case fi.Tag:
+			v, ok := p.Tags[fi.Name]
+			if !ok {
+				continue
+			}
+			usedTags[fi.Name] = true
+			if err := defaultDecoder.setMember(sv, fi, v); err != nil {
+				return fmt.Errorf("tag %s: %v", fi.Name, err)
+			}
+		default:
+			v, ok := p.Fields[fi.Name]
+			if !ok {
+				continue
+			}
+			usedFields[fi.Name] = true
+			if err := defaultDecoder.setMember(sv, fi, v); err != nil {
+				return fmt.Errorf("field %s: %v", fi.Name, err)
+			}
+		}
+	}
+
+	// second pass: maps collect whatever is left over
+	for _, fi := range info.Fields {
+		var (
+			src  map[string]interface{}
+			used map[string]bool
+		)
+		switch {
+		case fi.TagMap:
+			src = make(map[string]interface{}, len(p.Tags))
+			for k, v := range p.Tags {
+				src[k] = v
+			}
+			used = usedTags
+		case fi.FieldMap:
+			src, used = p.Fields, usedFields
+		default:
+			continue
+		}
+		m := fieldByIndexAlloc(sv, fi.Index)
+		if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("member %s must be a map with string keys", fi.GoName)
+		}
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(m.Type()))
+		}
+		for key, v := range src {
+			k, ok := fi.MapEntryKey(key)
+			if used[key] || !ok {
+				continue
+			}
+			ev := reflect.New(m.Type().Elem()).Elem()
+			if ev.Kind() == reflect.Interface {
+				ev.Set(reflect.ValueOf(v))
+			} else if err := defaultDecoder.setValue(ev, v); err != nil {
+				return fmt.Errorf("key %s: %v", k, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(m.Type().Key()), ev)
+		}
+	}
+	return nil
+}
+
+// parseLine parses a single line of line protocol into a point, reading the
+// timestamp as a count of precision. Field values are returned as int64,
+// uint64, float64, bool or string.
+func parseLine(line string, precision time.Duration) (influx.Point, error) {
+	var p influx.Point
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return p, fmt.Errorf("empty line")
+	}
+
+	name, i := scanToken(line, 0, ", ")
+	if name == "" {
+		return p, fmt.Errorf("missing measurement")
+	}
+	p.Measurement = name
+
+	p.Tags = make(map[string]string)
+	for i < len(line) && line[i] == ',' {
+		var k, v string
+		k, i = scanToken(line, i+1, "=, ")
+		if i >= len(line) || line[i] != '=' || k == "" {
+			return p, fmt.Errorf("invalid tag at offset %d", i)
+		}
+		v, i = scanToken(line, i+1, ", ")
+		if v == "" {
+			return p, fmt.Errorf("missing value for tag %s", k)
+		}
+		p.Tags[k] = v
+	}
+
+	if i >= len(line) || line[i] != ' ' {
+		return p, fmt.Errorf("missing fields")
+	}
+	p.Fields = make(map[string]interface{})
+	for sep := byte(' '); i < len(line) && line[i] == sep; sep = ',' {
+		var k string
+		k, i = scanToken(line, i+1, "=, ")
+		if i >= len(line) || line[i] != '=' || k == "" {
+			return p, fmt.Errorf("invalid field at offset %d", i)
+		}
+		var (
+			v   interface{}
+			err error
+		)
+		v, i, err = scanFieldValue(line, i+1)
+		if err != nil {
+			return p, fmt.Errorf("field %s: %v", k, err)
+		}
+		p.Fields[k] = v
+	}
+
+	if i < len(line) {
+		if line[i] != ' ' {
+			return p, fmt.Errorf("unexpected %q at offset %d", line[i], i)
+		}
+		ts := strings.TrimSpace(line[i+1:])
+		n, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		p.Time = time.Unix(0, n*int64(precision))
+	}
+	return p, nil
+}
+
+// isTokenEscape reports whether c is escaped by a preceding backslash in a
+// name, key or tag value. A backslash before any other byte is literal, as
+// in the Windows path C:\temp.
+func isTokenEscape(c byte) bool {
+	return c == ',' || c == '=' || c == ' '
+}
+
+// scanToken reads an escaped token from s starting at i, stopping at the
+// first unescaped byte in stops. It returns the unescaped token and the
+// index of the byte that stopped it.
+func scanToken(s string, i int, stops string) (string, int) {
+	var b []byte
+	start := i
+	for i < len(s) {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			if u := s[i+1]; isTokenEscape(u) {
+				if b == nil {
+					b = append(b, s[start:i]...)
+				}
+				b = append(b, u)
+				i += 2
+				continue
+			}
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		if b != nil {
+			b = append(b, c)
+		}
+		i++
+	}
+	if b == nil {
+		return s[start:i], i
+	}
+	return string(b), i
+}
+
+// scanFieldValue reads a field value from s starting at i, returning the
+// value and the index just past it.
+func scanFieldValue(s string, i int) (interface{}, int, error) {
+	if i < len(s) && s[i] == '"' {
+		var b []byte
+		for i++; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+				i++
+				b = append(b, s[i])
+				continue
+			}
+			if c == '"' {
+				return string(b), i + 1, nil
+			}
+			b = append(b, c)
+		}
+		return nil, i, fmt.Errorf("unterminated string")
+	}
+
+	end := i
+	for end < len(s) && s[end] != ',' && s[end] != ' ' {
+		end++
+	}
+	raw := s[i:end]
+	if raw == "" {
+		return nil, end, fmt.Errorf("missing value")
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return true, end, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, end, nil
+	}
+	switch raw[len(raw)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return nil, end, fmt.Errorf("invalid integer %q", raw)
+		}
+		return n, end, nil
+	case 'u':
+		n, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return nil, end, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return n, end, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, end, fmt.Errorf("invalid value %q", raw)
+	}
+	return f, end, nil
+}
@@ -0,0 +1,94 @@
+package influxmarshal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type parsedCPU struct {
+	Host   string                 `influx:"host,tag"`
+	Core   int                    `influx:"core,tag"`
+	Usage  float64                `influx:"usage"`
+	Count  int64                  `influx:"count"`
+	Total  uint64                 `influx:"total"`
+	Up     bool                   `influx:"up"`
+	Note   string                 `influx:"note"`
+	Tags   map[string]string      `influx:",tags"`
+	Fields map[string]interface{} `influx:",fields"`
+	Time   time.Time              `influx:"time,time"`
+}
+
+func TestUnmarshalLine(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want parsedCPU
+	}{
+		{
+			line: `cpu,host=a\ b,core=3 usage=0.5,count=-2i,total=7u,up=t,note="say \"hi\"" 1700000000000000001`,
+			want: parsedCPU{Host: "a b", Core: 3, Usage: 0.5, Count: -2, Total: 7, Up: true, Note: `say "hi"`, Time: time.Unix(0, 1700000000000000001)},
+		},
+		{
+			// extra tags and fields are collected, and the time is optional
+			line: "cpu,region=eu usage=1,extra=2i,flag=F\n",
+			want: parsedCPU{Usage: 1, Tags: map[string]string{"region": "eu"}, Fields: map[string]interface{}{"extra": int64(2), "flag": false}},
+		},
+		{
+			line: `cpu,path=C:\temp count=1i`,
+			want: parsedCPU{Count: 1, Tags: map[string]string{"path": `C:\temp`}},
+		},
+	} {
+		var got parsedCPU
+		if err := UnmarshalLine(tt.line, &got); err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		// the maps are allocated whether or not they collect anything
+		if len(got.Tags) == 0 {
+			got.Tags = nil
+		}
+		if len(got.Fields) == 0 {
+			got.Fields = nil
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q:\ngot  %+v\nwant %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalLineErrors(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want string
+	}{
+		{"", "empty line"},
+		{",host=a usage=1", "missing measurement"},
+		{"cpu,host usage=1", "invalid tag"},
+		{"cpu,host= usage=1", "missing value for tag host"},
+		{"cpu,host=a", "missing fields"},
+		{"cpu usage", "invalid field"},
+		{"cpu usage=", "field usage: missing value"},
+		{`cpu note="open`, "field note: unterminated string"},
+		{"cpu usage=1 soon", `invalid timestamp "soon"`},
+		{"cpu usage=1,", "invalid field"},
+		// decoding
+		{"cpu,core=x usage=1", "tag core:"},
+		{`cpu usage="high"`, "field usage:"},
+		{"cpu count=1.5", "field count:"},
+		{"cpu total=-1i", "field total:"},
+	} {
+		var got parsedCPU
+		err := UnmarshalLine(tt.line, &got)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want %q", tt.line, err, tt.want)
+		}
+	}
+
+	var n int
+	for _, dest := range []interface{}{parsedCPU{}, &n, nil} {
+		if err := UnmarshalLine("cpu usage=1", dest); err == nil {
+			t.Errorf("%T: no error", dest)
+		}
+	}
+}
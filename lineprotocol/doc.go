@@ -0,0 +1,85 @@
+// Package lineprotocol encodes Go structs as InfluxDB line protocol, using
+// the same struct tags and options as package influxmarshal. Unlike
+// influxmarshal, it does not depend on the InfluxDB client packages, and so
+// suits programs that only write line protocol, such as to a file or an HTTP
+// request of their own.
+//
+// The encoding rules are described by influxmarshal.Marshal.
+package lineprotocol
+
+import "github.com/flowchartsman/influxmarshal/internal/core"
+
+var (
+	// ErrNoFields is returned when a value would produce a point without
+	// any fields, which InfluxDB does not accept.
+	ErrNoFields = core.ErrNoFields
+
+	// ErrLineBreak is returned when a measurement, tag key, tag value or
+	// field key contains a newline or carriage return, which line protocol
+	// has no way to escape.
+	ErrLineBreak = core.ErrLineBreak
+
+	// ErrReservedName is returned, with WithNameValidation, for a
+	// measurement, tag key or field key that InfluxDB reserves: "time", or
+	// one beginning with an underscore.
+	ErrReservedName = core.ErrReservedName
+
+	// ErrTooLong is returned, with WithSizePolicy, for a measurement, tag
+	// key, tag value or field key longer than MaxNameSize.
+	ErrTooLong = core.ErrTooLong
+
+	// ErrNotStruct is returned when a value to encode is not a struct or a
+	// pointer to one.
+	ErrNotStruct = core.ErrNotStruct
+
+	// ErrRequired is returned, wrapped with the name of the member, when a
+	// member with the "required" option is zero or nil.
+	ErrRequired = core.ErrRequired
+)
+
+// UnsupportedTypeError is returned when a member holds a value that cannot be
+// encoded as a tag or field.
+type UnsupportedTypeError = core.UnsupportedTypeError
+
+// DuplicateKeyError is returned when two members of a struct are encoded
+// with the same key.
+type DuplicateKeyError = core.DuplicateKeyError
+
+// TagSyntaxError is returned when a struct tag holds an option that is not
+// recognized.
+type TagSyntaxError = core.TagSyntaxError
+
+// Option customizes the encoding of a value.
+type Option = core.Option
+
+// InfluxValuer is the interface for your type to return a tag or field value
+type InfluxValuer = core.InfluxValuer
+
+// InfluxTagValuer is the interface for your type to return its value as a
+// tag, in preference to InfluxValuer.
+type InfluxTagValuer = core.InfluxTagValuer
+
+// InfluxFieldValuer is the interface for your type to return its value as a
+// field, in preference to InfluxValuer.
+type InfluxFieldValuer = core.InfluxFieldValuer
+
+// BeforeMarshaler is the interface for your type to be called before it is
+// encoded, as described for influxmarshal.BeforeMarshaler.
+type BeforeMarshaler = core.BeforeMarshaler
+
+// Measurementer is the interface for your type to provide its own
+// measurement name.
+type Measurementer = core.Measurementer
+
+// InfluxTagger is the interface for your type to provide computed tags in
+// addition to those of its members, as described for
+// influxmarshal.InfluxTagger.
+type InfluxTagger = core.InfluxTagger
+
+// Timestamper is the interface for your type to provide the timestamp of its
+// point. A zero time is treated as no timestamp.
+type Timestamper = core.Timestamper
+
+// Measurement can be embedded in a struct to declare the measurement name
+// with a struct tag, as described for influxmarshal.Measurement.
+type Measurement = core.Measurement
@@ -0,0 +1,53 @@
+package lineprotocol
+
+import "github.com/flowchartsman/influxmarshal/internal/core"
+
+// EscapeMeasurement escapes s for use as a measurement name in line
+// protocol. Commas and spaces are escaped with a backslash. Newlines and
+// carriage returns cannot be escaped, and are passed through unchanged; the
+// encoding functions reject them with ErrLineBreak.
+func EscapeMeasurement(s string) string {
+	return core.Escape(s, core.NameEscapes)
+}
+
+// EscapeTagKey escapes s for use as a tag key in line protocol. Commas,
+// equals signs and spaces are escaped with a backslash. Line breaks are
+// passed through as described for EscapeMeasurement.
+func EscapeTagKey(s string) string {
+	return core.Escape(s, core.KeyEscapes)
+}
+
+// EscapeTagValue escapes s for use as a tag value in line protocol, following
+// the same rules as EscapeTagKey.
+func EscapeTagValue(s string) string {
+	return core.Escape(s, core.KeyEscapes)
+}
+
+// EscapeFieldKey escapes s for use as a field key in line protocol, following
+// the same rules as EscapeTagKey.
+func EscapeFieldKey(s string) string {
+	return core.Escape(s, core.KeyEscapes)
+}
+
+// EscapeStringField escapes s for use as a string field value in line
+// protocol. Double quotes and backslashes are escaped with a backslash. The
+// surrounding double quotes are not included.
+func EscapeStringField(s string) string {
+	return core.Escape(s, core.StringEscapes)
+}
+
+// AppendEscapedMeasurement appends s to b escaped as by EscapeMeasurement. It
+// is intended for generated code, such as that of influxmarshalgen.
+func AppendEscapedMeasurement(b []byte, s string) []byte {
+	return core.AppendEscaped(b, s, core.NameEscapes)
+}
+
+// AppendEscapedTagValue appends s to b escaped as by EscapeTagValue.
+func AppendEscapedTagValue(b []byte, s string) []byte {
+	return core.AppendEscaped(b, s, core.KeyEscapes)
+}
+
+// AppendEscapedStringField appends s to b escaped as by EscapeStringField.
+func AppendEscapedStringField(b []byte, s string) []byte {
+	return core.AppendEscaped(b, s, core.StringEscapes)
+}
@@ -0,0 +1,113 @@
+package lineprotocol
+
+import (
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+)
+
+// MarshalLine returns the InfluxDB line protocol representation of v,
+// without a trailing newline. It accepts the same values as
+// influxmarshal.MarshalWithOptions.
+// (ref: https://docs.influxdata.com/influxdb/v1.7/write_protocols/line_protocol_reference/)
+//
+// Tags are written in key order, as recommended by InfluxDB. Fields are
+// written in struct order, followed by any dynamic fields, unless
+// WithSortedKeys is used. Tags with empty values are not permitted by line
+// protocol and are skipped.
+func MarshalLine(v interface{}, measurement string, opts ...Option) (string, error) {
+	o := core.NewOptions(opts)
+	val, err := core.StructValue(v)
+	if err != nil {
+		return "", err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return "", err
+	}
+	b, err := core.AppendStruct(nil, v, val, info, measurement, o.Now(), o)
+	if err != nil {
+		return "", err
+	}
+	return core.BytesString(b, o.UnsafeStrings), nil
+}
+
+// LineAppender is the interface implemented by types that can append their
+// own line protocol, followed by a newline, without reflection, such as those
+// generated by influxmarshalgen. t is used as by AppendPoint. AppendPoint,
+// and AppendLine without options, use the AppendInfluxLine method of v if it
+// has one.
+type LineAppender interface {
+	AppendInfluxLine(dst []byte, measurement string, t time.Time) ([]byte, error)
+}
+
+// AppendLine appends the line protocol representation of v to dst, followed
+// by a newline, and returns the extended buffer. On error, dst is returned
+// unmodified.
+func AppendLine(dst []byte, v interface{}, measurement string, opts ...Option) ([]byte, error) {
+	if la, ok := v.(LineAppender); ok && len(opts) == 0 {
+		return la.AppendInfluxLine(dst, measurement, time.Now())
+	}
+	o := core.NewOptions(opts)
+	val, err := core.StructValue(v)
+	if err != nil {
+		return dst, err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return dst, err
+	}
+	b, err := core.AppendStruct(dst, v, val, info, measurement, o.Now(), o)
+	if err != nil {
+		return dst, err
+	}
+	return append(b, '\n'), nil
+}
+
+// AppendPoint is like AppendLine, but writes t as the timestamp of the point
+// unless v has a timestamp member of its own. If t is the zero time, the
+// timestamp is left for the server to assign.
+//
+// AppendPoint writes each member directly into dst without building an
+// intermediate point, and so does not allocate unless v has members with
+// the "tags" or "fields" options or members implementing fmt.Stringer,
+// encoding.TextMarshaler or InfluxValuer, or dst must grow. Passing v as a
+// pointer avoids the allocation of converting it to an interface.
+func AppendPoint(dst []byte, v interface{}, measurement string, t time.Time) ([]byte, error) {
+	if la, ok := v.(LineAppender); ok {
+		return la.AppendInfluxLine(dst, measurement, t)
+	}
+	val, err := core.StructValue(v)
+	if err != nil {
+		return dst, err
+	}
+	info, err := core.CompileType(val.Type(), core.DefaultOptions)
+	if err != nil {
+		return dst, err
+	}
+	b, err := core.AppendStruct(dst, v, val, info, measurement, t, core.DefaultOptions)
+	if err != nil {
+		return dst, err
+	}
+	return append(b, '\n'), nil
+}
+
+// AppendMaps appends the line protocol for the point with the given
+// measurement, tags, fields and timestamp to dst, followed by a newline, for
+// points given by their parts, such as those passed to an
+// influxmarshal.PointSink. Tags and fields are written in key order, and tags
+// with empty values are skipped. The field values must be of the types
+// supported for members, and are affected by the same options. If t is the
+// zero time, the timestamp is left for the server to assign. On error, dst is
+// returned unmodified.
+func AppendMaps(dst []byte, measurement string, tags map[string]string, fields map[string]interface{}, t time.Time, opts ...Option) ([]byte, error) {
+	o := core.DefaultOptions
+	if len(opts) > 0 {
+		o = core.NewOptions(opts)
+	}
+	b, err := core.AppendMaps(dst, measurement, tags, fields, t, o)
+	if err != nil {
+		return dst, err
+	}
+	return append(b, '\n'), nil
+}
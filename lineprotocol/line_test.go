@@ -0,0 +1,67 @@
+package lineprotocol
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type cpu struct {
+	Measurement `influx:"cpu"`
+	Host        string    `influx:"host,tag"`
+	Usage       float64   `influx:"usage"`
+	Count       uint      `influx:"count"`
+	Time        time.Time `influx:",time"`
+}
+
+func TestMarshalLine(t *testing.T) {
+	v := cpu{Host: "a b", Usage: 0.5, Count: 3, Time: time.Unix(2, 0)}
+	got, err := MarshalLine(v, "", WithUnsigned(), WithPrecision(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `cpu,host=a\ b usage=0.5,count=3u 2`; got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestAppendPoint(t *testing.T) {
+	b, err := AppendPoint([]byte("x\n"), &cpu{Host: "h", Usage: 1}, "m", time.Unix(0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "x\nm,host=h usage=1,count=0i 5\n"; string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+	if _, err := AppendPoint(nil, cpu{Host: "a\nb", Usage: 1}, "", time.Time{}); !errors.Is(err, ErrLineBreak) {
+		t.Fatalf("got error %v, want ErrLineBreak", err)
+	}
+}
+
+func TestAppendMaps(t *testing.T) {
+	tags := map[string]string{"b": "x y", "a": "1", "empty": ""}
+	fields := map[string]interface{}{"v": 1.5, "n": int64(2), "s": `"q"`}
+	b, err := AppendMaps(nil, "m", tags, fields, time.Unix(3, 0), WithPrecision(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "m,a=1,b=x\\ y n=2i,s=\"\\\"q\\\"\",v=1.5 3\n"; string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+	if _, err := AppendMaps(nil, "m", tags, nil, time.Time{}); !errors.Is(err, ErrNoFields) {
+		t.Fatalf("got error %v, want ErrNoFields", err)
+	}
+	if _, err := AppendMaps(nil, "m", nil, map[string]interface{}{"x": nil}, time.Time{}); err == nil {
+		t.Fatal("expected error for nil field value")
+	}
+
+	s := NewSchemaTracker(nil)
+	if _, err := AppendMaps(nil, "m", nil, fields, time.Time{}, WithSchemaTracker(s)); err != nil {
+		t.Fatal(err)
+	}
+	var sce *SchemaConflictError
+	_, err = AppendMaps(nil, "m", nil, map[string]interface{}{"n": 2.5}, time.Time{}, WithSchemaTracker(s))
+	if !errors.As(err, &sce) || sce.Field != "n" || sce.Type != FieldInteger {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package lineprotocol
+
+import (
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+)
+
+// WithTime is the option of the same name in influxmarshal. See
+// influxmarshal.WithTime.
+func WithTime(t time.Time) Option {
+	return func(o *core.Options) {
+		o.Time = t
+	}
+}
+
+// WithClock is the option of the same name in influxmarshal. See
+// influxmarshal.WithClock.
+func WithClock(now func() time.Time) Option {
+	return func(o *core.Options) {
+		o.Clock = now
+	}
+}
+
+// WithExtraTags is the option of the same name in influxmarshal. See
+// influxmarshal.WithExtraTags.
+func WithExtraTags(tags map[string]string) Option {
+	return func(o *core.Options) {
+		if o.Tags == nil {
+			o.Tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			o.Tags[k] = v
+		}
+	}
+}
+
+// WithExtraFields is the option of the same name in influxmarshal. See
+// influxmarshal.WithExtraFields.
+func WithExtraFields(fields map[string]interface{}) Option {
+	return func(o *core.Options) {
+		if o.Fields == nil {
+			o.Fields = make(map[string]interface{}, len(fields))
+		}
+		for k, v := range fields {
+			o.Fields[k] = v
+		}
+	}
+}
+
+// WithTagKey is the option of the same name in influxmarshal. See
+// influxmarshal.WithTagKey.
+func WithTagKey(key string) Option {
+	return func(o *core.Options) {
+		o.TagKey = key
+	}
+}
+
+// WithSeparator is the option of the same name in influxmarshal. See
+// influxmarshal.WithSeparator.
+func WithSeparator(sep string) Option {
+	return func(o *core.Options) {
+		o.Separator = sep
+	}
+}
+
+// KeyJoin determines how the keys of nested members, such as those of
+// inlined structs, are joined to the names they are nested in.
+type KeyJoin = core.KeyJoin
+
+const (
+	// JoinSeparator joins keys with the separator set by WithSeparator, as
+	// in "cpu_user". It is the default.
+	JoinSeparator = core.JoinSeparator
+	// JoinCamelCase joins keys by capitalizing the first letter of the
+	// nested key, as in "cpuUser". The separator is not used.
+	JoinCamelCase = core.JoinCamelCase
+)
+
+// WithKeyJoin is the option of the same name in influxmarshal. See
+// influxmarshal.WithKeyJoin.
+func WithKeyJoin(j KeyJoin) Option {
+	return func(o *core.Options) {
+		o.KeyJoin = j
+	}
+}
+
+// WithPrecision is the option of the same name in influxmarshal. See
+// influxmarshal.WithPrecision.
+func WithPrecision(d time.Duration) Option {
+	return func(o *core.Options) {
+		o.Precision = d
+	}
+}
+
+// WithUnsigned is the option of the same name in influxmarshal. See
+// influxmarshal.WithUnsigned.
+func WithUnsigned() Option {
+	return func(o *core.Options) {
+		o.Unsigned = true
+	}
+}
+
+// WithForceFloat is the option of the same name in influxmarshal. See
+// influxmarshal.WithForceFloat.
+func WithForceFloat() Option {
+	return func(o *core.Options) {
+		o.ForceFloat = true
+	}
+}
+
+// WithSortedKeys is the option of the same name in influxmarshal. See
+// influxmarshal.WithSortedKeys.
+func WithSortedKeys() Option {
+	return func(o *core.Options) {
+		o.SortKeys = true
+	}
+}
+
+// WithUnsafeStrings is the option of the same name in influxmarshal. See
+// influxmarshal.WithUnsafeStrings.
+func WithUnsafeStrings() Option {
+	return func(o *core.Options) {
+		o.UnsafeStrings = true
+	}
+}
+
+// NaNPolicy determines how NaN and infinite float fields, which InfluxDB does
+// not accept, are encoded.
+type NaNPolicy = core.NaNPolicy
+
+var (
+	// NaNError makes NaN and infinite fields an error. It is the default.
+	NaNError = core.NaNError
+	// NaNSkip omits NaN and infinite fields.
+	NaNSkip = core.NaNSkip
+)
+
+// NaNReplaceWith returns a NaNPolicy writing x in place of NaN and infinite
+// fields.
+func NaNReplaceWith(x float64) NaNPolicy {
+	return core.NaNReplaceWith(x)
+}
+
+// WithNaNPolicy is the option of the same name in influxmarshal. See
+// influxmarshal.WithNaNPolicy.
+func WithNaNPolicy(p NaNPolicy) Option {
+	return func(o *core.Options) {
+		o.NaN = p
+	}
+}
+
+// WithMaxDepth is the option of the same name in influxmarshal. See
+// influxmarshal.WithMaxDepth.
+func WithMaxDepth(n int) Option {
+	return func(o *core.Options) {
+		o.MaxDepth = n
+	}
+}
+
+// SkipReason is the reason a member was omitted from a point, as reported to
+// the function given to WithOnFieldSkipped.
+type SkipReason = core.SkipReason
+
+const (
+	// SkipNil is for a nil pointer or interface, a SQL NULL, or a nil value
+	// with the "omitempty" option.
+	SkipNil = core.SkipNil
+	// SkipZero is for a zero value with the "omitzero" option.
+	SkipZero = core.SkipZero
+	// SkipEmpty is for an empty value with the "omitempty" option, or an
+	// empty tag value, which line protocol cannot represent.
+	SkipEmpty = core.SkipEmpty
+	// SkipNaN is for a NaN or infinite float omitted by the "omitnan" option
+	// or the NaN policy.
+	SkipNaN = core.SkipNaN
+)
+
+// WithOnFieldSkipped is the option of the same name in influxmarshal. See
+// influxmarshal.WithOnFieldSkipped.
+func WithOnFieldSkipped(fn func(key string, reason SkipReason)) Option {
+	return func(o *core.Options) {
+		o.OnSkip = fn
+	}
+}
+
+// WithOnFieldError is the option of the same name in influxmarshal. See
+// influxmarshal.WithOnFieldError.
+func WithOnFieldError(fn func(key string, err error)) Option {
+	return func(o *core.Options) {
+		o.OnError = fn
+	}
+}
+
+// WithAllErrors is the option of the same name in influxmarshal. See
+// influxmarshal.WithAllErrors.
+func WithAllErrors() Option {
+	return func(o *core.Options) {
+		o.AllErrors = true
+	}
+}
+
+// WithNameValidation is the option of the same name in influxmarshal. See
+// influxmarshal.WithNameValidation.
+func WithNameValidation() Option {
+	return func(o *core.Options) {
+		o.ValidateNames = true
+	}
+}
+
+// MaxNameSize is the length in bytes that InfluxDB allows for measurements,
+// tag keys, tag values and field keys.
+const MaxNameSize = core.MaxNameSize
+
+// SizePolicy determines how tag values longer than MaxNameSize, which
+// InfluxDB does not accept, are encoded.
+type SizePolicy = core.SizePolicy
+
+var (
+	// SizeIgnore writes long tag values and names as they are, to be
+	// rejected by InfluxDB. It is the default.
+	SizeIgnore = core.SizeIgnore
+	// SizeError makes long tag values and names an error wrapping
+	// ErrTooLong.
+	SizeError = core.SizeError
+)
+
+// SizeTruncate returns a SizePolicy truncating long tag values to
+// MaxNameSize bytes, the last of which are replaced by marker, such as "...".
+// Long measurements, tag keys and field keys are still an error, as
+// truncating them could merge distinct keys.
+func SizeTruncate(marker string) SizePolicy {
+	return core.SizeTruncate(marker)
+}
+
+// WithSizePolicy is the option of the same name in influxmarshal. See
+// influxmarshal.WithSizePolicy.
+func WithSizePolicy(p SizePolicy) Option {
+	return func(o *core.Options) {
+		o.TagSize = p
+	}
+}
+
+// WithMaxStringLength is the option of the same name in influxmarshal. See
+// influxmarshal.WithMaxStringLength.
+func WithMaxStringLength(n int, marker string) Option {
+	return func(o *core.Options) {
+		o.MaxString = n
+		o.StringMarker = marker
+	}
+}
+
+// WithExplicitStringer is the option of the same name in influxmarshal. See
+// influxmarshal.WithExplicitStringer.
+func WithExplicitStringer() Option {
+	return func(o *core.Options) {
+		o.ExplicitStringer = true
+	}
+}
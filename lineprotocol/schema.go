@@ -0,0 +1,44 @@
+package lineprotocol
+
+import "github.com/flowchartsman/influxmarshal/internal/core"
+
+// FieldType is the InfluxDB type of a field, which is fixed for each field
+// key of a measurement within a shard.
+type FieldType = core.FieldType
+
+const (
+	FieldFloat    = core.FieldFloat
+	FieldInteger  = core.FieldInteger
+	FieldUnsigned = core.FieldUnsigned
+	FieldString   = core.FieldString
+	FieldBoolean  = core.FieldBoolean
+)
+
+// SchemaConflictError is returned, with WithSchemaTracker, when a field is
+// written with a type other than the one it was first written with.
+type SchemaConflictError = core.SchemaConflictError
+
+// SchemaTracker remembers the type of each field written per measurement, so
+// that a field later written with another type, which InfluxDB rejects with
+// a partial write error, is caught when it is encoded. It is safe for
+// concurrent use.
+type SchemaTracker = core.SchemaTracker
+
+// NewSchemaTracker returns a SchemaTracker that knows no fields. A field
+// written with a conflicting type is an error, or, if onConflict is not nil,
+// is passed to onConflict and written anyway. Either way, the type a field
+// was first written with is kept.
+func NewSchemaTracker(onConflict func(err *SchemaConflictError)) *SchemaTracker {
+	return core.NewSchemaTracker(onConflict)
+}
+
+// WithSchemaTracker checks the type of each field written against those
+// remembered by s, and records the types of new fields once a point is
+// encoded without error. Passing the same SchemaTracker to each encoding, or
+// to an encoder, tracks the schema of everything written through them. Types
+// are those written according to the other options, such as WithForceFloat.
+func WithSchemaTracker(s *SchemaTracker) Option {
+	return func(o *core.Options) {
+		o.Schema = s
+	}
+}
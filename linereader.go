@@ -0,0 +1,117 @@
+package influxmarshal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// LineReader parses a stream of line protocol one line at a time, such as
+// the output of Telegraf or the body of a write request:
+//
+//	lr := influxmarshal.NewLineReader(r)
+//	for lr.Next() {
+//	    var cpu CPU
+//	    if err := lr.Scan(&cpu); err != nil {
+//	        return err
+//	    }
+//	    ...
+//	}
+//	if err := lr.Err(); err != nil {
+//	    return err
+//	}
+//
+// Blank lines and comments, which begin with "#", are skipped. Lines may be
+// of any length.
+type LineReader struct {
+	r         *bufio.Reader
+	precision time.Duration
+	line      int
+	cur       influx.Point
+	ok        bool
+	err       error
+}
+
+// LineReaderOption customizes a LineReader.
+type LineReaderOption func(*LineReader)
+
+// WithReadPrecision sets the precision of the timestamps read by a
+// LineReader, one of time.Nanosecond, time.Microsecond, time.Millisecond or
+// time.Second, matching the precision parameter of the /write endpoint. The
+// default is time.Nanosecond.
+func WithReadPrecision(d time.Duration) LineReaderOption {
+	return func(lr *LineReader) {
+		lr.precision = d
+	}
+}
+
+// NewLineReader returns a LineReader reading line protocol from r.
+func NewLineReader(r io.Reader, opts ...LineReaderOption) *LineReader {
+	lr := &LineReader{
+		r:         bufio.NewReader(r),
+		precision: time.Nanosecond,
+	}
+	for _, opt := range opts {
+		opt(lr)
+	}
+	return lr
+}
+
+// Next parses the next line, reporting whether there is one. It returns
+// false at the end of the stream or on error, which is reported by Err.
+func (lr *LineReader) Next() bool {
+	lr.ok = false
+	if lr.err != nil {
+		return false
+	}
+	for {
+		line, err := lr.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			lr.err = err
+			return false
+		}
+		if line == "" && err == io.EOF {
+			return false
+		}
+		lr.line++
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		p, perr := parseLine(strings.TrimLeft(line, " \t"), lr.precision)
+		if perr != nil {
+			lr.err = fmt.Errorf("line %d: %v", lr.line, perr)
+			return false
+		}
+		lr.cur, lr.ok = p, true
+		return true
+	}
+}
+
+// Point returns the current line as a point. Field values are int64, uint64,
+// float64, bool or string.
+func (lr *LineReader) Point() influx.Point {
+	return lr.cur
+}
+
+// Scan decodes the current line into dest, which must be a pointer to a
+// struct, as UnmarshalLine does.
+func (lr *LineReader) Scan(dest interface{}) error {
+	if !lr.ok {
+		return fmt.Errorf("Scan called without a successful call to Next")
+	}
+	if err := unmarshalPoint(lr.cur, dest); err != nil {
+		return fmt.Errorf("line %d: %v", lr.line, err)
+	}
+	return nil
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (lr *LineReader) Err() error {
+	return lr.err
+}
@@ -0,0 +1,49 @@
+package influxmarshal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineReader(t *testing.T) {
+	const in = `# a comment
+
+m,path=a\ b count=1i,text="x" 1
+  m,path=c count=2i 2
+`
+	lr := NewLineReader(strings.NewReader(in), WithReadPrecision(time.Second))
+	var got []escapeValue
+	for lr.Next() {
+		var v escapeValue
+		if err := lr.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if err := lr.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []escapeValue{
+		{Path: "a b", Count: 1, Text: "x", Time: time.Unix(1, 0)},
+		{Path: "c", Count: 2, Time: time.Unix(2, 0)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || got[i].Count != want[i].Count ||
+			got[i].Text != want[i].Text || !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("line %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLineReaderError(t *testing.T) {
+	lr := NewLineReader(strings.NewReader("m count=1i\nm count=\n"))
+	for lr.Next() {
+	}
+	if err := lr.Err(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("got %v, want an error for line 2", err)
+	}
+}
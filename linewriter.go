@@ -0,0 +1,369 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/lineprotocol"
+)
+
+// DefaultFlushBytes is the default buffer size at which a LineWriter
+// flushes.
+const DefaultFlushBytes = 64 * 1024
+
+var (
+	// ErrClosed is returned when writing to a closed writer.
+	ErrClosed = errors.New("writer is closed")
+
+	// ErrLineTooLong is returned when a single line exceeds the maximum
+	// line or batch size of a writer.
+	ErrLineTooLong = errors.New("line too long")
+)
+
+// LineWriter encodes values as line protocol and writes them to an
+// underlying io.Writer in batches. Lines are buffered until the buffer
+// reaches a size threshold or the oldest buffered line reaches a maximum
+// age, whichever comes first, and are always written whole. Each batch is
+// passed to the underlying writer in a single call to Write, and can be
+// limited in size to stay within the request limits of a server.
+//
+// If the underlying writer fails, the batch it failed on and any after it
+// remain buffered, and are retried by the next flush, so no lines are lost.
+// The error is returned by the Write, Flush or Close call that triggered the
+// flush, or, for flushes triggered by the age limit, by the next such call.
+// Until the underlying writer recovers, the buffer grows with each line
+// written.
+//
+// A LineWriter is safe for concurrent use.
+type LineWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      []byte
+	maxBytes int
+	maxAge   time.Duration
+	timer    *time.Timer
+	opts     []Option
+	err      error
+	closed   bool
+
+	lines         int
+	maxLineBytes  int
+	maxBatchBytes int
+	maxBatchLines int
+
+	gzip  bool
+	level int
+	zw    *gzip.Writer
+	zbuf  bytes.Buffer
+}
+
+// LineWriterOption customizes a LineWriter.
+type LineWriterOption func(*LineWriter)
+
+// WithFlushBytes sets the buffer size at which a LineWriter flushes. The
+// default is DefaultFlushBytes.
+func WithFlushBytes(n int) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.maxBytes = n
+	}
+}
+
+// WithFlushAge sets the maximum time a line may remain buffered before a
+// LineWriter flushes. By default, lines are only flushed by size or by an
+// explicit call to Flush.
+func WithFlushAge(d time.Duration) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.maxAge = d
+	}
+}
+
+// WithMaxLineBytes sets the maximum length of a single line, including the
+// newline. Write returns an error wrapping ErrLineTooLong for longer lines.
+func WithMaxLineBytes(n int) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.maxLineBytes = n
+	}
+}
+
+// WithMaxBatchBytes sets the maximum size of a batch passed to the
+// underlying writer, before compression. Flushes larger than this are split
+// into multiple batches at line boundaries, and lines that cannot fit in a
+// batch are rejected as by WithMaxLineBytes.
+func WithMaxBatchBytes(n int) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.maxBatchBytes = n
+	}
+}
+
+// WithMaxBatchLines sets the maximum number of lines in a batch passed to
+// the underlying writer. The LineWriter flushes whenever this many lines are
+// buffered.
+func WithMaxBatchLines(n int) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.maxBatchLines = n
+	}
+}
+
+// WithGzip causes a LineWriter to compress each batch as a separate gzip
+// stream at the given compression level, such as gzip.BestSpeed, as accepted
+// by the InfluxDB write API with "Content-Encoding: gzip". Written to a
+// file, the concatenated streams form a valid gzip file.
+func WithGzip(level int) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.gzip = true
+		lw.level = level
+	}
+}
+
+// WithEncodeOptions sets the Options used to encode each value.
+func WithEncodeOptions(opts ...Option) LineWriterOption {
+	return func(lw *LineWriter) {
+		lw.opts = append(lw.opts, opts...)
+	}
+}
+
+// NewLineWriter returns a LineWriter writing to w.
+func NewLineWriter(w io.Writer, opts ...LineWriterOption) *LineWriter {
+	lw := &LineWriter{
+		w:        w,
+		maxBytes: DefaultFlushBytes,
+	}
+	for _, opt := range opts {
+		opt(lw)
+	}
+	return lw
+}
+
+// Write encodes v as a line of line protocol, as AppendLine does, and adds it
+// to the buffer, flushing if the buffer has reached its size threshold.
+func (lw *LineWriter) Write(v interface{}, measurement string) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.closed {
+		return ErrClosed
+	}
+	if err := lw.takeErr(); err != nil {
+		return err
+	}
+
+	b, err := AppendLine(lw.buf, v, measurement, lw.opts...)
+	if err != nil {
+		return err
+	}
+	return lw.add(b)
+}
+
+// WritePoint encodes the point given by its parts as line protocol, as
+// lineprotocol.AppendMaps does, and adds it to the buffer like Write. It
+// makes a LineWriter a PointSink.
+func (lw *LineWriter) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.closed {
+		return ErrClosed
+	}
+	if err := lw.takeErr(); err != nil {
+		return err
+	}
+
+	b, err := lineprotocol.AppendMaps(lw.buf, measurement, tags, fields, t, lw.opts...)
+	if err != nil {
+		return err
+	}
+	return lw.add(b)
+}
+
+// writeLine adds an encoded line, including its newline, to the buffer.
+func (lw *LineWriter) writeLine(line []byte) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.closed {
+		return ErrClosed
+	}
+	if err := lw.takeErr(); err != nil {
+		return err
+	}
+	return lw.add(append(lw.buf, line...))
+}
+
+// add takes b, the buffer extended by a single line, as the new buffer,
+// flushing if necessary. lw.mu must be held.
+func (lw *LineWriter) add(b []byte) error {
+	wasEmpty := len(lw.buf) == 0
+	if max := lw.maxLineLen(); max > 0 && len(b)-len(lw.buf) > max {
+		return fmt.Errorf("%w: %d bytes, maximum is %d", ErrLineTooLong, len(b)-len(lw.buf), max)
+	}
+	lw.buf = b
+	lw.lines++
+
+	if len(lw.buf) >= lw.maxBytes || (lw.maxBatchLines > 0 && lw.lines >= lw.maxBatchLines) {
+		return lw.flush()
+	}
+	if wasEmpty && lw.maxAge > 0 {
+		lw.startTimer()
+	}
+	return nil
+}
+
+// maxLineLen returns the length, including the newline, above which a line
+// cannot be written, or 0 if there is no limit.
+func (lw *LineWriter) maxLineLen() int {
+	max := lw.maxLineBytes
+	if lw.maxBatchBytes > 0 && (max <= 0 || lw.maxBatchBytes < max) {
+		max = lw.maxBatchBytes
+	}
+	return max
+}
+
+// Flush writes any buffered lines to the underlying writer.
+func (lw *LineWriter) Flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if err := lw.takeErr(); err != nil {
+		return err
+	}
+	return lw.flush()
+}
+
+// Close flushes any buffered lines and stops the LineWriter. It does not
+// close the underlying writer. If the lines cannot be written, Close returns
+// the error and the LineWriter remains open, so that Close may be retried.
+func (lw *LineWriter) Close() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.closed {
+		return nil
+	}
+	if err := lw.takeErr(); err != nil {
+		return err
+	}
+	if err := lw.flush(); err != nil {
+		return err
+	}
+	lw.closed = true
+	return nil
+}
+
+// startTimer arranges for the buffer to be flushed once it reaches its
+// maximum age. lw.mu must be held.
+func (lw *LineWriter) startTimer() {
+	if lw.timer == nil {
+		lw.timer = time.AfterFunc(lw.maxAge, lw.flushAged)
+		return
+	}
+	lw.timer.Reset(lw.maxAge)
+}
+
+func (lw *LineWriter) flushAged() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if err := lw.flush(); err != nil && lw.err == nil {
+		lw.err = err
+	}
+}
+
+// flush writes the buffer to the underlying writer, split into batches
+// within the configured limits. If a batch cannot be written, it and those
+// after it remain buffered to be retried by the next flush. lw.mu must be
+// held.
+func (lw *LineWriter) flush() error {
+	if lw.timer != nil {
+		lw.timer.Stop()
+	}
+
+	buf := lw.buf
+	for len(buf) > 0 {
+		n := lw.batchLen(buf)
+		if err := lw.writeBatch(buf[:n]); err != nil {
+			lw.buf = append(lw.buf[:0], buf...)
+			lw.lines = bytes.Count(lw.buf, []byte{'\n'})
+			if lw.maxAge > 0 {
+				lw.startTimer()
+			}
+			return err
+		}
+		buf = buf[n:]
+	}
+	lw.buf = lw.buf[:0]
+	lw.lines = 0
+	return nil
+}
+
+// batchLen returns the length of the longest prefix of whole lines in buf
+// that fits within the batch limits. Lines longer than the limits are
+// rejected by Write, so it is never 0.
+func (lw *LineWriter) batchLen(buf []byte) int {
+	if lw.maxBatchBytes <= 0 && lw.maxBatchLines <= 0 {
+		return len(buf)
+	}
+	end, lines := 0, 0
+	for end < len(buf) {
+		next := bytes.IndexByte(buf[end:], '\n') + end + 1
+		if end > 0 && lw.maxBatchBytes > 0 && next > lw.maxBatchBytes {
+			break
+		}
+		end = next
+		lines++
+		if lw.maxBatchLines > 0 && lines >= lw.maxBatchLines {
+			break
+		}
+	}
+	return end
+}
+
+// lineBatchWriter is implemented by underlying writers that record the
+// number of lines in each batch, which cannot be counted once it is
+// compressed.
+type lineBatchWriter interface {
+	writeLines(batch []byte, lines int) error
+}
+
+// writeBatch writes a single batch to the underlying writer, compressing it
+// if required. lw.mu must be held.
+func (lw *LineWriter) writeBatch(batch []byte) error {
+	lines := bytes.Count(batch, []byte{'\n'})
+	if !lw.gzip {
+		return lw.write(batch, lines)
+	}
+
+	lw.zbuf.Reset()
+	if lw.zw == nil {
+		zw, err := gzip.NewWriterLevel(&lw.zbuf, lw.level)
+		if err != nil {
+			return err
+		}
+		lw.zw = zw
+	} else {
+		lw.zw.Reset(&lw.zbuf)
+	}
+	if _, err := lw.zw.Write(batch); err != nil {
+		return err
+	}
+	if err := lw.zw.Close(); err != nil {
+		return err
+	}
+	return lw.write(lw.zbuf.Bytes(), lines)
+}
+
+// write passes p, holding the given number of lines, to the underlying
+// writer.
+func (lw *LineWriter) write(p []byte, lines int) error {
+	if bw, ok := lw.w.(lineBatchWriter); ok {
+		return bw.writeLines(p, lines)
+	}
+	_, err := lw.w.Write(p)
+	return err
+}
+
+// takeErr returns and clears the error from the last background flush.
+// lw.mu must be held.
+func (lw *LineWriter) takeErr() error {
+	err := lw.err
+	lw.err = nil
+	return err
+}
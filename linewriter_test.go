@@ -0,0 +1,177 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordWriter records each batch written to it, failing while err is set.
+type recordWriter struct {
+	mu      sync.Mutex
+	batches []string
+	err     error
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.batches = append(w.batches, string(p))
+	return len(p), nil
+}
+
+func (w *recordWriter) get() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.batches...)
+}
+
+type writerValue struct {
+	N int `influx:"n"`
+}
+
+// writeValues writes values with n from 0 to count-1.
+func writeValues(t *testing.T, lw *LineWriter, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if err := lw.Write(writerValue{i}, "m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func lineWriterOpts(opts ...LineWriterOption) []LineWriterOption {
+	return append(opts, WithEncodeOptions(WithTime(time.Unix(0, 1))))
+}
+
+func TestLineWriterFlushBytes(t *testing.T) {
+	w := &recordWriter{}
+	// each line is "m n=Ni 1\n", 9 bytes for single digits
+	lw := NewLineWriter(w, lineWriterOpts(WithFlushBytes(20))...)
+	writeValues(t, lw, 5)
+	if got := w.get(); len(got) != 1 || got[0] != "m n=0i 1\nm n=1i 1\nm n=2i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.get(); len(got) != 2 || got[1] != "m n=3i 1\nm n=4i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+	if err := lw.Write(writerValue{}, "m"); err != ErrClosed {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+}
+
+func TestLineWriterFlushAge(t *testing.T) {
+	w := &recordWriter{}
+	lw := NewLineWriter(w, lineWriterOpts(WithFlushAge(20*time.Millisecond))...)
+	defer lw.Close()
+	writeValues(t, lw, 2)
+	if got := w.get(); len(got) != 0 {
+		t.Fatalf("flushed early: %q", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(w.get()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("lines not flushed by age")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := w.get(); got[0] != "m n=0i 1\nm n=1i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestLineWriterBatchLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  LineWriterOption
+		want []string
+	}{
+		{
+			name: "lines",
+			opt:  WithMaxBatchLines(2),
+			want: []string{"m n=0i 1\nm n=1i 1\n", "m n=2i 1\nm n=3i 1\n", "m n=4i 1\n"},
+		},
+		{
+			name: "bytes",
+			opt:  WithMaxBatchBytes(20),
+			want: []string{"m n=0i 1\nm n=1i 1\n", "m n=2i 1\nm n=3i 1\n", "m n=4i 1\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &recordWriter{}
+			lw := NewLineWriter(w, lineWriterOpts(tt.opt)...)
+			writeValues(t, lw, 5)
+			if err := lw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.get(); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineWriterLineTooLong(t *testing.T) {
+	lw := NewLineWriter(&recordWriter{}, lineWriterOpts(WithMaxLineBytes(5))...)
+	if err := lw.Write(writerValue{1}, "m"); !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("got %v, want ErrLineTooLong", err)
+	}
+}
+
+func TestLineWriterRetainsOnError(t *testing.T) {
+	w := &recordWriter{err: errors.New("unavailable")}
+	lw := NewLineWriter(w, lineWriterOpts(WithMaxBatchLines(2), WithFlushBytes(1<<20))...)
+	writeValues(t, lw, 1)
+	if err := lw.Write(writerValue{1}, "m"); err == nil {
+		t.Fatal("expected error from failed flush")
+	}
+	if err := lw.Close(); err == nil {
+		t.Fatal("expected error from failed close")
+	}
+
+	w.mu.Lock()
+	w.err = nil
+	w.mu.Unlock()
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.get(); len(got) != 1 || got[0] != "m n=0i 1\nm n=1i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestLineWriterGzip(t *testing.T) {
+	w := &recordWriter{}
+	lw := NewLineWriter(w, lineWriterOpts(WithGzip(gzip.BestSpeed), WithMaxBatchLines(2))...)
+	writeValues(t, lw, 3)
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var all []byte
+	for _, batch := range w.get() {
+		zr, err := gzip.NewReader(strings.NewReader(batch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, b...)
+	}
+	if !bytes.Equal(all, []byte("m n=0i 1\nm n=1i 1\nm n=2i 1\n")) {
+		t.Fatalf("got %q", all)
+	}
+}
@@ -0,0 +1,86 @@
+package influxmarshal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// MarshalMerged marshals each of vs, as Marshal does, and combines their tags
+// and fields into a single point, such as to add a struct of common tags to
+// the fields of another. It is an error for more than one value to set the
+// same tag or field, or to provide different timestamps.
+//
+// If measurement is empty, it is taken from the first value that declares
+// one. Each value need not have fields of its own, but the merged point
+// must.
+func MarshalMerged(measurement string, vs ...interface{}) (influx.Point, error) {
+	var merged influx.Point
+	if measurement == "" {
+		for _, v := range vs {
+			val, err := core.StructValue(v)
+			if err != nil {
+				return merged, err
+			}
+			info, err := core.CompileType(val.Type(), core.DefaultOptions)
+			if err != nil {
+				return merged, err
+			}
+			if measurement = core.StructMeasurement(v, info); measurement != "" {
+				break
+			}
+		}
+		if measurement == "" {
+			return merged, fmt.Errorf("no measurement")
+		}
+	}
+
+	// every value shares the same default timestamp, so that those that
+	// provide their own can be told apart
+	now := time.Now()
+	o := core.NewOptions([]Option{WithTime(now)})
+
+	merged.Measurement = measurement
+	merged.Tags = make(map[string]string)
+	merged.Fields = make(map[string]interface{})
+	merged.Time = now
+	for i, v := range vs {
+		val, err := core.StructValue(v)
+		if err != nil {
+			return merged, err
+		}
+		info, err := core.CompileType(val.Type(), o)
+		if err != nil {
+			return merged, fmt.Errorf("value %d: %w", i, err)
+		}
+		p, err := marshal(v, val, info, measurement, o)
+		if err != nil && !errors.Is(err, ErrNoFields) {
+			return merged, fmt.Errorf("value %d: %w", i, err)
+		}
+		for k, tv := range p.Tags {
+			if _, ok := merged.Tags[k]; ok {
+				return merged, fmt.Errorf("value %d: tag %s is already set", i, k)
+			}
+			merged.Tags[k] = tv
+		}
+		for k, fv := range p.Fields {
+			if _, ok := merged.Fields[k]; ok {
+				return merged, fmt.Errorf("value %d: field %s is already set", i, k)
+			}
+			merged.Fields[k] = fv
+		}
+		if !p.Time.Equal(now) {
+			if !merged.Time.Equal(now) && !merged.Time.Equal(p.Time) {
+				return merged, fmt.Errorf("value %d: timestamp differs from an earlier value", i)
+			}
+			merged.Time = p.Time
+		}
+	}
+	if len(merged.Fields) == 0 {
+		return merged, ErrNoFields
+	}
+	return merged, nil
+}
@@ -0,0 +1,75 @@
+package influxmarshal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type mergeCommon struct {
+	Host   string `influx:"host,tag"`
+	Region string `influx:"region,tag"`
+}
+
+type mergeLoad struct {
+	Load float64   `influx:"load"`
+	Time time.Time `influx:",time"`
+}
+
+type mergeMem struct {
+	Measurement `influx:"mem"`
+	Used        int `influx:"used"`
+}
+
+func TestMarshalMerged(t *testing.T) {
+	at := time.Unix(100, 0)
+	p, err := MarshalMerged("system", mergeCommon{"a", "us"}, mergeLoad{0.5, at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Measurement != "system" || len(p.Tags) != 2 || p.Tags["host"] != "a" || p.Tags["region"] != "us" {
+		t.Fatalf("got %+v", p)
+	}
+	if len(p.Fields) != 1 || p.Fields["load"] != 0.5 || !p.Time.Equal(at) {
+		t.Fatalf("got %+v", p)
+	}
+
+	// the measurement comes from the first value declaring one, and the
+	// timestamp defaults to now
+	before := time.Now()
+	p, err = MarshalMerged("", mergeCommon{Host: "a"}, mergeMem{Used: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Measurement != "mem" || p.Fields["used"] != 1 || p.Time.Before(before) {
+		t.Fatalf("got %+v", p)
+	}
+}
+
+func TestMarshalMergedErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement string
+		vs          []interface{}
+		want        string
+	}{
+		{"tag", "m", []interface{}{mergeCommon{Host: "a"}, mergeCommon{Host: "b"}, mergeLoad{Load: 1}}, "value 1: tag host is already set"},
+		{"field", "m", []interface{}{mergeLoad{Load: 1}, mergeLoad{Load: 2}}, "value 1: field load is already set"},
+		{"time", "m", []interface{}{mergeLoad{1, time.Unix(1, 0)}, struct {
+			Used int       `influx:"used"`
+			Time time.Time `influx:",time"`
+		}{1, time.Unix(2, 0)}}, "value 1: timestamp differs from an earlier value"},
+		{"measurement", "", []interface{}{mergeCommon{}, mergeLoad{Load: 1}}, "no measurement"},
+		{"not struct", "m", []interface{}{mergeLoad{Load: 1}, 1}, ErrNotStruct.Error()},
+	}
+	for _, tt := range tests {
+		_, err := MarshalMerged(tt.measurement, tt.vs...)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := MarshalMerged("m", mergeCommon{Host: "a"}); !errors.Is(err, ErrNoFields) {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/flowchartsman/influxmarshal/metric
+
+go 1.21
+
+require (
+	github.com/flowchartsman/influxmarshal v0.0.0
+	github.com/influxdata/line-protocol v0.0.0-20210922203350-b1ad95c89adf
+)
+
+require github.com/influxdata/influxdb1-client v0.0.0-20220302092344-a9ab5670611c // indirect
+
+replace github.com/flowchartsman/influxmarshal => ../
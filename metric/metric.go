@@ -0,0 +1,72 @@
+// Package metric adapts marshaled structs to the Metric interface of
+// github.com/influxdata/line-protocol, so that they can be serialized by its
+// Encoder or passed to code written against it, such as Telegraf plugins. It
+// is a separate module so that only its users depend on that library.
+package metric
+
+import (
+	"sort"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+	protocol "github.com/influxdata/line-protocol"
+)
+
+// Metric is a marshaled point. It implements protocol.Metric.
+type Metric struct {
+	name   string
+	tags   []*protocol.Tag
+	fields []*protocol.Field
+	time   time.Time
+}
+
+// New marshals v as influxmarshal.MarshalWithOptions does and returns the
+// point as a Metric. Its tags and fields are sorted by key.
+func New(v interface{}, measurement string, opts ...influxmarshal.Option) (*Metric, error) {
+	m := &Metric{}
+	if err := influxmarshal.MarshalTo(m, v, measurement, opts...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WritePoint sets m to the point given by its parts, making a Metric an
+// influxmarshal.PointSink.
+func (m *Metric) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	m.name, m.time = measurement, t
+	m.tags = make([]*protocol.Tag, 0, len(tags))
+	for k, v := range tags {
+		m.tags = append(m.tags, &protocol.Tag{Key: k, Value: v})
+	}
+	sort.Slice(m.tags, func(i, j int) bool {
+		return m.tags[i].Key < m.tags[j].Key
+	})
+	m.fields = make([]*protocol.Field, 0, len(fields))
+	for k, v := range fields {
+		m.fields = append(m.fields, &protocol.Field{Key: k, Value: v})
+	}
+	sort.Slice(m.fields, func(i, j int) bool {
+		return m.fields[i].Key < m.fields[j].Key
+	})
+	return nil
+}
+
+// Name returns the measurement of the point.
+func (m *Metric) Name() string {
+	return m.name
+}
+
+// Time returns the timestamp of the point.
+func (m *Metric) Time() time.Time {
+	return m.time
+}
+
+// TagList returns the tags of the point, sorted by key.
+func (m *Metric) TagList() []*protocol.Tag {
+	return m.tags
+}
+
+// FieldList returns the fields of the point, sorted by key.
+func (m *Metric) FieldList() []*protocol.Field {
+	return m.fields
+}
@@ -0,0 +1,49 @@
+package metric
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	protocol "github.com/influxdata/line-protocol"
+)
+
+type cpu struct {
+	Region string    `influx:"region,tag"`
+	Host   string    `influx:"host,tag"`
+	Usage  float64   `influx:"usage"`
+	Count  int64     `influx:"count"`
+	Time   time.Time `influx:",time"`
+}
+
+func TestNew(t *testing.T) {
+	at := time.Unix(100, 0)
+	m, err := New(cpu{Region: "us", Host: "a", Usage: 0.5, Count: 2, Time: at}, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name() != "cpu" || !m.Time().Equal(at) {
+		t.Fatalf("got %s at %v", m.Name(), m.Time())
+	}
+	tags := m.TagList()
+	if len(tags) != 2 || tags[0].Key != "host" || tags[0].Value != "a" || tags[1].Key != "region" || tags[1].Value != "us" {
+		t.Fatalf("got tags %v", tags)
+	}
+	fields := m.FieldList()
+	if len(fields) != 2 || fields[0].Key != "count" || fields[0].Value != int64(2) || fields[1].Key != "usage" || fields[1].Value != 0.5 {
+		t.Fatalf("got fields %v", fields)
+	}
+
+	// the library encodes it as influxmarshal would
+	var buf bytes.Buffer
+	if _, err := protocol.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cpu,host=a,region=us count=2i,usage=0.5 100000000000\n"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	if _, err := New(1, "cpu"); err == nil {
+		t.Fatal("expected error for non-struct")
+	}
+}
@@ -0,0 +1,93 @@
+package influxmarshal
+
+import (
+	"errors"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// MarshalMulti marshals v into one point for each measurement it spans. A
+// member with the "measurement=NAME" option belongs to the point for the
+// measurement NAME, and an inlined struct member with the option passes it
+// on to each of its members:
+//
+//	type Host struct {
+//	    Name string    `influx:"host,tag"`
+//	    Load float64   `influx:"load"`
+//	    Used int64     `influx:"used,measurement=mem"`
+//	    Disk DiskStats `influx:"disk,inline,measurement=disk"`
+//	}
+//
+// Other fields belong to the point for measurement, or the measurement v
+// declares, which is omitted if there are no such fields. Other tags, the
+// timestamp, and any extra tags and fields given by opts are shared by every
+// point. The points are returned in the order their measurements first
+// appear in v, after the default measurement. It is an error if none of the
+// points has any fields. The methods of BeforeMarshaler and AfterMarshaler
+// are called for each point.
+//
+// Other functions ignore the "measurement" option, and encode v as a single
+// point.
+func MarshalMulti(v interface{}, measurement string, opts ...Option) ([]influx.Point, error) {
+	o := core.NewOptions(opts)
+	val, err := core.StructValue(v)
+	if err != nil {
+		return nil, err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{""}
+	seen := map[string]bool{"": true}
+	for _, fi := range info.Fields {
+		if !seen[fi.Measurement] {
+			seen[fi.Measurement] = true
+			groups = append(groups, fi.Measurement)
+		}
+	}
+
+	var points []influx.Point
+	for _, group := range groups {
+		sub := *info
+		sub.Fields = nil
+		for _, fi := range info.Fields {
+			shared := fi.Measurement == "" && (fi.Tag || fi.TagMap || fi.Time)
+			if fi.Measurement == group || shared {
+				sub.Fields = append(sub.Fields, fi)
+			}
+		}
+		name := group
+		if name == "" {
+			if !hasFields(&sub) {
+				continue
+			}
+			name = measurement
+		}
+		p, err := marshal(v, val, &sub, name, o)
+		if errors.Is(err, ErrNoFields) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	if len(points) == 0 {
+		return nil, ErrNoFields
+	}
+	return points, nil
+}
+
+// hasFields reports whether info has any members that may be encoded as
+// fields.
+func hasFields(info *core.TypeInfo) bool {
+	for _, fi := range info.Fields {
+		if !fi.Tag && !fi.TagMap && !fi.Time {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package influxmarshal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type multiDisk struct {
+	Free  int64 `influx:"free"`
+	Inode int64 `influx:"inodes"`
+}
+
+type multiHost struct {
+	Name string    `influx:"host,tag"`
+	Load float64   `influx:"load"`
+	Used int64     `influx:"used,measurement=mem"`
+	Disk multiDisk `influx:"disk,inline,measurement=disk"`
+	Time time.Time `influx:",time"`
+}
+
+func TestMarshalMulti(t *testing.T) {
+	at := time.Unix(100, 0)
+	v := multiHost{Name: "a", Load: 0.5, Used: 2, Disk: multiDisk{3, 4}, Time: at}
+	points, err := MarshalMulti(v, "cpu", WithExtraTags(map[string]string{"dc": "x"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		measurement string
+		fields      map[string]interface{}
+	}{
+		{"cpu", map[string]interface{}{"load": 0.5}},
+		{"mem", map[string]interface{}{"used": int64(2)}},
+		{"disk", map[string]interface{}{"disk_free": int64(3), "disk_inodes": int64(4)}},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("got %d points: %+v", len(points), points)
+	}
+	for i, p := range points {
+		w := want[i]
+		if p.Measurement != w.measurement || !p.Time.Equal(at) || len(p.Tags) != 2 || p.Tags["host"] != "a" || p.Tags["dc"] != "x" {
+			t.Fatalf("point %d: got %+v", i, p)
+		}
+		if len(p.Fields) != len(w.fields) {
+			t.Fatalf("point %d: got fields %v, want %v", i, p.Fields, w.fields)
+		}
+		for k, f := range w.fields {
+			if p.Fields[k] != f {
+				t.Fatalf("point %d: got fields %v, want %v", i, p.Fields, w.fields)
+			}
+		}
+	}
+}
+
+func TestMarshalMultiWithoutDefault(t *testing.T) {
+	type split struct {
+		Host string `influx:"host,tag"`
+		Used int64  `influx:"used,measurement=mem"`
+		Free int64  `influx:"free,measurement=disk,omitzero"`
+	}
+	// without other fields there is no point for the default measurement,
+	// and a measurement whose fields are all omitted has none either
+	points, err := MarshalMulti(split{Host: "a", Used: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Measurement != "mem" || points[0].Tags["host"] != "a" {
+		t.Fatalf("got %+v", points)
+	}
+
+	type empty struct {
+		Host string `influx:"host,tag"`
+		Free int64  `influx:"free,measurement=disk,omitzero"`
+	}
+	if _, err := MarshalMulti(empty{Host: "a"}, "m"); !errors.Is(err, ErrNoFields) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := MarshalMulti(1, "m"); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("got %v", err)
+	}
+}
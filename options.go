@@ -0,0 +1,273 @@
+package influxmarshal
+
+import (
+	"context"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	"github.com/flowchartsman/influxmarshal/lineprotocol"
+)
+
+// Option customizes the behavior of MarshalWithOptions.
+type Option = core.Option
+
+// WithTime sets the timestamp used for the point when v does not provide one
+// through a "time" field. Without this option, time.Now() is used, or the
+// function given to WithClock.
+func WithTime(t time.Time) Option {
+	return lineprotocol.WithTime(t)
+}
+
+// WithClock sets the function used in place of time.Now to timestamp points
+// that have no timestamp from WithTime or v, so that tests can produce
+// deterministic timestamps.
+func WithClock(now func() time.Time) Option {
+	return lineprotocol.WithClock(now)
+}
+
+// WithExtraTags adds tags to the point in addition to those found in v. If v
+// has a tag with the same key and a non-empty value, the value from v is
+// used.
+func WithExtraTags(tags map[string]string) Option {
+	return lineprotocol.WithExtraTags(tags)
+}
+
+// WithBatchTags adds tags, such as host, region or service, to every point
+// marshaled by MarshalBatch, MarshalBatchPoints, a Batcher or a Collector.
+// It is the same as WithExtraTags: a struct member with the same key takes
+// precedence unless its value is empty.
+func WithBatchTags(tags map[string]string) Option {
+	return WithExtraTags(tags)
+}
+
+// WithExtraFields adds fields to the point in addition to those found in v. If
+// v has a field with the same key, the value from v is used. The values are
+// not checked and must be types supported by InfluxDB.
+func WithExtraFields(fields map[string]interface{}) Option {
+	return lineprotocol.WithExtraFields(fields)
+}
+
+// WithTagKey sets the struct tag key used to look up field options. The
+// default is "influx".
+func WithTagKey(key string) Option {
+	return lineprotocol.WithTagKey(key)
+}
+
+// WithSeparator sets the separator placed between the name of an inlined
+// struct field and the names of its members. The default is "_".
+func WithSeparator(sep string) Option {
+	return lineprotocol.WithSeparator(sep)
+}
+
+// KeyJoin determines how the keys of nested members, such as those of
+// inlined structs, are joined to the names they are nested in.
+type KeyJoin = lineprotocol.KeyJoin
+
+const (
+	// JoinSeparator joins keys with the separator set by WithSeparator, as
+	// in "cpu_user". It is the default.
+	JoinSeparator = lineprotocol.JoinSeparator
+	// JoinCamelCase joins keys by capitalizing the first letter of the
+	// nested key, as in "cpuUser". The separator is not used.
+	JoinCamelCase = lineprotocol.JoinCamelCase
+)
+
+// WithKeyJoin sets how the keys of the members of inlined structs, and the
+// keys made by the "flatten" and "dive" options, are joined to the name of
+// the field they belong to. The default is JoinSeparator.
+func WithKeyJoin(j KeyJoin) Option {
+	return lineprotocol.WithKeyJoin(j)
+}
+
+// WithPrecision sets the precision of line protocol timestamps, which are
+// truncated and written as a count of the given unit, e.g. time.Second.
+// It must match the precision parameter sent to the /write endpoint. For
+// points, it sets the Precision field instead. The default is
+// time.Nanosecond.
+func WithPrecision(d time.Duration) Option {
+	return lineprotocol.WithPrecision(d)
+}
+
+// WithUnsigned causes unsigned integers to be written to line protocol with
+// the "u" suffix, which InfluxDB 1.x supports only when enabled, and InfluxDB
+// 2.x and 3 support by default. Without it, unsigned integers are written as
+// signed integers with the "i" suffix, and values too large for an int64 are
+// an error.
+func WithUnsigned() Option {
+	return lineprotocol.WithUnsigned()
+}
+
+// WithForceFloat causes integer fields to be written as floats, to avoid
+// field type conflicts with existing schemas that store numbers as floats.
+// Integers beyond 2^53 lose precision.
+func WithForceFloat() Option {
+	return lineprotocol.WithForceFloat()
+}
+
+// WithSortedKeys causes fields to be written to line protocol in key order,
+// so that the output is deterministic and compresses better. Tags are always
+// written in key order. The tags and fields of a point are maps, and so have
+// no order of their own.
+func WithSortedKeys() Option {
+	return lineprotocol.WithSortedKeys()
+}
+
+// WithDuplicatePolicy sets how MarshalBatchPoints and Batcher handle points
+// with the same measurement, tag set and timestamp within a batch. The
+// default is KeepDuplicates.
+func WithDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(o *core.Options) {
+		o.Duplicates = int(p)
+	}
+}
+
+// WithParallelism causes MarshalBatch and MarshalBatchPoints to marshal the
+// elements of a slice on up to n goroutines. The order of the points is
+// preserved. It is worthwhile only for large slices.
+func WithParallelism(n int) Option {
+	return func(o *core.Options) {
+		o.Parallelism = n
+	}
+}
+
+// WithUnsafeStrings avoids copying byte slices when converting them to
+// strings: the tag and field values of a point made from []byte members share
+// their memory, as does the result of MarshalLine with its encoding buffer.
+// The caller must not modify such members while the point is in use. Line
+// protocol appenders never copy byte slices.
+func WithUnsafeStrings() Option {
+	return lineprotocol.WithUnsafeStrings()
+}
+
+// NaNPolicy determines how NaN and infinite float fields, which InfluxDB does
+// not accept, are encoded.
+type NaNPolicy = lineprotocol.NaNPolicy
+
+var (
+	// NaNError makes NaN and infinite fields an error. It is the default.
+	NaNError = lineprotocol.NaNError
+	// NaNSkip omits NaN and infinite fields.
+	NaNSkip = lineprotocol.NaNSkip
+)
+
+// NaNReplaceWith returns a NaNPolicy writing x in place of NaN and infinite
+// fields.
+func NaNReplaceWith(x float64) NaNPolicy {
+	return lineprotocol.NaNReplaceWith(x)
+}
+
+// WithNaNPolicy sets how NaN and infinite float fields are encoded, except
+// those of members with the "omitnan" option, which are always omitted, and
+// extra fields, which are not checked. The default is NaNError.
+func WithNaNPolicy(p NaNPolicy) Option {
+	return lineprotocol.WithNaNPolicy(p)
+}
+
+// WithMaxDepth limits how deeply structs may be nested through the "inline"
+// and "prefix" options and embedding, so that a deep or mistaken hierarchy
+// is reported as an error when the type is first encoded. The default is 16.
+// A struct inlined within itself is always an error.
+func WithMaxDepth(n int) Option {
+	return lineprotocol.WithMaxDepth(n)
+}
+
+// SkipReason is the reason a member was omitted from a point, as reported to
+// the function given to WithOnFieldSkipped.
+type SkipReason = lineprotocol.SkipReason
+
+const (
+	// SkipNil is for a nil pointer or interface, a SQL NULL, or a nil value
+	// with the "omitempty" option.
+	SkipNil = lineprotocol.SkipNil
+	// SkipZero is for a zero value with the "omitzero" option.
+	SkipZero = lineprotocol.SkipZero
+	// SkipEmpty is for an empty value with the "omitempty" option, or an
+	// empty tag value, which line protocol cannot represent.
+	SkipEmpty = lineprotocol.SkipEmpty
+	// SkipNaN is for a NaN or infinite float omitted by the "omitnan" option
+	// or the NaN policy.
+	SkipNaN = lineprotocol.SkipNaN
+)
+
+// WithOnFieldSkipped sets a function called with the key of each member
+// omitted from a point and the reason, so that collectors can report data
+// that would otherwise be dropped silently. It is called synchronously
+// during encoding, and must be safe for concurrent use if the options are
+// shared, such as by an Encoder.
+func WithOnFieldSkipped(fn func(key string, reason SkipReason)) Option {
+	return lineprotocol.WithOnFieldSkipped(fn)
+}
+
+// WithOnFieldError sets a function called with the key of a member that
+// fails to encode and the error, which is also returned as usual. It is
+// called synchronously, like the function given to WithOnFieldSkipped.
+func WithOnFieldError(fn func(key string, err error)) Option {
+	return lineprotocol.WithOnFieldError(fn)
+}
+
+// WithContextTags sets a function extracting tags from the context given to
+// MarshalContext, such as a tenant or trace ID carried by a request, so that
+// they need not be threaded through every struct. Other functions ignore it.
+func WithContextTags(fn func(ctx context.Context) map[string]string) Option {
+	return func(o *core.Options) {
+		o.ContextTags = fn
+	}
+}
+
+// WithAllErrors causes encoding to continue past a member that fails, so
+// that the error returned joins those of every member, as by errors.Join,
+// rather than reporting only the first. No point is produced either way.
+func WithAllErrors() Option {
+	return lineprotocol.WithAllErrors()
+}
+
+// WithNameValidation causes a measurement, tag key or field key that
+// InfluxDB reserves to be an error wrapping ErrReservedName, rather than
+// failing only when the point is written. Names that contain line breaks are
+// then an error wrapping ErrLineBreak for points as well as line protocol.
+func WithNameValidation() Option {
+	return lineprotocol.WithNameValidation()
+}
+
+// SizePolicy determines how tag values longer than MaxNameSize, which
+// InfluxDB does not accept, are encoded.
+type SizePolicy = lineprotocol.SizePolicy
+
+var (
+	// SizeIgnore writes long tag values and names as they are, to be
+	// rejected by InfluxDB. It is the default.
+	SizeIgnore = lineprotocol.SizeIgnore
+	// SizeError makes long tag values and names an error wrapping
+	// ErrTooLong.
+	SizeError = lineprotocol.SizeError
+)
+
+// SizeTruncate returns a SizePolicy truncating long tag values to
+// MaxNameSize bytes, the last of which are replaced by marker, such as "...".
+// Long measurements, tag keys and field keys are still an error, as
+// truncating them could merge distinct keys.
+func SizeTruncate(marker string) SizePolicy {
+	return lineprotocol.SizeTruncate(marker)
+}
+
+// WithSizePolicy sets how tag values, and the measurement and keys, longer
+// than InfluxDB allows are encoded, so that a runaway value is caught before
+// it reaches the database. The default is SizeIgnore.
+func WithSizePolicy(p SizePolicy) Option {
+	return lineprotocol.WithSizePolicy(p)
+}
+
+// WithMaxStringLength truncates string fields longer than n bytes, such as
+// log messages that found their way into metrics, to n bytes, the last of
+// which are replaced by marker, such as "...". UTF-8 sequences are not split,
+// so a truncated value may be shorter. Escaping is not counted.
+func WithMaxStringLength(n int, marker string) Option {
+	return lineprotocol.WithMaxStringLength(n, marker)
+}
+
+// WithExplicitStringer causes fmt.Stringer to be used only for members with
+// the "string" option, so that a type with a String method meant for
+// debugging is not silently written as a string.
+func WithExplicitStringer() Option {
+	return lineprotocol.WithExplicitStringer()
+}
@@ -0,0 +1,41 @@
+package influxmarshal
+
+import (
+	"sync"
+
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+var pointPool = sync.Pool{
+	New: func() interface{} {
+		return &influx.Point{
+			Tags:   make(map[string]string),
+			Fields: make(map[string]interface{}),
+		}
+	},
+}
+
+// AcquirePoint returns an empty point from a pool, for use with MarshalInto.
+// It should be returned with ReleasePoint once it is no longer needed.
+func AcquirePoint() *influx.Point {
+	return pointPool.Get().(*influx.Point)
+}
+
+// ReleasePoint clears p and returns it to the pool used by AcquirePoint. p
+// must not be used afterwards.
+func ReleasePoint(p *influx.Point) {
+	for k := range p.Tags {
+		delete(p.Tags, k)
+	}
+	for k := range p.Fields {
+		delete(p.Fields, k)
+	}
+	if p.Tags == nil {
+		p.Tags = make(map[string]string)
+	}
+	if p.Fields == nil {
+		p.Fields = make(map[string]interface{})
+	}
+	*p = influx.Point{Tags: p.Tags, Fields: p.Fields}
+	pointPool.Put(p)
+}
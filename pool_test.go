@@ -0,0 +1,74 @@
+package influxmarshal
+
+import (
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+type pooledCPU struct {
+	Host  string  `influx:"host,tag"`
+	Usage float64 `influx:"usage"`
+	Idle  float64 `influx:"idle,omitzero"`
+}
+
+func TestMarshalInto(t *testing.T) {
+	p := AcquirePoint()
+	defer ReleasePoint(p)
+	if p.Measurement != "" || len(p.Tags) != 0 || len(p.Fields) != 0 {
+		t.Fatalf("acquired %+v", p)
+	}
+
+	at := time.Unix(100, 0)
+	if err := MarshalInto(p, pooledCPU{Host: "a", Usage: 1, Idle: 2}, "cpu", WithTime(at)); err != nil {
+		t.Fatal(err)
+	}
+	want, err := MarshalWithOptions(pooledCPU{Host: "a", Usage: 1, Idle: 2}, "cpu", WithTime(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Measurement != want.Measurement || !p.Time.Equal(at) || len(p.Tags) != 1 || p.Tags["host"] != "a" || len(p.Fields) != 2 || p.Fields["idle"] != 2.0 {
+		t.Fatalf("got %+v, want %+v", p, want)
+	}
+
+	// the maps are reused, but cleared of the previous point
+	tags, fields := p.Tags, p.Fields
+	if err := MarshalInto(p, &pooledCPU{Usage: 3}, "mem"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Measurement != "mem" || len(p.Tags) != 0 || len(p.Fields) != 1 || p.Fields["usage"] != 3.0 {
+		t.Fatalf("got %+v", p)
+	}
+	tags["x"] = "y"
+	fields["x"] = 1
+	if p.Tags["x"] != "y" || p.Fields["x"] != 1 {
+		t.Fatal("maps were not reused")
+	}
+
+	if err := MarshalInto(p, 1, "m"); err == nil {
+		t.Fatal("expected error for non-struct")
+	}
+}
+
+func TestReleasePoint(t *testing.T) {
+	p := AcquirePoint()
+	if err := MarshalInto(p, pooledCPU{Host: "a", Usage: 1}, "cpu", WithPrecision(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	ReleasePoint(p)
+	// nothing else holds p, so it is safe to inspect here
+	if p.Measurement != "" || !p.Time.IsZero() || p.Precision != "" || len(p.Tags) != 0 || len(p.Fields) != 0 {
+		t.Fatalf("released %+v", p)
+	}
+	if p.Tags == nil || p.Fields == nil {
+		t.Fatal("released point has nil maps")
+	}
+
+	// points built elsewhere may have nil maps
+	var q influx.Point
+	ReleasePoint(&q)
+	if q.Tags == nil || q.Fields == nil {
+		t.Fatal("released point has nil maps")
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/flowchartsman/influxmarshal/promwrite
+
+go 1.21
+
+require (
+	github.com/flowchartsman/influxmarshal v0.0.0
+	github.com/prometheus/prometheus v0.54.1
+)
+
+require (
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/influxdata/influxdb1-client v0.0.0-20220302092344-a9ab5670611c // indirect
+)
+
+replace github.com/flowchartsman/influxmarshal => ../
@@ -0,0 +1,131 @@
+// Package promwrite converts marshaled structs into Prometheus remote write
+// time series, so that the same annotated structs can feed InfluxDB or a
+// Prometheus-compatible backend. Tags become labels, and each numeric field
+// becomes a series named by the measurement and field key joined with an
+// underscore. It is a separate module so that only its users depend on the
+// Prometheus packages.
+package promwrite
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Series is an influxmarshal.PointSink collecting the series of each point
+// written to it, with a single sample each:
+//
+//	var s promwrite.Series
+//	err := influxmarshal.MarshalTo(&s, v, "cpu")
+//	req := s.WriteRequest()
+//
+// Booleans are converted to 1 or 0 and string fields are skipped. Characters
+// not allowed in metric and label names are replaced with underscores.
+type Series []prompb.TimeSeries
+
+// WritePoint appends a series for each numeric field of the point to s.
+func (s *Series) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	labels := make([]prompb.Label, 0, len(tags)+1)
+	for k, v := range tags {
+		if v != "" {
+			labels = append(labels, prompb.Label{Name: labelName(k), Value: v})
+		}
+	}
+	ts := t.UnixNano() / int64(time.Millisecond)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	n := 0
+	for _, k := range keys {
+		f, ok := floatValue(fields[k])
+		if !ok {
+			continue
+		}
+		ls := make([]prompb.Label, len(labels), len(labels)+1)
+		copy(ls, labels)
+		ls = append(ls, prompb.Label{Name: "__name__", Value: metricName(measurement + "_" + k)})
+		sort.Slice(ls, func(i, j int) bool {
+			return ls[i].Name < ls[j].Name
+		})
+		*s = append(*s, prompb.TimeSeries{
+			Labels:  ls,
+			Samples: []prompb.Sample{{Value: f, Timestamp: ts}},
+		})
+		n++
+	}
+	if n == 0 {
+		return influxmarshal.ErrNoFields
+	}
+	return nil
+}
+
+// WriteRequest returns a remote write request holding the series of s.
+func (s Series) WriteRequest() *prompb.WriteRequest {
+	return &prompb.WriteRequest{Timeseries: s}
+}
+
+// floatValue returns v as a sample value, reporting false if it is not
+// numeric or boolean.
+func floatValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// metricName replaces the characters of s not allowed in a metric name.
+func metricName(s string) string {
+	return sanitize(s, true)
+}
+
+// labelName replaces the characters of s not allowed in a label name.
+func labelName(s string) string {
+	return sanitize(s, false)
+}
+
+func sanitize(s string, colons bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', colons && r == ':':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package promwrite
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type cpu struct {
+	Host  string    `influx:"host,tag"`
+	Zone  string    `influx:"availability-zone,tag"`
+	Usage float64   `influx:"usage"`
+	Up    bool      `influx:"up"`
+	Model string    `influx:"model"`
+	Time  time.Time `influx:",time"`
+}
+
+func TestSeries(t *testing.T) {
+	var s Series
+	v := cpu{Host: "a", Zone: "us-1", Usage: 0.5, Up: true, Model: "x", Time: time.Unix(100, 5e6)}
+	if err := influxmarshal.MarshalTo(&s, v, "cpu.load"); err != nil {
+		t.Fatal(err)
+	}
+	labels := func(name string) []prompb.Label {
+		return []prompb.Label{{Name: "__name__", Value: name}, {Name: "availability_zone", Value: "us-1"}, {Name: "host", Value: "a"}}
+	}
+	want := []prompb.TimeSeries{
+		{Labels: labels("cpu_load_up"), Samples: []prompb.Sample{{Value: 1, Timestamp: 100005}}},
+		{Labels: labels("cpu_load_usage"), Samples: []prompb.Sample{{Value: 0.5, Timestamp: 100005}}},
+	}
+	if len(s) != len(want) {
+		t.Fatalf("got %+v", s)
+	}
+	for i, ts := range s {
+		if len(ts.Labels) != len(want[i].Labels) || len(ts.Samples) != 1 || ts.Samples[0] != want[i].Samples[0] {
+			t.Fatalf("series %d: got %+v, want %+v", i, ts, want[i])
+		}
+		for j, l := range ts.Labels {
+			if l != want[i].Labels[j] {
+				t.Fatalf("series %d: got %+v, want %+v", i, ts, want[i])
+			}
+		}
+	}
+	if req := s.WriteRequest(); len(req.Timeseries) != 2 {
+		t.Fatalf("got %+v", req)
+	}
+
+	// a point without numeric fields has no series
+	var empty Series
+	err := empty.WritePoint("m", nil, map[string]interface{}{"model": "x"}, time.Unix(1, 0))
+	if !errors.Is(err, influxmarshal.ErrNoFields) || len(empty) != 0 {
+		t.Fatalf("got %v, %+v", err, empty)
+	}
+}
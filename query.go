@@ -0,0 +1,62 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"strings"
+
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// Querier is the interface for running a query. It is implemented by
+// *influx.Client.
+type Querier interface {
+	Query(q influx.Query) (*influx.Response, error)
+}
+
+// QueryInto runs q with c and decodes every series of every result into
+// dest, as Unmarshal does.
+//
+// If some of the statements in q fail, the results of the others are still
+// decoded into dest and a *QueryError describing the failures is returned.
+func QueryInto(c Querier, q influx.Query, dest interface{}) error {
+	resp, err := c.Query(q)
+	if err != nil {
+		return err
+	}
+	if resp.Err != nil {
+		return resp.Err
+	}
+
+	var qerr QueryError
+	for i := range resp.Results {
+		res := &resp.Results[i]
+		if res.Err != nil {
+			qerr.Errs = append(qerr.Errs, res.Err)
+			continue
+		}
+		if err := Unmarshal(res, dest); err != nil {
+			return err
+		}
+	}
+	if len(qerr.Errs) > 0 {
+		return &qerr
+	}
+	return nil
+}
+
+// QueryError is returned by QueryInto when one or more statements in a query
+// fail. The results of the remaining statements are still decoded.
+type QueryError struct {
+	Errs []error
+}
+
+func (e *QueryError) Error() string {
+	if len(e.Errs) == 1 {
+		return fmt.Sprintf("query statement failed: %v", e.Errs[0])
+	}
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d query statements failed: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,89 @@
+package influxmarshal
+
+import (
+	"errors"
+	"testing"
+
+	influx "github.com/influxdata/influxdb1-client"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// fakeQuerier returns resp and err for any query, recording the last.
+type fakeQuerier struct {
+	resp  *influx.Response
+	err   error
+	query influx.Query
+}
+
+func (q *fakeQuerier) Query(query influx.Query) (*influx.Response, error) {
+	q.query = query
+	return q.resp, q.err
+}
+
+func hostRows(hosts ...string) []models.Row {
+	row := models.Row{Name: "cpu", Columns: []string{"host"}}
+	for _, h := range hosts {
+		row.Values = append(row.Values, []interface{}{h})
+	}
+	return []models.Row{row}
+}
+
+func TestQueryInto(t *testing.T) {
+	q := &fakeQuerier{resp: &influx.Response{Results: []influx.Result{
+		{Series: hostRows("a", "b")},
+		{Series: hostRows("c")},
+	}}}
+	var got []decodedCPU
+	query := influx.Query{Command: "SELECT host FROM cpu; SELECT host FROM cpu", Database: "db"}
+	if err := QueryInto(q, query, &got); err != nil {
+		t.Fatal(err)
+	}
+	if q.query != query {
+		t.Errorf("ran %+v", q.query)
+	}
+	if len(got) != 3 || got[0].Host != "a" || got[2].Host != "c" {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestQueryIntoErrors(t *testing.T) {
+	down := errors.New("connection refused")
+	var got []decodedCPU
+	if err := QueryInto(&fakeQuerier{err: down}, influx.Query{}, &got); err != down {
+		t.Errorf("got %v, want %v", err, down)
+	}
+	bad := errors.New("error parsing query")
+	if err := QueryInto(&fakeQuerier{resp: &influx.Response{Err: bad}}, influx.Query{}, &got); err != bad {
+		t.Errorf("got %v, want %v", err, bad)
+	}
+
+	// the results of the statements that succeed are still decoded
+	first, second := errors.New("database not found"), errors.New("retention policy not found")
+	q := &fakeQuerier{resp: &influx.Response{Results: []influx.Result{
+		{Err: first},
+		{Series: hostRows("a")},
+		{Err: second},
+	}}}
+	err := QueryInto(q, influx.Query{}, &got)
+	var qerr *QueryError
+	if !errors.As(err, &qerr) || len(qerr.Errs) != 2 || qerr.Errs[0] != first || qerr.Errs[1] != second {
+		t.Fatalf("got %v", err)
+	}
+	if want := "2 query statements failed: database not found; retention policy not found"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+	if len(got) != 1 || got[0].Host != "a" {
+		t.Errorf("got %+v", got)
+	}
+	if want := "query statement failed: database not found"; (&QueryError{Errs: qerr.Errs[:1]}).Error() != want {
+		t.Errorf("got %q, want %q", (&QueryError{Errs: qerr.Errs[:1]}).Error(), want)
+	}
+
+	// a decoding error stops the query
+	q = &fakeQuerier{resp: &influx.Response{Results: []influx.Result{
+		{Series: []models.Row{{Columns: []string{"count"}, Values: [][]interface{}{{"x"}}}}},
+	}}}
+	if err := QueryInto(q, influx.Query{}, &got); err == nil || errors.As(err, &qerr) {
+		t.Errorf("got %v", err)
+	}
+}
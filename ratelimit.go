@@ -0,0 +1,63 @@
+package influxmarshal
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter, holding up to burst tokens and
+// refilling at rate tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last call. b.mu must be held.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take takes a token if one is available, reporting whether it did.
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait takes a token, blocking until one is available.
+func (b *tokenBucket) wait() {
+	b.mu.Lock()
+	b.refill()
+	// tokens may go negative, reserving future tokens for this caller
+	b.tokens--
+	deficit := -b.tokens
+	b.mu.Unlock()
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
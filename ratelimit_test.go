@@ -0,0 +1,38 @@
+package influxmarshal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	b := newTokenBucket(100, 1)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		b.wait()
+	}
+	// the first token is available at once, the rest at 10ms intervals
+	if d := time.Since(start); d < 25*time.Millisecond {
+		t.Fatalf("4 tokens at 100/s took %v", d)
+	}
+}
+
+func TestTokenBucketTake(t *testing.T) {
+	b := newTokenBucket(1, 2)
+	if !b.take() || !b.take() {
+		t.Fatal("burst tokens not available")
+	}
+	if b.take() {
+		t.Fatal("took a token beyond the burst")
+	}
+}
+
+func TestWithRateLimitNonPositive(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		c := NewCollector("m", WithRateLimit(rate, 1))
+		if c.limit != nil {
+			t.Errorf("rate %v: limit set", rate)
+		}
+		c.Close()
+	}
+}
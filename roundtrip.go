@@ -0,0 +1,113 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+)
+
+// CheckRoundTrip marshals v to line protocol, decodes each member back from
+// the result, and reports any member whose value does not survive the trip
+// unchanged, such as a type only representable through fmt.Stringer or a
+// value that loses precision. It is intended for use in tests:
+//
+//	if err := influxmarshal.CheckRoundTrip(CPU{...}); err != nil {
+//	    t.Error(err)
+//	}
+//
+// Members with the "tags" or "fields" option are not checked. If v does not
+// round trip cleanly, the returned error is a *RoundTripError.
+func CheckRoundTrip(v interface{}) error {
+	val, err := core.StructValue(v)
+	if err != nil {
+		return err
+	}
+	// the measurement is irrelevant, but must not be empty
+	line, err := MarshalLine(v, "roundtrip")
+	if err != nil {
+		return err
+	}
+	p, err := parseLine(line, time.Nanosecond)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %v", line, err)
+	}
+
+	var rterr RoundTripError
+	info, err := core.CompileType(val.Type(), core.NewOptions(nil))
+	if err != nil {
+		return err
+	}
+	for i := range info.Fields {
+		fi := &info.Fields[i]
+		if fi.TagMap || fi.FieldMap || fi.Flatten {
+			continue
+		}
+		orig, ok := core.FieldByIndex(val, fi.Index)
+		if !ok || (orig.Kind() == reflect.Ptr && orig.IsNil()) {
+			// skipped by Marshal
+			continue
+		}
+		if orig.Kind() == reflect.Ptr {
+			orig = orig.Elem()
+		}
+
+		var (
+			src     interface{}
+			present bool
+		)
+		switch {
+		case fi.Time:
+			if orig.Interface().(time.Time).IsZero() {
+				continue
+			}
+			src, present = p.Time, true
+		case fi.Tag:
+			src, present = p.Tags[fi.Name]
+		default:
+			src, present = p.Fields[fi.Name]
+		}
+		if !present {
+			if !core.IsZero(orig) {
+				rterr.add(fi, "value %v was not encoded", orig.Interface())
+			}
+			continue
+		}
+
+		decoded := reflect.New(orig.Type()).Elem()
+		if err := defaultDecoder.setDecoded(decoded, fi, src); err != nil {
+			rterr.add(fi, "%v", err)
+			continue
+		}
+		if !roundTripEqual(orig, decoded) {
+			rterr.add(fi, "%v decoded as %v", orig.Interface(), decoded.Interface())
+		}
+	}
+	if len(rterr.Problems) > 0 {
+		return &rterr
+	}
+	return nil
+}
+
+func roundTripEqual(a, b reflect.Value) bool {
+	if a.Type() == core.TimeType {
+		return a.Interface().(time.Time).Equal(b.Interface().(time.Time))
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// RoundTripError is returned by CheckRoundTrip, describing each member that
+// did not survive encoding and decoding unchanged.
+type RoundTripError struct {
+	Problems []string
+}
+
+func (e *RoundTripError) add(fi *core.FieldInfo, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fi.GoName+": "+fmt.Sprintf(format, args...))
+}
+
+func (e *RoundTripError) Error() string {
+	return "lossy round trip: " + strings.Join(e.Problems, "; ")
+}
@@ -0,0 +1,56 @@
+package influxmarshal
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripCPU struct {
+	Host  string    `influx:"host,tag"`
+	Usage float64   `influx:"usage"`
+	Count int       `influx:"count"`
+	Up    bool      `influx:"up"`
+	Note  *string   `influx:"note"`
+	Time  time.Time `influx:",time"`
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	v := roundTripCPU{Host: "a", Usage: 0.5, Count: 3, Up: true, Time: time.Unix(1, 2)}
+	if err := CheckRoundTrip(v); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRoundTrip(&v); err != nil {
+		t.Fatal(err)
+	}
+	// zero and nil members are left out of the line without complaint
+	if err := CheckRoundTrip(roundTripCPU{Host: "a", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRoundTripLossy(t *testing.T) {
+	type lossy struct {
+		Addr  net.IP  `influx:"addr,tag"`
+		Ratio float64 `influx:"ratio,precision=1"`
+		Count int     `influx:"count"`
+	}
+	err := CheckRoundTrip(lossy{Addr: net.IPv4(10, 0, 0, 1), Ratio: 0.25, Count: 1})
+	var rterr *RoundTripError
+	if !errors.As(err, &rterr) {
+		t.Fatalf("got %v", err)
+	}
+	want := []string{"Addr: cannot decode string into net.IP", "Ratio: 0.25 decoded as 0.3"}
+	if strings.Join(rterr.Problems, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", rterr.Problems, want)
+	}
+	if want := "lossy round trip: " + strings.Join(want, "; "); err.Error() != want {
+		t.Fatalf("got %v, want %s", err, want)
+	}
+
+	if err := CheckRoundTrip(1); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("got %v", err)
+	}
+}
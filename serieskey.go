@@ -0,0 +1,53 @@
+package influxmarshal
+
+import (
+	"hash/fnv"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+)
+
+// SeriesKey returns the series key of v: its escaped measurement followed by
+// its tags in key order, exactly as they begin its line protocol, e.g.
+// "cpu,host=a,region=us". Two values belong to the same series if and only
+// if their series keys are equal, which makes the key suitable for
+// deduplication, sharding and cardinality accounting. It accepts the same
+// values and options as MarshalWithOptions.
+func SeriesKey(v interface{}, measurement string, opts ...Option) (string, error) {
+	o := core.NewOptions(opts)
+	val, err := core.StructValue(v)
+	if err != nil {
+		return "", err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return "", err
+	}
+	b, err := core.AppendSeriesKey(nil, v, val, info, measurement, o)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// SeriesKeyHash returns the 64-bit FNV-1a hash of the series key of v, as
+// returned by SeriesKey. It is stable across processes and releases, and so
+// may be used to assign series to shards.
+func SeriesKeyHash(v interface{}, measurement string, opts ...Option) (uint64, error) {
+	o := core.NewOptions(opts)
+	val, err := core.StructValue(v)
+	if err != nil {
+		return 0, err
+	}
+	info, err := core.CompileType(val.Type(), o)
+	if err != nil {
+		return 0, err
+	}
+	var buf [128]byte
+	b, err := core.AppendSeriesKey(buf[:0], v, val, info, measurement, o)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64(), nil
+}
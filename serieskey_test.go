@@ -0,0 +1,73 @@
+package influxmarshal
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+type seriesCPU struct {
+	Region string  `influx:"region,tag"`
+	Host   string  `influx:"host,tag"`
+	Usage  float64 `influx:"usage"`
+}
+
+func TestSeriesKey(t *testing.T) {
+	v := seriesCPU{Region: "us", Host: "a b", Usage: 1}
+	key, err := SeriesKey(v, "cpu load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `cpu\ load,host=a\ b,region=us`; key != want {
+		t.Fatalf("got %s, want %s", key, want)
+	}
+	line, err := MarshalLine(v, "cpu load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line[:len(key)+1] != key+" " {
+		t.Fatalf("line %s does not begin with %s", line, key)
+	}
+
+	// fields do not affect the key, but options do
+	other, err := SeriesKey(seriesCPU{Region: "us", Host: "a b", Usage: 2}, "cpu load")
+	if err != nil || other != key {
+		t.Fatalf("got %s, %v", other, err)
+	}
+	key, err = SeriesKey(&v, "cpu", WithExtraTags(map[string]string{"dc": "x"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `cpu,dc=x,host=a\ b,region=us`; key != want {
+		t.Fatalf("got %s, want %s", key, want)
+	}
+
+	if _, err := SeriesKey(v, ""); err == nil {
+		t.Fatal("expected error for missing measurement")
+	}
+	if _, err := SeriesKey(1, "cpu"); err == nil {
+		t.Fatal("expected error for non-struct")
+	}
+}
+
+func TestSeriesKeyHash(t *testing.T) {
+	v := seriesCPU{Region: "us", Host: "a", Usage: 1}
+	got, err := SeriesKeyHash(v, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hash is documented to be stable, so pin it
+	if want := uint64(0x3c0d8a8058455768); got != want {
+		t.Fatalf("got %#x, want %#x", got, want)
+	}
+	h := fnv.New64a()
+	h.Write([]byte("cpu,host=a,region=us"))
+	if got != h.Sum64() {
+		t.Fatalf("got %#x, want FNV-1a of the series key %#x", got, h.Sum64())
+	}
+	if other, _ := SeriesKeyHash(seriesCPU{Region: "eu", Host: "a"}, "cpu"); other == got {
+		t.Fatal("different series hash equally")
+	}
+	if _, err := SeriesKeyHash(v, ""); err == nil {
+		t.Fatal("expected error for missing measurement")
+	}
+}
@@ -0,0 +1,65 @@
+package influxmarshal
+
+import (
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client"
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// PointSink is the interface implemented by destinations for marshaled
+// points. It receives the parts of each point rather than a point type of
+// a particular client, so that adapters for any client, or for none, can be
+// written without this package depending on it. Adapters are provided for
+// the points of both influxdb1-client packages and, through LineWriter, for
+// line protocol; package influxdb2 provides one for the InfluxDB 2.x client.
+//
+// The maps are owned by the sink once passed to it.
+type PointSink interface {
+	WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error
+}
+
+// PointSinkFunc is an adapter to allow the use of an ordinary function as a
+// PointSink.
+type PointSinkFunc func(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error
+
+// WritePoint calls f(measurement, tags, fields, t).
+func (f PointSinkFunc) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	return f(measurement, tags, fields, t)
+}
+
+// MarshalTo marshals v as MarshalWithOptions does and passes the point to
+// sink.
+func MarshalTo(sink PointSink, v interface{}, measurement string, opts ...Option) error {
+	p, err := MarshalWithOptions(v, measurement, opts...)
+	if err != nil {
+		return err
+	}
+	return sink.WritePoint(p.Measurement, p.Tags, p.Fields, p.Time)
+}
+
+// Points is a PointSink collecting the points written to it.
+type Points []influx.Point
+
+// WritePoint appends the point to ps.
+func (ps *Points) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	*ps = append(*ps, influx.Point{
+		Measurement: measurement,
+		Tags:        tags,
+		Fields:      fields,
+		Time:        t,
+	})
+	return nil
+}
+
+// BatchPointsSink returns a PointSink adding the points written to it to bp.
+func BatchPointsSink(bp client.BatchPoints) PointSink {
+	return PointSinkFunc(func(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+		p, err := client.NewPoint(measurement, tags, fields, t)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(p)
+		return nil
+	})
+}
@@ -0,0 +1,43 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+type sinkValue struct {
+	Host  string `influx:"host,tag"`
+	Usage int    `influx:"usage"`
+}
+
+func TestMarshalTo(t *testing.T) {
+	ts := time.Unix(0, 7)
+	var ps Points
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	lw := NewLineWriter(&buf)
+
+	for _, sink := range []PointSink{&ps, BatchPointsSink(bp), lw} {
+		if err := MarshalTo(sink, sinkValue{Host: "a", Usage: 2}, "cpu", WithTime(ts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ps) != 1 || ps[0].Measurement != "cpu" || ps[0].Tags["host"] != "a" || ps[0].Fields["usage"] != 2 || !ps[0].Time.Equal(ts) {
+		t.Fatalf("got points %+v", ps)
+	}
+	if got := bp.Points(); len(got) != 1 || got[0].String() != "cpu,host=a usage=2i 7" {
+		t.Fatalf("got batch points %v", got)
+	}
+	if err := lw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "cpu,host=a usage=2i 7\n" {
+		t.Fatalf("got line %q", got)
+	}
+}
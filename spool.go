@@ -0,0 +1,210 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	spoolExt = ".batch"
+	spoolTmp = ".tmp"
+)
+
+// spool is an io.Writer that stores each write as a batch in a file of its
+// own in a directory, until it is removed once written, so that batches
+// survive a restart of the process. Files are named by sequence number, and
+// those left by a previous process are replayed first. Beyond max bytes, the
+// oldest batches are dropped.
+type spool struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	dir    string
+	max    int64
+	onDrop func(lines int)
+
+	// files holds the batches in order, the first of which is being
+	// written if busy is set
+	files  []spoolFile
+	size   int64
+	seq    uint64
+	busy   bool
+	closed bool
+}
+
+// spoolFile is a batch stored by a spool.
+type spoolFile struct {
+	name  string
+	size  int64
+	lines int
+}
+
+// openSpool returns a spool in dir, creating dir if necessary, holding the
+// batches left in it.
+func openSpool(dir string, max int64, onDrop func(lines int)) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:    dir,
+		max:    max,
+		onDrop: onDrop,
+	}
+	s.cond.L = &s.mu
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, spoolTmp) {
+			// interrupted while being stored
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		s.files = append(s.files, spoolFile{name, int64(len(b)), bytes.Count(b, []byte{'\n'})})
+		s.size += int64(len(b))
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+	// names are of equal length, so they sort in sequence
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].name < s.files[j].name
+	})
+	return s, nil
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if err := s.writeLines(p, bytes.Count(p, []byte{'\n'})); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeLines stores p as a batch, synced to disk before it is queued, and
+// drops the oldest batches not being written if the spool is then too
+// large.
+func (s *spool) writeLines(p []byte, lines int) error {
+	s.mu.Lock()
+	name := fmt.Sprintf("%020d%s", s.seq, spoolExt)
+	if err := writeFileSync(filepath.Join(s.dir, name), p); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.seq++
+	s.files = append(s.files, spoolFile{name, int64(len(p)), lines})
+	s.size += int64(len(p))
+
+	first := 0
+	if s.busy {
+		first = 1
+	}
+	var dropped []int
+	for s.max > 0 && s.size > s.max && len(s.files) > first+1 {
+		dropped = append(dropped, s.files[first].lines)
+		s.drop(first)
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	// outside the lock, in case onDrop uses s
+	for _, lines := range dropped {
+		s.onDrop(lines)
+	}
+	return nil
+}
+
+// writeFileSync writes the file name with the contents b, through a
+// temporary file, so that it is complete if it exists at all.
+func writeFileSync(name string, b []byte) error {
+	tmp := name + spoolTmp
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// next returns the oldest batch and the number of lines in it, waiting for
+// one if the spool is empty, and keeps it until it is removed. It returns nil
+// once the spool is closed and empty. A batch that cannot be read is removed
+// and reported.
+func (s *spool) next() ([]byte, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.files) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.files) == 0 {
+		return nil, 0, nil
+	}
+	lines := s.files[0].lines
+	b, err := os.ReadFile(filepath.Join(s.dir, s.files[0].name))
+	if err != nil {
+		s.drop(0)
+		return nil, lines, err
+	}
+	s.busy = true
+	return b, lines, nil
+}
+
+// count returns the number of batches in the spool, and of lines in them.
+func (s *spool) count() (batches, lines int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		lines += f.lines
+	}
+	return len(s.files), lines
+}
+
+// remove removes the batch returned by next.
+func (s *spool) remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop(0)
+	s.busy = false
+}
+
+// drop removes the batch at index i. s.mu must be held.
+func (s *spool) drop(i int) {
+	f := s.files[i]
+	os.Remove(filepath.Join(s.dir, f.name))
+	s.files = append(s.files[:i], s.files[i+1:]...)
+	s.size -= f.size
+}
+
+// close causes next to return nil once the spool is empty.
+func (s *spool) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+package influxmarshal
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// RunExec writes line protocol for the exec input plugin of Telegraf, which
+// runs a program at each interval and parses its output. It calls gather with
+// a LineWriter writing to standard output, and closes the LineWriter once
+// gather returns.
+func RunExec(gather func(w *LineWriter) error, opts ...LineWriterOption) error {
+	lw := NewLineWriter(os.Stdout, opts...)
+	if err := gather(lw); err != nil {
+		lw.Close()
+		return err
+	}
+	return lw.Close()
+}
+
+// ExecdOption customizes RunExecd.
+type ExecdOption func(*execd)
+
+type execd struct {
+	in      io.Reader
+	out     io.Writer
+	signals []os.Signal
+	lwOpts  []LineWriterOption
+}
+
+// WithExecdSignals causes RunExecd to gather metrics when the process
+// receives one of sigs, for the "SIGHUP", "SIGUSR1" and "SIGUSR2" settings of
+// the signal option of the plugin, in addition to a line on standard input.
+func WithExecdSignals(sigs ...os.Signal) ExecdOption {
+	return func(e *execd) {
+		e.signals = append(e.signals, sigs...)
+	}
+}
+
+// WithExecdIO sets the reader from which RunExecd reads requests and the
+// writer to which it writes metrics, in place of standard input and output.
+func WithExecdIO(in io.Reader, out io.Writer) ExecdOption {
+	return func(e *execd) {
+		e.in, e.out = in, out
+	}
+}
+
+// WithExecdLineWriterOptions sets the options of the LineWriter passed to the
+// gather function of RunExecd.
+func WithExecdLineWriterOptions(opts ...LineWriterOption) ExecdOption {
+	return func(e *execd) {
+		e.lwOpts = append(e.lwOpts, opts...)
+	}
+}
+
+// RunExecd serves metrics to the execd input plugin of Telegraf, which runs
+// a program continuously and requests metrics at each interval. With the
+// default "STDIN" setting of the signal option of the plugin, each request is
+// a line on standard input. For each request, RunExecd calls gather with a
+// LineWriter writing to standard output, and flushes it once gather returns.
+// Requests received while gather is running are combined into one.
+//
+// RunExecd returns nil once standard input is closed, which Telegraf does
+// when it stops, or the error of gather or the LineWriter. It returns
+// ctx.Err() if ctx is done first.
+//
+// With the "none" setting, the program writes metrics as it pleases, for
+// which a LineWriter on os.Stdout with WithFlushAge is enough.
+func RunExecd(ctx context.Context, gather func(w *LineWriter) error, opts ...ExecdOption) error {
+	e := execd{in: os.Stdin, out: os.Stdout}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	lw := NewLineWriter(e.out, e.lwOpts...)
+	defer lw.Close()
+
+	requests := make(chan struct{}, 1)
+	closed := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(e.in)
+		for sc.Scan() {
+			select {
+			case requests <- struct{}{}:
+			default:
+				// a request is already pending
+			}
+		}
+		closed <- sc.Err()
+	}()
+	var sigs chan os.Signal
+	if len(e.signals) > 0 {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, e.signals...)
+		defer signal.Stop(sigs)
+	}
+
+	serve := func() error {
+		if err := gather(lw); err != nil {
+			return err
+		}
+		return lw.Flush()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-closed:
+			// serve a request made just before
+			select {
+			case <-requests:
+				if err := serve(); err != nil {
+					return err
+				}
+			default:
+			}
+			return err
+		case <-requests:
+		case <-sigs:
+		}
+		if err := serve(); err != nil {
+			return err
+		}
+	}
+}
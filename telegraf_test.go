@@ -0,0 +1,58 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunExecd(t *testing.T) {
+	inR, inW := io.Pipe()
+	var out bytes.Buffer
+	served := make(chan struct{})
+	n := 0
+	gather := func(w *LineWriter) error {
+		n++
+		err := w.Write(sinkValue{Host: "a", Usage: n}, "cpu")
+		served <- struct{}{}
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- RunExecd(context.Background(), gather,
+			WithExecdIO(inR, &out),
+			WithExecdLineWriterOptions(WithEncodeOptions(WithTime(time.Unix(0, 1)))),
+		)
+	}()
+	for i := 0; i < 2; i++ {
+		io.WriteString(inW, "\n")
+		<-served
+	}
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := "cpu,host=a usage=1i 1\ncpu,host=a usage=2i 1\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunExecdError(t *testing.T) {
+	errGather := errors.New("gather failed")
+	err := RunExecd(context.Background(), func(*LineWriter) error { return errGather },
+		WithExecdIO(strings.NewReader("\n"), io.Discard))
+	if !errors.Is(err, errGather) {
+		t.Fatalf("got %v, want %v", err, errGather)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inR, _ := io.Pipe()
+	if err := RunExecd(ctx, func(*LineWriter) error { return nil }, WithExecdIO(inR, io.Discard)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
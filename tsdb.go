@@ -0,0 +1,252 @@
+package influxmarshal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphiteWriter is a PointSink writing points in the Graphite plaintext
+// protocol, so that structs marshaled for InfluxDB can also be sent to
+// Graphite. Each numeric field becomes a series named by the measurement and
+// field key joined with a dot, with the tags of the point in the tagged
+// format of Graphite 1.1:
+//
+//	cpu.usage;host=a 0.5 1136214245
+//
+// Booleans are written as 1 or 0 and string fields are skipped. Characters
+// with a meaning in the protocol are replaced with underscores. Timestamps
+// are written in seconds.
+//
+// A GraphiteWriter is safe for concurrent use.
+type GraphiteWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewGraphiteWriter returns a GraphiteWriter writing to w, such as a TCP
+// connection to a Graphite server.
+func NewGraphiteWriter(w io.Writer) *GraphiteWriter {
+	return &GraphiteWriter{w: w}
+}
+
+// WritePoint writes a line for each numeric field of the point to the
+// underlying writer, in a single call to Write.
+func (g *GraphiteWriter) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	values, err := numericFields(measurement, fields)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	keys := sortedKeys(tags)
+	b := g.buf[:0]
+	for _, f := range values {
+		b = append(b, graphiteName(measurement)...)
+		b = append(b, '.')
+		b = append(b, graphiteName(f.key)...)
+		for _, k := range keys {
+			if tags[k] == "" {
+				continue
+			}
+			b = append(b, ';')
+			b = append(b, graphiteName(k)...)
+			b = append(b, '=')
+			b = append(b, graphiteName(tags[k])...)
+		}
+		b = append(b, ' ')
+		b = append(b, f.value...)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, t.Unix(), 10)
+		b = append(b, '\n')
+	}
+	g.buf = b
+	_, err = g.w.Write(b)
+	return err
+}
+
+var graphiteReplacer = strings.NewReplacer(" ", "_", ";", "_", "=", "_", "\n", "_", "\r", "_", "\t", "_")
+
+// graphiteName replaces the characters of s that cannot appear in a Graphite
+// path or tag.
+func graphiteName(s string) string {
+	return graphiteReplacer.Replace(s)
+}
+
+// OpenTSDBWriter is a PointSink writing points for OpenTSDB, either as telnet
+// style put commands or, with JSON, as one JSON data point per line for its
+// HTTP API. Each numeric field becomes a metric named by the measurement and
+// field key joined with a dot:
+//
+//	put cpu.usage 1136214245000 0.5 host=a
+//
+// OpenTSDB requires every data point to have at least one tag. Booleans are
+// written as 1 or 0 and string fields are skipped. Characters not allowed in
+// metric names and tags are replaced with underscores. Timestamps are
+// written in milliseconds.
+//
+// An OpenTSDBWriter is safe for concurrent use.
+type OpenTSDBWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+	buf  []byte
+}
+
+// NewOpenTSDBWriter returns an OpenTSDBWriter writing put commands to w, or
+// JSON data points if asJSON is set.
+func NewOpenTSDBWriter(w io.Writer, asJSON bool) *OpenTSDBWriter {
+	return &OpenTSDBWriter{w: w, json: asJSON}
+}
+
+// openTSDBPoint is a data point of the OpenTSDB HTTP API.
+type openTSDBPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     json.Number       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// WritePoint writes a data point for each numeric field of the point to the
+// underlying writer, in a single call to Write.
+func (o *OpenTSDBWriter) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	values, err := numericFields(measurement, fields)
+	if err != nil {
+		return err
+	}
+	clean := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			clean[openTSDBName(k)] = openTSDBName(v)
+		}
+	}
+	if len(clean) == 0 {
+		return fmt.Errorf("point %s has no tags, which OpenTSDB requires", measurement)
+	}
+	keys := sortedKeys(clean)
+	ts := t.UnixNano() / int64(time.Millisecond)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.buf[:0]
+	for _, f := range values {
+		metric := openTSDBName(measurement) + "." + openTSDBName(f.key)
+		if o.json {
+			p, err := json.Marshal(openTSDBPoint{metric, ts, json.Number(f.value), clean})
+			if err != nil {
+				return err
+			}
+			b = append(b, p...)
+			b = append(b, '\n')
+			continue
+		}
+		b = append(b, "put "...)
+		b = append(b, metric...)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, ts, 10)
+		b = append(b, ' ')
+		b = append(b, f.value...)
+		for _, k := range keys {
+			b = append(b, ' ')
+			b = append(b, k...)
+			b = append(b, '=')
+			b = append(b, clean[k]...)
+		}
+		b = append(b, '\n')
+	}
+	o.buf = b
+	_, err = o.w.Write(b)
+	return err
+}
+
+// openTSDBName replaces the characters of s that are not allowed in OpenTSDB
+// metric names and tags.
+func openTSDBName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.', r == '/', r > 0x7f:
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// numericField is a field value formatted as a number.
+type numericField struct {
+	key   string
+	value string
+}
+
+// numericFields returns the numeric and boolean fields of a point, formatted
+// as numbers and sorted by key. It is an error if there are none.
+func numericFields(measurement string, fields map[string]interface{}) ([]numericField, error) {
+	values := make([]numericField, 0, len(fields))
+	for _, k := range sortedKeys(fields) {
+		var s string
+		switch v := fields[k].(type) {
+		case int:
+			s = strconv.FormatInt(int64(v), 10)
+		case int8:
+			s = strconv.FormatInt(int64(v), 10)
+		case int16:
+			s = strconv.FormatInt(int64(v), 10)
+		case int32:
+			s = strconv.FormatInt(int64(v), 10)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case uint:
+			s = strconv.FormatUint(uint64(v), 10)
+		case uint8:
+			s = strconv.FormatUint(uint64(v), 10)
+		case uint16:
+			s = strconv.FormatUint(uint64(v), 10)
+		case uint32:
+			s = strconv.FormatUint(uint64(v), 10)
+		case uint64:
+			s = strconv.FormatUint(v, 10)
+		case float32:
+			s = formatFloat(float64(v), 32)
+		case float64:
+			s = formatFloat(v, 64)
+		case bool:
+			s = "0"
+			if v {
+				s = "1"
+			}
+		}
+		if s != "" {
+			values = append(values, numericField{k, s})
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("point %s: %w", measurement, ErrNoFields)
+	}
+	return values, nil
+}
+
+// formatFloat formats f without an exponent, or returns "" for NaN and
+// infinities, which cannot be written.
+func formatFloat(f float64, bits int) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, bits)
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
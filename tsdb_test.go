@@ -0,0 +1,55 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type tsdbValue struct {
+	Host  string  `influx:"host,tag"`
+	Usage float64 `influx:"usage"`
+	Up    bool    `influx:"up"`
+	Note  string  `influx:"note"`
+}
+
+func TestGraphiteWriter(t *testing.T) {
+	var buf bytes.Buffer
+	g := NewGraphiteWriter(&buf)
+	v := tsdbValue{Host: "a b", Usage: 0.5, Up: true, Note: "skipped"}
+	if err := MarshalTo(g, v, "cpu", WithTime(time.Unix(100, 0))); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cpu.up;host=a_b 1 100\ncpu.usage;host=a_b 0.5 100\n"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+	if err := g.WritePoint("m", nil, map[string]interface{}{"s": "x"}, time.Now()); !errors.Is(err, ErrNoFields) {
+		t.Fatalf("got error %v, want ErrNoFields", err)
+	}
+}
+
+func TestOpenTSDBWriter(t *testing.T) {
+	v := tsdbValue{Host: "a:b", Usage: 2}
+	var buf bytes.Buffer
+	if err := MarshalTo(NewOpenTSDBWriter(&buf, false), v, "cpu", WithTime(time.Unix(1, 0))); err != nil {
+		t.Fatal(err)
+	}
+	if want := "put cpu.up 1000 0 host=a_b\nput cpu.usage 1000 2 host=a_b\n"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := MarshalTo(NewOpenTSDBWriter(&buf, true), v, "cpu", WithTime(time.Unix(1, 0))); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"metric":"cpu.up","timestamp":1000,"value":0,"tags":{"host":"a_b"}}` + "\n" +
+		`{"metric":"cpu.usage","timestamp":1000,"value":2,"tags":{"host":"a_b"}}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	if err := MarshalTo(NewOpenTSDBWriter(&buf, false), tsdbValue{Usage: 1}, "cpu"); err == nil {
+		t.Fatal("expected error for point without tags")
+	}
+}
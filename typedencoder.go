@@ -0,0 +1,132 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+	influx "github.com/influxdata/influxdb1-client"
+)
+
+// TypedEncoder is like Encoder, but is parameterized by the type it encodes,
+// T, which must be a struct or a pointer to a struct. As the type of each
+// value is known at compile time, it is never checked, and values are not
+// passed as interface{}. Using a pointer type for T avoids copying each value
+// to the heap, so that AppendPoint does not allocate under the conditions
+// described for the package-level AppendPoint.
+//
+// A TypedEncoder is safe for concurrent use.
+type TypedEncoder[T any] struct {
+	measurement string
+	info        *core.TypeInfo
+	opts        *core.Options
+	ptr         bool
+	// methods is set if the struct type itself, rather than a pointer to it,
+	// implements an interface checked on values
+	methods bool
+}
+
+// NewTypedEncoder returns a TypedEncoder for values of type T, encoded into
+// the given measurement, or, if measurement is empty, the measurement each
+// value declares. The options apply to every value encoded.
+func NewTypedEncoder[T any](measurement string, opts ...Option) (*TypedEncoder[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	o := core.NewOptions(opts)
+	info, err := core.CompileType(t, o)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedEncoder[T]{
+		measurement: measurement,
+		info:        info,
+		opts:        o,
+		ptr:         ptr,
+		methods:     hasMethods(t),
+	}, nil
+}
+
+var afterMarshalerType = reflect.TypeOf((*AfterMarshaler)(nil)).Elem()
+
+// hasMethods reports whether t implements Measurementer, Timestamper,
+// InfluxTagger, BeforeMarshaler or AfterMarshaler.
+func hasMethods(t reflect.Type) bool {
+	for _, it := range []reflect.Type{core.MeasurementerType, core.TimestamperType, core.InfluxTaggerType, core.BeforeMarshalerType, afterMarshalerType} {
+		if t.Implements(it) {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the struct value of v, and v as an interface for the
+// checks of the interfaces listed by hasMethods. For pointer types, neither
+// allocates.
+func (e *TypedEncoder[T]) value(v T) (interface{}, reflect.Value, error) {
+	if !e.ptr {
+		return structValueOf(v, e.methods)
+	}
+	iv := interface{}(v)
+	val := reflect.ValueOf(iv)
+	if val.IsNil() {
+		return nil, val, fmt.Errorf("value is nil")
+	}
+	return iv, val.Elem(), nil
+}
+
+// structValueOf returns v as an interface, if methods is set, and the
+// addressable value of a copy of v. The interface holds v itself, so that
+// only the methods Marshal would find on v are used, and is otherwise nil to
+// avoid boxing it. It is separate from value so that only struct types pay
+// for the copy escaping to the heap.
+func structValueOf[T any](v T, methods bool) (interface{}, reflect.Value, error) {
+	var iv interface{}
+	if methods {
+		iv = v
+	}
+	p := &v
+	return iv, reflect.ValueOf(p).Elem(), nil
+}
+
+// RegisterConverter registers fn to convert members of type t, as described
+// for Encoder.RegisterConverter. Converters must be registered before the
+// TypedEncoder is used.
+func (e *TypedEncoder[T]) RegisterConverter(t reflect.Type, fn func(v interface{}) (interface{}, error)) {
+	registerConverter(e.opts, t, fn)
+}
+
+// Encode returns a point for v, as Marshal does.
+func (e *TypedEncoder[T]) Encode(v T) (influx.Point, error) {
+	iv, val, err := e.value(v)
+	if err != nil {
+		return influx.Point{}, err
+	}
+	return marshal(iv, val, e.info, e.measurement, e.opts)
+}
+
+// AppendLine appends the line protocol representation of v to dst, as the
+// package-level AppendLine does.
+func (e *TypedEncoder[T]) AppendLine(dst []byte, v T) ([]byte, error) {
+	return e.AppendPoint(dst, v, e.opts.Now())
+}
+
+// AppendPoint appends the line protocol representation of v to dst, as the
+// package-level AppendPoint does.
+func (e *TypedEncoder[T]) AppendPoint(dst []byte, v T, t time.Time) ([]byte, error) {
+	iv, val, err := e.value(v)
+	if err != nil {
+		return dst, err
+	}
+	b, err := core.AppendStruct(dst, iv, val, e.info, e.measurement, t, e.opts)
+	if err != nil {
+		return dst, err
+	}
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,122 @@
+package influxmarshal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type ptrMethods struct {
+	Value int `influx:"value"`
+}
+
+func (*ptrMethods) Measurement() string  { return "ptr" }
+func (*ptrMethods) Timestamp() time.Time { return time.Unix(1, 0) }
+
+type valueMethods struct {
+	Value int `influx:"value"`
+}
+
+func (valueMethods) Measurement() string  { return "value" }
+func (valueMethods) Timestamp() time.Time { return time.Unix(2, 0) }
+
+func TestTypedEncoderMethodSets(t *testing.T) {
+	at := WithTime(time.Unix(3, 0))
+
+	// pointer methods are not in the method set of a struct value
+	e, err := NewTypedEncoder[ptrMethods]("m", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Encode(ptrMethods{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := MarshalWithOptions(ptrMethods{1}, "m", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Measurement != want.Measurement || !got.Time.Equal(want.Time) || !got.Time.Equal(time.Unix(3, 0)) {
+		t.Fatalf("got %s at %v, Marshal gives %s at %v", got.Measurement, got.Time, want.Measurement, want.Time)
+	}
+
+	ev, err := NewTypedEncoder[valueMethods]("", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ev.Encode(valueMethods{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Measurement != "value" || !got.Time.Equal(time.Unix(2, 0)) {
+		t.Fatalf("got %s at %v", got.Measurement, got.Time)
+	}
+
+	ep, err := NewTypedEncoder[*ptrMethods]("", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := ep.AppendLine(nil, &ptrMethods{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "ptr value=1i 1000000000\n" {
+		t.Fatalf("got %q", line)
+	}
+}
+
+func TestTypedEncoderAllocs(t *testing.T) {
+	e, err := NewTypedEncoder[*ptrMethods]("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &ptrMethods{1}
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = e.AppendPoint(buf[:0], v, time.Unix(0, 1))
+	})
+	if allocs != 0 {
+		t.Fatalf("AppendPoint made %v allocations", allocs)
+	}
+}
+
+type valueTagger struct {
+	Value int `influx:"value"`
+}
+
+func (valueTagger) InfluxTags() map[string]string { return map[string]string{"kind": "value"} }
+
+func TestTypedEncoderTagger(t *testing.T) {
+	e, err := NewTypedEncoder[valueTagger]("m", WithTime(time.Unix(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := e.AppendLine(nil, valueTagger{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "m,kind=value value=1i 1\n" {
+		t.Fatalf("got %q", line)
+	}
+}
+
+type valueHooks struct {
+	Value int `influx:"value"`
+}
+
+func (v valueHooks) BeforeInfluxMarshal() error {
+	if v.Value < 0 {
+		return errors.New("negative value")
+	}
+	return nil
+}
+
+func TestTypedEncoderHooks(t *testing.T) {
+	e, err := NewTypedEncoder[valueHooks]("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Encode(valueHooks{-1}); err == nil {
+		t.Fatal("expected error from BeforeInfluxMarshal")
+	}
+}
@@ -0,0 +1,70 @@
+package influxmarshal
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// DefaultUDPPayloadBytes is the default maximum size of a datagram sent by a
+// UDPWriter: an Ethernet MTU of 1500 bytes, less the IPv4 and UDP headers, so
+// that datagrams are not fragmented.
+const DefaultUDPPayloadBytes = 1500 - 20 - 8
+
+// UDPWriter encodes values as line protocol and sends them in datagrams to
+// the UDP listener of an InfluxDB server. It is a LineWriter whose batches
+// are limited to DefaultUDPPayloadBytes, unless WithMaxBatchBytes is given,
+// and flushed as soon as they are full, so that each batch fits in a single
+// datagram.
+//
+// UDP offers no delivery guarantee, so datagrams that cannot be sent are
+// dropped rather than retried, and counted by SendErrors.
+type UDPWriter struct {
+	// errors is first to keep it 64-bit aligned for atomic access
+	errors uint64
+
+	*LineWriter
+	conn net.Conn
+}
+
+// NewUDPWriter returns a UDPWriter sending to addr, such as
+// "localhost:8089".
+func NewUDPWriter(addr string, opts ...LineWriterOption) (*UDPWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	w := &UDPWriter{conn: conn}
+	opts = append([]LineWriterOption{
+		WithFlushBytes(DefaultUDPPayloadBytes),
+		WithMaxBatchBytes(DefaultUDPPayloadBytes),
+	}, opts...)
+	w.LineWriter = NewLineWriter(udpSender{w}, opts...)
+	return w, nil
+}
+
+// SendErrors returns the number of datagrams that could not be sent.
+func (w *UDPWriter) SendErrors() uint64 {
+	return atomic.LoadUint64(&w.errors)
+}
+
+// Close flushes any buffered lines and closes the connection.
+func (w *UDPWriter) Close() error {
+	err := w.LineWriter.Close()
+	if cerr := w.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// udpSender sends each batch written to it as a datagram, counting failures
+// instead of returning them.
+type udpSender struct {
+	w *UDPWriter
+}
+
+func (s udpSender) Write(p []byte) (int, error) {
+	if _, err := s.w.conn.Write(p); err != nil {
+		atomic.AddUint64(&s.w.errors, 1)
+	}
+	return len(p), nil
+}
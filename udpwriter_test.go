@@ -0,0 +1,50 @@
+package influxmarshal
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPWriter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w, err := NewUDPWriter(pc.LocalAddr().String(),
+		WithMaxBatchBytes(64),
+		WithEncodeOptions(WithTime(time.Unix(0, 1))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10
+	for i := 0; i < n; i++ {
+		if err := w.Write(sinkValue{Host: "host", Usage: i}, "cpu"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for lines < n {
+		m, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %d lines: %v", lines, err)
+		}
+		if m > 64 || buf[m-1] != '\n' {
+			t.Fatalf("datagram of %d bytes: %q", m, buf[:m])
+		}
+		lines += strings.Count(string(buf[:m]), "\n")
+	}
+	if w.SendErrors() != 0 {
+		t.Fatalf("got %d send errors", w.SendErrors())
+	}
+}
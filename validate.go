@@ -0,0 +1,35 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+)
+
+// Validate checks that values of the type of v can be encoded with the given
+// options, without encoding v: that its struct tags are valid, its members
+// are of supported types and its keys are unique, and that its measurement
+// name and keys are not reserved, as by WithNameValidation. v may be a
+// struct, a pointer to a struct, or the reflect.Type of either, as for
+// NewEncoder, so that it can be used in tests or at startup.
+//
+// The errors of every member are returned joined, as by errors.Join. The
+// keys of members with the "tags" or "fields" options, and values only known
+// from an interface or a converter, cannot be checked.
+func Validate(v interface{}, opts ...Option) error {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if t == nil {
+		return fmt.Errorf("value is nil")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	return core.ValidateType(t, core.NewOptions(opts))
+}
@@ -0,0 +1,98 @@
+package influxmarshal
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flowchartsman/influxmarshal/internal/core"
+)
+
+// WriteAPI identifies the HTTP API used to write line protocol to a server.
+type WriteAPI int
+
+const (
+	// WriteAPIv1 is the /write endpoint of InfluxDB 1.x, which writes to a
+	// database and, optionally, a retention policy.
+	WriteAPIv1 WriteAPI = iota
+	// WriteAPIv2 is the /api/v2/write endpoint of InfluxDB 2.x, which
+	// writes to a bucket of an organization.
+	WriteAPIv2
+	// WriteAPIv3 is the v2-compatible /api/v2/write endpoint of InfluxDB 3,
+	// which writes to a database named as the bucket and has no
+	// organizations.
+	WriteAPIv3
+)
+
+// WriteTarget describes where line protocol is written on a server.
+//
+// Line protocol for InfluxDB 2.x and 3 may contain unsigned integers, which
+// InfluxDB 1.x accepts only when enabled, so encode it with WithUnsigned to
+// preserve them. Such values are written as signed integers otherwise.
+type WriteTarget struct {
+	API WriteAPI
+	// Database is the database written to, or the bucket for WriteAPIv2
+	Database string
+	// RetentionPolicy is the retention policy written to with WriteAPIv1,
+	// or the default retention policy of the database if empty
+	RetentionPolicy string
+	// Org is the organization of the bucket with WriteAPIv2
+	Org string
+	// Precision is the precision of the timestamps, as set by
+	// WithPrecision. The default is time.Nanosecond.
+	Precision time.Duration
+}
+
+// URL returns the URL of the write endpoint of t on the server at base, such
+// as "http://localhost:8086".
+func (t WriteTarget) URL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if t.Database == "" {
+		return "", fmt.Errorf("no database")
+	}
+	precision := t.Precision
+	if precision == 0 {
+		precision = time.Nanosecond
+	}
+	p := core.PrecisionString(precision)
+	if p == "" {
+		return "", fmt.Errorf("unsupported precision %s", precision)
+	}
+
+	q := url.Values{}
+	var path string
+	switch t.API {
+	case WriteAPIv1:
+		path = "/write"
+		q.Set("db", t.Database)
+		if t.RetentionPolicy != "" {
+			q.Set("rp", t.RetentionPolicy)
+		}
+	case WriteAPIv2, WriteAPIv3:
+		if precision > time.Second {
+			return "", fmt.Errorf("unsupported precision %s", precision)
+		}
+		path = "/api/v2/write"
+		q.Set("bucket", t.Database)
+		if t.API == WriteAPIv2 {
+			if t.Org == "" {
+				return "", fmt.Errorf("no organization")
+			}
+			q.Set("org", t.Org)
+		}
+		// the v2 API names nanoseconds and microseconds in full
+		if len(p) == 1 && p != "s" {
+			p += "s"
+		}
+	default:
+		return "", fmt.Errorf("unknown write API %d", t.API)
+	}
+	q.Set("precision", p)
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,33 @@
+package influxmarshal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteTargetURL(t *testing.T) {
+	tests := []struct {
+		target WriteTarget
+		want   string
+	}{
+		{WriteTarget{Database: "db", RetentionPolicy: "rp"}, "http://h:8086/write?db=db&precision=n&rp=rp"},
+		{WriteTarget{Database: "db", Precision: time.Minute}, "http://h:8086/write?db=db&precision=m"},
+		{WriteTarget{API: WriteAPIv2, Database: "b", Org: "o", Precision: time.Microsecond}, "http://h:8086/api/v2/write?bucket=b&org=o&precision=us"},
+		{WriteTarget{API: WriteAPIv3, Database: "db", Precision: time.Second}, "http://h:8086/api/v2/write?bucket=db&precision=s"},
+		{WriteTarget{API: WriteAPIv3, Database: "db", Precision: time.Hour}, ""},
+		{WriteTarget{API: WriteAPIv2, Database: "b"}, ""},
+		{WriteTarget{}, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.target.URL("http://h:8086/")
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%+v: got %s, want error", tt.target, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%+v: got %s, %v, want %s", tt.target, got, err, tt.want)
+		}
+	}
+}
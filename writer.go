@@ -0,0 +1,564 @@
+package influxmarshal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default settings of a Writer. Batches are retried as often as by an
+// HTTPWriter, with the same bounds on the backoff.
+const (
+	DefaultFlushInterval = 10 * time.Second
+	DefaultBatchSize     = 5000
+	DefaultJitter        = 0.2
+)
+
+// Writer encodes values as line protocol and writes them in batches to an
+// underlying io.Writer, such as an HTTPWriter, from a background goroutine,
+// so that Write does not wait for the server:
+//
+//	w := influxmarshal.NewWriter(hw,
+//	    influxmarshal.WithFlushInterval(10*time.Second),
+//	    influxmarshal.WithBatchSize(5000),
+//	)
+//	...
+//	err := w.Write(v) // from any goroutine
+//	...
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	err := w.Close(ctx)
+//
+// A batch is written once it holds the number of lines set by WithBatchSize,
+// or once its first line has waited for the interval set by
+// WithFlushInterval. One full batch is held while another is written, after
+// which Write blocks until the underlying writer catches up.
+//
+// A batch that fails with an error that is Retryable, such as a timeout or a
+// 503 Service Unavailable, is retried with exponential backoff, as set by
+// WithWriterRetry, while the batches after it wait. A batch that fails
+// otherwise, or too many times, is dropped, and the error reported. An
+// HTTPWriter retries batches itself, so the attempts of the two multiply:
+// with the defaults of both, a batch is sent up to 36 times, six times by the
+// HTTPWriter for each of the six attempts of the Writer. Create the
+// HTTPWriter with WithRetry(0, 0, 0) to leave retries to the Writer.
+//
+// With WithSpool, batches are instead stored on disk until they are written,
+// so that they survive an extended outage of the server or a restart of the
+// process, and Write does not block. A batch is then retried until it is
+// written or the context given to Close is done, and those not yet written
+// are written by the next Writer using the spool.
+//
+// Flush and Close report the lines that could not be written, or were not
+// written before their context was done, with an *UndeliveredError.
+//
+// A Writer is safe for concurrent use.
+type Writer struct {
+	// dropped is first to keep it 64-bit aligned for atomic access
+	dropped uint64
+
+	w           io.Writer
+	measurement string
+	interval    time.Duration
+	size        int
+	opts        []Option
+	onError     func(err error)
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+
+	spoolDir string
+	spoolMax int64
+
+	lw    *LineWriter
+	queue *batchQueue
+	spool *spool
+	// ctx is canceled when the Writer is halted, interrupting the batch
+	// being written
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu sync.Mutex
+	// queued and finished count the batches queued, including those left
+	// in the spool, and those written or given up on since, and pending
+	// the lines of the difference
+	queued   int
+	finished int
+	pending  int
+	// lost counts the lines of the batches given up on, and progress is
+	// closed and replaced as each batch is finished
+	lost     int
+	progress chan struct{}
+	// err is the first error of a batch, last the latest, and stopErr the
+	// reason the Writer was stopped before its batches were written
+	err     error
+	last    error
+	stopErr error
+}
+
+// ErrSpoolFull is the cause reported for lines dropped from the spool set by
+// WithSpool for exceeding its size.
+var ErrSpoolFull = errors.New("spool is full")
+
+// UndeliveredError is returned by the Flush and Close methods of a Writer
+// when lines could not be written to the underlying writer.
+type UndeliveredError struct {
+	// Lines is the number of lines not written, each of which is a point
+	Lines int
+	// Err is the error of a batch that could not be written, or of the
+	// context if it was done before the batches were written
+	Err error
+}
+
+func (e *UndeliveredError) Error() string {
+	return fmt.Sprintf("%d lines not delivered: %v", e.Lines, e.Err)
+}
+
+func (e *UndeliveredError) Unwrap() error {
+	return e.Err
+}
+
+// WriterOption customizes a Writer.
+type WriterOption func(*Writer)
+
+// WithFlushInterval sets the longest time a line may wait in a Writer before
+// its batch is written. The default is DefaultFlushInterval.
+func WithFlushInterval(d time.Duration) WriterOption {
+	return func(w *Writer) {
+		w.interval = d
+	}
+}
+
+// WithBatchSize sets the number of lines in a full batch of a Writer. The
+// default is DefaultBatchSize, as recommended by InfluxDB.
+func WithBatchSize(n int) WriterOption {
+	return func(w *Writer) {
+		w.size = n
+	}
+}
+
+// WithWriterMeasurement sets the measurement of the values written to a
+// Writer. By default, each value declares its own.
+func WithWriterMeasurement(measurement string) WriterOption {
+	return func(w *Writer) {
+		w.measurement = measurement
+	}
+}
+
+// WithWriterEncodeOptions sets the Options used to encode each value written
+// to a Writer.
+func WithWriterEncodeOptions(opts ...Option) WriterOption {
+	return func(w *Writer) {
+		w.opts = append(w.opts, opts...)
+	}
+}
+
+// WithWriterErrorFunc sets a function to be called with the error of each
+// batch that cannot be written, as it happens, usually from the background
+// goroutine. The lines of those batches are reported by Flush and Close either
+// way.
+func WithWriterErrorFunc(f func(err error)) WriterOption {
+	return func(w *Writer) {
+		w.onError = f
+	}
+}
+
+// WithWriterRetry sets the number of times a Writer retries a batch, the
+// bounds of the exponential backoff between attempts, and the jitter, the
+// fraction by which each backoff is randomly lengthened or shortened so that
+// many writers do not retry in step. A maxRetries of zero disables retries.
+// The defaults are DefaultMaxRetries, DefaultMinBackoff, DefaultMaxBackoff
+// and DefaultJitter.
+func WithWriterRetry(maxRetries int, minBackoff, maxBackoff time.Duration, jitter float64) WriterOption {
+	return func(w *Writer) {
+		w.maxRetries = maxRetries
+		w.minBackoff = minBackoff
+		w.maxBackoff = maxBackoff
+		w.jitter = jitter
+	}
+}
+
+// WithSpool causes a Writer to store its batches in files in dir, which is
+// created if necessary, until they are written, and to write any batches left
+// there by a previous Writer first. dir must not be used by another Writer at
+// the same time. If the batches take more than maxBytes, the oldest are
+// dropped, and their lines counted by Dropped, and reported by Flush and
+// Close with ErrSpoolFull. A maxBytes of zero or less
+// removes the limit.
+func WithSpool(dir string, maxBytes int64) WriterOption {
+	return func(w *Writer) {
+		w.spoolDir = dir
+		w.spoolMax = maxBytes
+	}
+}
+
+// NewWriter returns a Writer writing batches to w, and starts its background
+// goroutine, which runs until the Writer is closed. It returns an error if
+// the spool set by WithSpool cannot be opened.
+func NewWriter(w io.Writer, opts ...WriterOption) (*Writer, error) {
+	wr := &Writer{
+		w:        w,
+		interval: DefaultFlushInterval,
+		size:     DefaultBatchSize,
+		done:     make(chan struct{}),
+		progress: make(chan struct{}),
+
+		maxRetries: DefaultMaxRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		jitter:     DefaultJitter,
+	}
+	for _, opt := range opts {
+		opt(wr)
+	}
+	if wr.size < 1 {
+		wr.size = 1
+	}
+	wr.ctx, wr.cancel = context.WithCancel(context.Background())
+	if wr.spoolDir != "" {
+		s, err := openSpool(wr.spoolDir, wr.spoolMax, func(lines int) {
+			atomic.AddUint64(&wr.dropped, uint64(lines))
+			wr.setErr(ErrSpoolFull)
+			wr.finish(lines, ErrSpoolFull)
+		})
+		if err != nil {
+			return nil, err
+		}
+		wr.spool = s
+		wr.queued, wr.pending = s.count()
+	} else {
+		// with no room, the queue holds the single batch it always accepts
+		wr.queue = newBatchQueue(0, BlockOnOverflow, "", nil)
+	}
+	wr.lw = NewLineWriter(writerQueue{wr},
+		WithFlushBytes(math.MaxInt),
+		WithMaxBatchLines(wr.size),
+		WithFlushAge(wr.interval),
+		WithEncodeOptions(wr.opts...),
+	)
+	go wr.run()
+	return wr, nil
+}
+
+// Write encodes v as a line of line protocol, as AppendLine does, and adds it
+// to the current batch. It returns ErrClosed if the Writer has been closed.
+func (w *Writer) Write(v interface{}) error {
+	return w.lw.Write(v, w.measurement)
+}
+
+// Flush writes the current batch, and waits until it and the batches before
+// it have been written, or ctx is done. It returns an *UndeliveredError if
+// lines were given up on since Flush was called, or are still waiting to be
+// written when ctx is done, which does not stop them being written later.
+func (w *Writer) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	lost := w.lost
+	w.mu.Unlock()
+	if err := await(ctx, w.lw.Flush); err != nil {
+		return w.undelivered(lost, err)
+	}
+	w.mu.Lock()
+	target := w.queued
+	w.mu.Unlock()
+	if err := w.wait(ctx, target); err != nil {
+		return w.undelivered(lost, err)
+	}
+	return w.undelivered(lost, nil)
+}
+
+// Close writes the lines not yet written, waits for the background goroutine
+// to write every batch, and stops it. If ctx is done first, the batch being
+// written is interrupted if the underlying writer takes a context, as an
+// HTTPWriter does, and Close returns without waiting for it. The batches not
+// yet written are then given up on, or, with a spool, left in it. Close
+// returns an *UndeliveredError if any lines were given up on since the Writer
+// was created, or are left unwritten. It does not close the underlying
+// writer.
+func (w *Writer) Close(ctx context.Context) error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- w.lw.Close()
+	}()
+	select {
+	case err := <-ch:
+		w.setErr(err)
+	case <-ctx.Done():
+		// the queue is closed, so the last batch is given up on at once
+		w.halt(ctx.Err())
+		w.setErr(<-ch)
+	}
+	if w.spool != nil {
+		w.spool.close()
+	} else {
+		w.queue.close()
+	}
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		w.halt(ctx.Err())
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// pending holds the batches left in the spool, or still being written
+	lines := w.lost + w.pending
+	err := w.err
+	if err == nil {
+		err = w.stopErr
+	}
+	if lines == 0 {
+		return nil
+	}
+	return &UndeliveredError{Lines: lines, Err: err}
+}
+
+// await calls fn, which passes the current batch to the queue or spool,
+// returning early if ctx is done, in which case fn continues until there is
+// room for the batch.
+func await(ctx context.Context, fn func() error) error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait waits until target batches have been finished, or ctx is done.
+func (w *Writer) wait(ctx context.Context, target int) error {
+	for {
+		w.mu.Lock()
+		finished, progress := w.finished, w.progress
+		w.mu.Unlock()
+		if finished >= target {
+			return nil
+		}
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// undelivered returns an *UndeliveredError for the lines given up on beyond
+// lost, and those still pending if ctx ended with err, or nil if there are
+// none.
+func (w *Writer) undelivered(lost int, err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := w.lost - lost
+	if err != nil {
+		lines += w.pending
+	} else {
+		err = w.last
+	}
+	if lines == 0 {
+		return nil
+	}
+	return &UndeliveredError{Lines: lines, Err: err}
+}
+
+// halt stops the Writer from writing further batches, for the reason err.
+// The batch being written is interrupted if possible, and, without a spool,
+// the queue is closed, so that the batches not yet written are given up on.
+func (w *Writer) halt(err error) {
+	w.stopOnce.Do(func() {
+		w.mu.Lock()
+		w.stopErr = err
+		w.mu.Unlock()
+		w.cancel()
+		if w.queue != nil {
+			w.queue.close()
+		}
+	})
+}
+
+// halted reports whether the Writer has been halted.
+func (w *Writer) halted() bool {
+	return w.ctx.Err() != nil
+}
+
+// Dropped returns the number of lines dropped from the spool set by
+// WithSpool for exceeding its size.
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// run writes the batches of the queue or spool to the underlying writer
+// until it is closed and empty, or, for the spool, until the Writer is
+// halted. Once halted, the batches of the queue are given up on.
+func (w *Writer) run() {
+	defer close(w.done)
+	for {
+		b, lines, err := w.next()
+		if b == nil && err == nil {
+			return
+		}
+		if err == nil {
+			if w.halted() {
+				if w.spool != nil {
+					return
+				}
+				err = w.stopReason()
+			} else {
+				err = w.write(b)
+			}
+			if w.spool != nil {
+				if err != nil && w.halted() {
+					// kept for the next Writer
+					w.setErr(err)
+					return
+				}
+				w.spool.remove()
+			}
+		}
+		w.setErr(err)
+		w.finish(lines, err)
+	}
+}
+
+// next returns the next batch to write and the number of lines in it, as
+// batchQueue.next does.
+func (w *Writer) next() ([]byte, int, error) {
+	if w.spool != nil {
+		return w.spool.next()
+	}
+	b, err := w.queue.next()
+	return b, bytes.Count(b, []byte{'\n'}), err
+}
+
+// finish records that a batch of the given number of lines has been written,
+// or given up on because of err.
+func (w *Writer) finish(lines int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished++
+	w.pending -= lines
+	if err != nil {
+		w.lost += lines
+	}
+	close(w.progress)
+	w.progress = make(chan struct{})
+}
+
+// contextWriter is implemented by underlying writers, such as HTTPWriter,
+// whose writes can be interrupted.
+type contextWriter interface {
+	WriteContext(ctx context.Context, batch []byte) error
+}
+
+// write writes the batch b to the underlying writer, retrying while the
+// error is Retryable, as many times as allowed, or, with a spool, until the
+// Writer is halted.
+func (w *Writer) write(b []byte) error {
+	for attempt := 0; ; attempt++ {
+		var err error
+		if cw, ok := w.w.(contextWriter); ok {
+			err = cw.WriteContext(w.ctx, b)
+		} else {
+			_, err = w.w.Write(b)
+		}
+		if err != nil && w.halted() && errors.Is(err, context.Canceled) {
+			// interrupted by halt
+			return w.stopReason()
+		}
+		if err == nil || !Retryable(err) || (w.spool == nil && attempt >= w.maxRetries) {
+			return err
+		}
+		t := time.NewTimer(w.backoff(attempt))
+		select {
+		case <-w.ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// stopReason returns the error the Writer was halted with.
+func (w *Writer) stopReason() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stopErr
+}
+
+// backoff returns the time to wait before the retry following attempt, with
+// jitter.
+func (w *Writer) backoff(attempt int) time.Duration {
+	d := backoff(attempt, w.minBackoff, w.maxBackoff)
+	return d + time.Duration(float64(d)*w.jitter*(2*rand.Float64()-1))
+}
+
+// setErr reports err, if it is not nil.
+func (w *Writer) setErr(err error) {
+	if err == nil {
+		return
+	}
+	if w.onError != nil {
+		w.onError(err)
+	}
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.last = err
+	w.mu.Unlock()
+}
+
+// writerQueue is the underlying writer of the LineWriter of a Writer, which
+// passes each batch to the queue or spool of the Writer and counts it.
+type writerQueue struct {
+	w *Writer
+}
+
+func (q writerQueue) Write(p []byte) (int, error) {
+	if err := q.writeLines(p, bytes.Count(p, []byte{'\n'})); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (q writerQueue) writeLines(p []byte, lines int) error {
+	w := q.w
+	// counted first, as the batch may be finished as soon as it is queued
+	w.mu.Lock()
+	w.queued++
+	w.pending += lines
+	w.mu.Unlock()
+	var err error
+	if w.spool != nil {
+		err = w.spool.writeLines(p, lines)
+	} else {
+		err = w.queue.writeLines(p, lines)
+		if err != nil && w.halted() {
+			// given up on, like the batches in the queue
+			err = w.stopReason()
+			w.setErr(err)
+			w.finish(lines, err)
+			return nil
+		}
+	}
+	if err != nil {
+		w.mu.Lock()
+		w.queued--
+		w.pending -= lines
+		w.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,325 @@
+package influxmarshal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newWriter returns a Writer writing values with n to the measurement "m".
+func newWriter(t *testing.T, w io.Writer, opts ...WriterOption) *Writer {
+	t.Helper()
+	opts = append(opts, WithWriterMeasurement("m"), WithWriterEncodeOptions(WithTime(time.Unix(0, 1))))
+	wr, err := NewWriter(w, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wr
+}
+
+func TestWriterBatchSize(t *testing.T) {
+	rw := &recordWriter{}
+	w := newWriter(t, rw, WithBatchSize(2), WithFlushInterval(time.Hour))
+	for i := 0; i < 5; i++ {
+		if err := w.Write(writerValue{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"m n=0i 1\nm n=1i 1\n", "m n=2i 1\nm n=3i 1\n", "m n=4i 1\n"}
+	got := rw.get()
+	if len(got) != len(want) {
+		t.Fatalf("got %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("batch %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+	if err := w.Write(writerValue{5}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	rw := &recordWriter{}
+	w := newWriter(t, rw, WithFlushInterval(20*time.Millisecond))
+	defer w.Close(context.Background())
+	if err := w.Write(writerValue{0}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(rw.get()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("lines not written by interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := rw.get(); got[0] != "m n=0i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	fail := errors.New("down")
+	rw := &recordWriter{err: fail}
+	w := newWriter(t, rw)
+	if err := w.Write(writerValue{0}); err != nil {
+		t.Fatal(err)
+	}
+	err := w.Close(context.Background())
+	var ue *UndeliveredError
+	if !errors.As(err, &ue) || ue.Lines != 1 || !errors.Is(err, fail) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// flakyWriter fails with err the first fails times it is written to.
+type flakyWriter struct {
+	recordWriter
+	fails    int
+	attempts int
+	fail     error
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.attempts++
+	if w.attempts <= w.fails {
+		w.mu.Unlock()
+		return 0, w.fail
+	}
+	w.mu.Unlock()
+	return w.recordWriter.Write(p)
+}
+
+func TestWriterRetry(t *testing.T) {
+	unavailable := &HTTPError{StatusCode: http.StatusServiceUnavailable}
+	fw := &flakyWriter{fails: 2, fail: unavailable}
+	w := newWriter(t, fw, WithWriterRetry(3, time.Millisecond, 2*time.Millisecond, 0.5))
+	if err := w.Write(writerValue{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fw.get(); fw.attempts != 3 || len(got) != 1 || got[0] != "m n=0i 1\n" {
+		t.Fatalf("got %q after %d attempts", got, fw.attempts)
+	}
+
+	// too many failures
+	fw = &flakyWriter{fails: 5, fail: unavailable}
+	w = newWriter(t, fw, WithWriterRetry(1, time.Millisecond, time.Millisecond, 0))
+	w.Write(writerValue{0})
+	if err := w.Close(context.Background()); !errors.Is(err, unavailable) || fw.attempts != 2 {
+		t.Fatalf("got %v after %d attempts", err, fw.attempts)
+	}
+
+	// permanent failures are not retried
+	conflict := &HTTPError{StatusCode: http.StatusBadRequest}
+	fw = &flakyWriter{fails: 1, fail: conflict}
+	w = newWriter(t, fw, WithWriterRetry(3, time.Millisecond, time.Millisecond, 0))
+	w.Write(writerValue{0})
+	if err := w.Close(context.Background()); !errors.Is(err, conflict) || fw.attempts != 1 || len(fw.get()) != 0 {
+		t.Fatalf("got %v after %d attempts", err, fw.attempts)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{&HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("write: %w", &HTTPError{StatusCode: http.StatusTooManyRequests}), true},
+		{&HTTPError{StatusCode: http.StatusBadRequest}, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{errors.New("disk full"), false},
+	} {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWriterSpool(t *testing.T) {
+	dir := t.TempDir()
+	down := &flakyWriter{fails: 1 << 30, fail: &HTTPError{StatusCode: http.StatusServiceUnavailable}}
+	w := newWriter(t, down, WithSpool(dir, 0), WithBatchSize(2), WithWriterRetry(0, time.Millisecond, time.Millisecond, 0))
+	for i := 0; i < 5; i++ {
+		if err := w.Write(writerValue{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the batches are kept once the deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := w.Close(ctx)
+	var ue *UndeliveredError
+	if !errors.As(err, &ue) || ue.Lines != 5 || !Retryable(err) {
+		t.Fatalf("got %v", err)
+	}
+
+	// the batches are written in order once the server is back
+	rw := &recordWriter{}
+	w = newWriter(t, rw, WithSpool(dir, 0), WithBatchSize(2))
+	if err := w.Write(writerValue{5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"m n=0i 1\nm n=1i 1\n", "m n=2i 1\nm n=3i 1\n", "m n=4i 1\n", "m n=5i 1\n"}
+	if got := rw.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left in spool", len(entries))
+	}
+}
+
+func TestWriterSpoolLimit(t *testing.T) {
+	dir := t.TempDir()
+	down := &flakyWriter{fails: 1 << 30, fail: &HTTPError{StatusCode: http.StatusServiceUnavailable}}
+	// each batch is 18 bytes, so the spool holds 3
+	w := newWriter(t, down, WithSpool(dir, 60), WithBatchSize(2), WithWriterRetry(0, time.Millisecond, time.Millisecond, 0))
+	for i := 0; i < 10; i++ {
+		if err := w.Write(writerValue{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// the lines dropped and those left in the spool are not delivered
+	err := w.Close(ctx)
+	var ue *UndeliveredError
+	if !errors.As(err, &ue) || ue.Lines != 10 || !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("got %v", err)
+	}
+	if got := w.Dropped(); got != 4 {
+		t.Errorf("dropped %d lines, want 4", got)
+	}
+
+	rw := &recordWriter{}
+	w = newWriter(t, rw, WithSpool(dir, 60))
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the first batch is kept if it was being written when the spool
+	// overflowed
+	got := rw.get()
+	if len(got) != 3 || got[1] != "m n=6i 1\nm n=7i 1\n" || got[2] != "m n=8i 1\nm n=9i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	fw := &flakyWriter{fails: 1, fail: &HTTPError{StatusCode: http.StatusServiceUnavailable}}
+	w := newWriter(t, fw, WithFlushInterval(time.Hour), WithWriterRetry(1, time.Millisecond, time.Millisecond, 0))
+	defer w.Close(context.Background())
+	for i := 0; i < 3; i++ {
+		if err := w.Write(writerValue{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := fw.get(); len(got) != 1 || got[0] != "m n=0i 1\nm n=1i 1\nm n=2i 1\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	// lines given up on before the Flush are not reported by it
+	fw.mu.Lock()
+	fw.fails = 1 << 30
+	fw.mu.Unlock()
+	w.Write(writerValue{3})
+	if err := w.Flush(ctx); !errors.Is(err, fw.fail) {
+		t.Fatalf("got %v", err)
+	}
+	w.Write(writerValue{4})
+	w.Write(writerValue{5})
+	var ue *UndeliveredError
+	if err := w.Flush(ctx); !errors.As(err, &ue) || ue.Lines != 2 {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestWriterCloseDeadline(t *testing.T) {
+	down := &flakyWriter{fails: 1 << 30, fail: &HTTPError{StatusCode: http.StatusServiceUnavailable}}
+	w := newWriter(t, down, WithBatchSize(2), WithWriterRetry(1000, 10*time.Millisecond, 10*time.Millisecond, 0))
+	for i := 0; i < 5; i++ {
+		if err := w.Write(writerValue{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := w.Close(ctx)
+	var ue *UndeliveredError
+	if !errors.As(err, &ue) || ue.Lines != 5 {
+		t.Fatalf("got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close took %v", d)
+	}
+	if len(down.get()) != 0 {
+		t.Errorf("got %q", down.get())
+	}
+}
+
+// blockingWriter blocks each write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// cancelWriter blocks each write until its context is done.
+type cancelWriter struct {
+	recordWriter
+}
+
+func (w *cancelWriter) WriteContext(ctx context.Context, p []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWriterCloseBlocked(t *testing.T) {
+	bw := &blockingWriter{release: make(chan struct{})}
+	defer close(bw.release)
+	for _, sink := range []io.Writer{bw, &cancelWriter{}} {
+		w := newWriter(t, sink, WithBatchSize(2))
+		for i := 0; i < 5; i++ {
+			if err := w.Write(writerValue{i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := w.Close(ctx)
+		cancel()
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%T: Close took %v", sink, d)
+		}
+		var ue *UndeliveredError
+		if !errors.As(err, &ue) || ue.Lines != 5 || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%T: got %v", sink, err)
+		}
+	}
+}